@@ -2,12 +2,19 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/nalgeon/codapi/internal/audit"
 	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
 	"github.com/nalgeon/codapi/internal/logx"
 	"github.com/nalgeon/codapi/internal/sandbox"
 	"github.com/nalgeon/codapi/internal/server"
@@ -41,6 +48,35 @@ func startDebug(port int) *server.Server {
 	return srv
 }
 
+// startMetrics serves the metrics.
+func startMetrics(cfg *config.Metrics) *server.Server {
+	host := cfg.Host
+	if host == "" {
+		host = "localhost"
+	}
+	logx.Log("metrics on %s:%d...", host, cfg.Port)
+	router := server.NewMetrics()
+	srv := server.NewServer(host, cfg.Port, router)
+	srv.Start()
+	return srv
+}
+
+// applyLogConfig sets up the logging according to the configuration.
+func applyLogConfig(cfg *config.Config) error {
+	logx.Verbose = cfg.Verbose
+	if cfg.Log == nil {
+		return nil
+	}
+	err := logx.SetFormat(cfg.Log.Format)
+	if err != nil {
+		return err
+	}
+	if cfg.Log.Level != "" {
+		return logx.SetLevel(cfg.Log.Level)
+	}
+	return nil
+}
+
 // listenSignals listens for termination signals
 // and performs graceful shutdown.
 func listenSignals(servers ...*server.Server) {
@@ -51,37 +87,118 @@ func listenSignals(servers ...*server.Server) {
 	for _, srv := range servers {
 		err := srv.Stop()
 		if err != nil {
-			logx.Log("failed to stop: %v", err)
+			logx.Error("failed to stop: %v", err)
+		}
+	}
+	sandbox.CloseSessions()
+	engine.StopPools()
+}
+
+// replay re-runs the executions from the audit log files
+// against a server and reports the changed results.
+// Returns the process exit code.
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:1313", "server url")
+	key := flags.String("key", "", "api key (if the server requires one)")
+	sandboxes := flags.String("sandbox", "", "comma-separated sandboxes to replay (all by default)")
+	timeout := flags.Duration("timeout", time.Minute, "request timeout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: codapi replay [flags] audit.jsonl...")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := audit.ReplayOptions{
+		URL:    *url,
+		Key:    *key,
+		Client: &http.Client{Timeout: *timeout},
+	}
+	if *sandboxes != "" {
+		opts.Sandboxes = strings.Split(*sandboxes, ",")
+	}
+
+	var sum audit.ReplaySummary
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fileSum, err := audit.Replay(ctx, file, os.Stdout, opts)
+		_ = file.Close()
+		sum.Add(fileSum)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
 		}
 	}
+	fmt.Printf("replayed %d: %d same, %d changed, %d skipped, %d failed\n",
+		sum.Total, sum.Same, sum.Changed, sum.Skipped, sum.Failed)
+	if sum.Changed > 0 || sum.Failed > 0 {
+		return 1
+	}
+	return 0
 }
 
 func main() {
+	// codapi starts itself as the init process of the process engine
+	engine.ProcessInit()
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+
 	port := flag.Int("port", 1313, "server port")
 	flag.Parse()
 
 	cfg, err := config.Read(".")
 	if err != nil {
-		logx.Log("read config: %v", err)
+		logx.Error("read config: %v", err)
+		os.Exit(1)
+	}
+
+	err = applyLogConfig(cfg)
+	if err != nil {
+		logx.Error("apply config: %v", err)
 		os.Exit(1)
 	}
 
 	err = sandbox.ApplyConfig(cfg)
 	if err != nil {
-		logx.Log("apply config: %v", err)
+		logx.Error("apply config: %v", err)
+		os.Exit(1)
+	}
+	err = server.ApplyConfig(cfg)
+	if err != nil {
+		logx.Error("apply config: %v", err)
 		os.Exit(1)
 	}
+	engine.StartPools(cfg)
 
 	srv := startServer(*port)
-	logx.Verbose = cfg.Verbose
 	logx.Log("workers: %d", cfg.PoolSize)
+	if cfg.QueueSize > 0 {
+		logx.Log("queue: %d, timeout %ds", cfg.QueueSize, cfg.QueueTimeout)
+	}
 	logx.Log("boxes: %v", cfg.BoxNames())
 	logx.Log("commands: %v", cfg.CommandNames())
+	if len(cfg.Keys) > 0 {
+		logx.Log("api keys: %d", len(cfg.Keys))
+	}
 
+	servers := []*server.Server{srv}
+	if cfg.Metrics != nil && cfg.Metrics.Port != 0 {
+		servers = append(servers, startMetrics(cfg.Metrics))
+	}
 	if cfg.Verbose {
-		debug := startDebug(6060)
-		listenSignals(srv, debug)
-	} else {
-		listenSignals(srv)
+		servers = append(servers, startDebug(6060))
 	}
+	listenSignals(servers...)
 }
@@ -1,7 +1,8 @@
 module github.com/nalgeon/codapi
 
-go 1.23.0
+go 1.24.0
 
-toolchain go1.24.0
-
-require github.com/nalgeon/be v0.1.0
+require (
+	github.com/nalgeon/be v0.1.0
+	golang.org/x/sys v0.38.0
+)
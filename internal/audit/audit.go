@@ -0,0 +1,213 @@
+// Package audit records code executions to a log file,
+// so that they can be inspected or replayed later.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+// Default audit log settings.
+const (
+	defaultMaxSize  = 100 * 1024 * 1024
+	defaultMaxFiles = 5
+)
+
+// fileMode is the permission mode of the log files. The log holds
+// user code and its output, so only the owner can read it.
+const fileMode = 0600
+
+// An Entry is a single code execution in the audit log.
+type Entry struct {
+	Time     time.Time        `json:"time"`
+	Client   string           `json:"client,omitempty"`
+	Key      string           `json:"key,omitempty"`
+	Session  string           `json:"session,omitempty"`
+	Redacted bool             `json:"redacted,omitempty"`
+	Request  engine.Request   `json:"request"`
+	Result   engine.Execution `json:"result"`
+}
+
+// A Log appends entries to a JSON lines file,
+// rotating it when it grows too large. The current file
+// is at the path, and the rotated ones are at path.1
+// (the most recent), path.2, and so on.
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	redact   bool
+	file     *os.File
+	size     int64
+}
+
+// Open opens the audit log according to the configuration.
+func Open(cfg *config.Audit) (*Log, error) {
+	l := &Log{
+		path:     cfg.Path,
+		maxSize:  defaultMaxSize,
+		maxFiles: defaultMaxFiles,
+		redact:   cfg.Redact,
+	}
+	if cfg.MaxSize > 0 {
+		l.maxSize = int64(cfg.MaxSize)
+	}
+	if cfg.MaxFiles > 0 {
+		l.maxFiles = cfg.MaxFiles
+	}
+	err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write appends the execution to the log.
+func (l *Log) Write(in engine.Request, out engine.Execution) error {
+	entry := Entry{
+		Time:    time.Now().UTC(),
+		Client:  in.Client,
+		Key:     in.Key,
+		Session: in.Session,
+		Request: in,
+		Result:  out,
+	}
+	if l.redact {
+		entry.Redacted = true
+		entry.Request = redactRequest(in)
+		entry.Result = redactResult(out)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		err = l.rotate()
+		if l.file == nil {
+			return err
+		}
+		// the rotation failed, but the current file
+		// is still open, so keep writing to it
+	}
+	n, werr := l.file.Write(data)
+	l.size += int64(n)
+	return errors.Join(err, werr)
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// open opens the current log file for appending.
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return err
+	}
+	// An existing file keeps its mode, so restrict it explicitly.
+	if err := file.Chmod(fileMode); err != nil {
+		_ = file.Close()
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files, moves the current file
+// to path.1 and opens a new one. If the rotation fails,
+// reopens the current file. The caller must hold the lock.
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		err = l.shift()
+	}
+	return errors.Join(err, l.open())
+}
+
+// shift shifts the rotated files and moves the current file
+// to path.1. The current file must be closed.
+func (l *Log) shift() error {
+	_ = os.Remove(rotatedPath(l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(rotatedPath(l.path, i), rotatedPath(l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, rotatedPath(l.path, 1))
+}
+
+// rotatedPath returns the path of the n-th rotated file.
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// redactRequest returns the request with the code
+// and the program input removed. Keeps the file names,
+// the number of arguments and the environment variable names.
+func redactRequest(in engine.Request) engine.Request {
+	in.Files = redactFiles(in.Files)
+	in.Stdin = ""
+	if in.Args != nil {
+		in.Args = make([]string, len(in.Args))
+	}
+	if in.Env != nil {
+		env := make(map[string]string, len(in.Env))
+		for name := range in.Env {
+			env[name] = ""
+		}
+		in.Env = env
+	}
+	return in
+}
+
+// redactResult returns the execution result with the output
+// removed. Keeps the output sizes and the file names.
+func redactResult(out engine.Execution) engine.Execution {
+	out.Stdout = ""
+	out.Stderr = ""
+	out.Files = redactFiles(out.Files)
+	return out
+}
+
+// redactFiles returns the files with their contents removed.
+func redactFiles(files engine.Files) engine.Files {
+	if files == nil {
+		return nil
+	}
+	redacted := make(engine.Files, len(files))
+	for name := range files {
+		redacted[name] = ""
+	}
+	return redacted
+}
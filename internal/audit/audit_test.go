@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	file, err := os.Open(path)
+	be.Err(t, err, nil)
+	defer func() { _ = file.Close() }()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		be.Err(t, err, nil)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+var request = engine.Request{
+	ID:      "python_run_42",
+	Sandbox: "python",
+	Command: "run",
+	Files:   engine.Files{"": "print('hello')"},
+	Key:     "alice",
+	Client:  "1.2.3.4",
+}
+
+var result = engine.Execution{
+	ID:       "python_run_42",
+	OK:       true,
+	ExitCode: 0,
+	Reason:   engine.ReasonExit,
+	Stdout:   "hello\n",
+}
+
+func TestLog_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := Open(&config.Audit{Path: path})
+	be.Err(t, err, nil)
+
+	err = log.Write(request, result)
+	be.Err(t, err, nil)
+	err = log.Write(request, result)
+	be.Err(t, err, nil)
+	err = log.Close()
+	be.Err(t, err, nil)
+
+	entries := readEntries(t, path)
+	be.Equal(t, len(entries), 2)
+	entry := entries[0]
+	be.True(t, !entry.Time.IsZero())
+	be.Equal(t, entry.Client, "1.2.3.4")
+	be.Equal(t, entry.Key, "alice")
+	be.Equal(t, entry.Redacted, false)
+	be.Equal(t, entry.Request.ID, "python_run_42")
+	be.Equal(t, entry.Request.Files, request.Files)
+	be.Equal(t, entry.Result.Stdout, "hello\n")
+
+	err = log.Write(request, result)
+	be.Err(t, err, os.ErrClosed)
+}
+
+func TestLog_Redact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := Open(&config.Audit{Path: path, Redact: true})
+	be.Err(t, err, nil)
+	defer func() { _ = log.Close() }()
+
+	in := request
+	in.Stdin = "secret"
+	in.Args = []string{"--token", "secret"}
+	in.Env = map[string]string{"TOKEN": "secret"}
+	out := result
+	out.Stderr = "oops"
+	out.StdoutBytes = 6
+	out.Files = engine.Files{"out.txt": "secret"}
+	err = log.Write(in, out)
+	be.Err(t, err, nil)
+	entries := readEntries(t, path)
+	be.Equal(t, len(entries), 1)
+	entry := entries[0]
+	be.True(t, entry.Redacted)
+	be.Equal(t, entry.Request.Files, engine.Files{"": ""})
+	be.Equal(t, entry.Request.Stdin, "")
+	be.Equal(t, entry.Request.Args, []string{"", ""})
+	be.Equal(t, entry.Request.Env, map[string]string{"TOKEN": ""})
+	be.Equal(t, entry.Result.Stdout, "")
+	be.Equal(t, entry.Result.Stderr, "")
+	be.Equal(t, entry.Result.StdoutBytes, int64(6))
+	be.Equal(t, entry.Result.Files, engine.Files{"out.txt": ""})
+	// the original request and result are not changed
+	be.Equal(t, in.Files[""], "print('hello')")
+	be.Equal(t, in.Env["TOKEN"], "secret")
+	be.Equal(t, out.Files["out.txt"], "secret")
+}
+
+func TestLog_Session(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := Open(&config.Audit{Path: path})
+	be.Err(t, err, nil)
+	defer func() { _ = log.Close() }()
+
+	in := request
+	in.Session = "python_session_42"
+	err = log.Write(in, result)
+	be.Err(t, err, nil)
+	entries := readEntries(t, path)
+	be.Equal(t, entries[0].Session, "python_session_42")
+}
+
+func TestLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	data, _ := json.Marshal(Entry{Request: request, Result: result})
+	// fits two entries per file
+	log, err := Open(&config.Audit{Path: path, MaxSize: 3 * len(data), MaxFiles: 2})
+	be.Err(t, err, nil)
+	defer func() { _ = log.Close() }()
+
+	for i := 0; i < 7; i++ {
+		err = log.Write(request, result)
+		be.Err(t, err, nil)
+	}
+	be.Equal(t, len(readEntries(t, path)), 1)
+	be.Equal(t, len(readEntries(t, path+".1")), 2)
+	be.Equal(t, len(readEntries(t, path+".2")), 2)
+	_, err = os.Stat(path + ".3")
+	be.True(t, os.IsNotExist(err))
+}
+
+func TestLog_RotateFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// path.1 is a non-empty directory, so the rotation fails
+	err := os.MkdirAll(filepath.Join(path+".1", "dir"), 0755)
+	be.Err(t, err, nil)
+	data, _ := json.Marshal(Entry{Request: request, Result: result})
+	log, err := Open(&config.Audit{Path: path, MaxSize: len(data), MaxFiles: 1})
+	be.Err(t, err, nil)
+	defer func() { _ = log.Close() }()
+
+	err = log.Write(request, result)
+	be.Err(t, err, nil)
+	err = log.Write(request, result)
+	be.True(t, err != nil)
+	// keeps writing to the current file
+	err = os.RemoveAll(path + ".1")
+	be.Err(t, err, nil)
+	err = log.Write(request, result)
+	be.Err(t, err, nil)
+	be.Equal(t, len(readEntries(t, path)), 1)
+	be.Equal(t, len(readEntries(t, path+".1")), 2)
+}
+
+func TestOpen_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := Open(&config.Audit{Path: path})
+	be.Err(t, err, nil)
+	_ = log.Write(request, result)
+	_ = log.Close()
+
+	log, err = Open(&config.Audit{Path: path})
+	be.Err(t, err, nil)
+	be.True(t, log.size > 0)
+	_ = log.Write(request, result)
+	_ = log.Close()
+	be.Equal(t, len(readEntries(t, path)), 2)
+}
+
+func TestOpen_Mode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	err := os.WriteFile(path, nil, 0644)
+	be.Err(t, err, nil)
+
+	log, err := Open(&config.Audit{Path: path})
+	be.Err(t, err, nil)
+	defer func() { _ = log.Close() }()
+
+	info, err := os.Stat(path)
+	be.Err(t, err, nil)
+	be.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+}
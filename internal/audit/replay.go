@@ -0,0 +1,179 @@
+// Replaying captured executions.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+// maxEntrySize is the maximum size of a single audit log line.
+const maxEntrySize = 64 * 1024 * 1024
+
+// ReplayOptions describe how to replay the audit log.
+type ReplayOptions struct {
+	// Server URL, e.g. http://localhost:1313
+	URL string
+	// API key (if the server requires one).
+	Key string
+	// Only replay the executions of these sandboxes (all if empty).
+	Sandboxes []string
+	// HTTP client to send the requests with.
+	Client *http.Client
+}
+
+// A ReplaySummary counts the replayed executions.
+type ReplaySummary struct {
+	Total   int
+	Same    int
+	Changed int
+	Skipped int
+	Failed  int
+}
+
+// Add adds the counts from the other summary.
+func (s *ReplaySummary) Add(other ReplaySummary) {
+	s.Total += other.Total
+	s.Same += other.Same
+	s.Changed += other.Changed
+	s.Skipped += other.Skipped
+	s.Failed += other.Failed
+}
+
+// Replay re-runs the executions from the audit log against the server
+// and writes the differences between the captured and the new results.
+// Skips redacted entries, session entries (which depend on the session
+// state) and the ones that were not executed (e.g. because the server was busy).
+func Replay(ctx context.Context, r io.Reader, w io.Writer, opts ReplayOptions) (ReplaySummary, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	var sum ReplaySummary
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEntrySize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		err := json.Unmarshal(line, &entry)
+		if err != nil {
+			return sum, fmt.Errorf("read entry: %w", err)
+		}
+		if len(opts.Sandboxes) > 0 && !slices.Contains(opts.Sandboxes, entry.Request.Sandbox) {
+			continue
+		}
+		sum.Total++
+		if !replayable(entry) {
+			sum.Skipped++
+			continue
+		}
+		out, err := execute(ctx, entry.Request, opts)
+		if err != nil {
+			sum.Failed++
+			fmt.Fprintf(w, "✗ %s: %v\n", entry.Request.ID, err)
+			continue
+		}
+		diff := diffResults(entry.Result, out)
+		if diff == "" {
+			sum.Same++
+			continue
+		}
+		sum.Changed++
+		fmt.Fprintf(w, "✗ %s (%s.%s):\n%s", entry.Request.ID,
+			entry.Request.Sandbox, entry.Request.Command, diff)
+	}
+	return sum, scanner.Err()
+}
+
+// replayable returns true if the entry can be replayed.
+func replayable(entry Entry) bool {
+	if entry.Redacted || entry.Session != "" {
+		return false
+	}
+	switch entry.Result.Reason {
+	case engine.ReasonBusy, engine.ReasonCanceled, engine.ReasonInternal:
+		return false
+	}
+	return true
+}
+
+// execute runs the request on the server and returns the result.
+func execute(ctx context.Context, in engine.Request, opts ReplayOptions) (engine.Execution, error) {
+	var out engine.Execution
+	in.ID = ""
+	body, err := json.Marshal(in)
+	if err != nil {
+		return out, err
+	}
+	uri := strings.TrimSuffix(opts.URL, "/") + "/v1/exec"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("content-type", "application/json")
+	if opts.Key != "" {
+		req.Header.Set("authorization", "Bearer "+opts.Key)
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return out, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if err != nil {
+		return out, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("%s: %s", resp.Status, out.Stderr)
+	}
+	return out, nil
+}
+
+// diffResults returns the differences between the captured
+// and the new execution results, or an empty string if they match.
+func diffResults(want, got engine.Execution) string {
+	var b strings.Builder
+	diffField(&b, "ok", fmt.Sprint(want.OK), fmt.Sprint(got.OK))
+	diffField(&b, "exit_code", fmt.Sprint(want.ExitCode), fmt.Sprint(got.ExitCode))
+	diffField(&b, "reason", want.Reason, got.Reason)
+	diffField(&b, "stdout", want.Stdout, got.Stdout)
+	diffField(&b, "stderr", want.Stderr, got.Stderr)
+	names := slices.Sorted(maps.Keys(want.Files))
+	for name := range maps.Keys(got.Files) {
+		if _, ok := want.Files[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		diffField(&b, "files["+name+"]", want.Files[name], got.Files[name])
+	}
+	return b.String()
+}
+
+// diffField writes the field values if they differ,
+// with the captured lines prefixed with "-"
+// and the new ones prefixed with "+".
+func diffField(b *strings.Builder, name, want, got string) {
+	if want == got {
+		return
+	}
+	fmt.Fprintf(b, "  %s:\n", name)
+	for _, line := range strings.Split(want, "\n") {
+		fmt.Fprintf(b, "  - %s\n", line)
+	}
+	for _, line := range strings.Split(got, "\n") {
+		fmt.Fprintf(b, "  + %s\n", line)
+	}
+}
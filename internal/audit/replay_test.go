@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+// newServer creates a server that prints the request stdin.
+func newServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in engine.Request
+		err := json.NewDecoder(r.Body).Decode(&in)
+		be.Err(t, err, nil)
+		be.Equal(t, in.ID, "")
+		if r.Header.Get("authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(engine.Execution{Stderr: "missing or invalid API key"})
+			return
+		}
+		out := engine.Execution{OK: true, Reason: engine.ReasonExit, Stdout: in.Stdin}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+}
+
+func entryLine(sandbox, stdin, stdout string, redacted bool) string {
+	entry := Entry{
+		Redacted: redacted,
+		Request: engine.Request{
+			ID: sandbox + "_run_42", Sandbox: sandbox, Command: "run",
+			Files: engine.Files{"": "print(input())"}, Stdin: stdin,
+		},
+		Result: engine.Execution{OK: true, Reason: engine.ReasonExit, Stdout: stdout},
+	}
+	data, _ := json.Marshal(entry)
+	return string(data) + "\n"
+}
+
+func TestReplay(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	opts := ReplayOptions{URL: srv.URL, Key: "secret"}
+
+	t.Run("same", func(t *testing.T) {
+		log := entryLine("python", "hello", "hello", false)
+		var out strings.Builder
+		sum, err := Replay(context.Background(), strings.NewReader(log), &out, opts)
+		be.Err(t, err, nil)
+		be.Equal(t, sum, ReplaySummary{Total: 1, Same: 1})
+		be.Equal(t, out.String(), "")
+	})
+	t.Run("changed", func(t *testing.T) {
+		log := entryLine("python", "hello", "hello\nworld", false)
+		var out strings.Builder
+		sum, err := Replay(context.Background(), strings.NewReader(log), &out, opts)
+		be.Err(t, err, nil)
+		be.Equal(t, sum, ReplaySummary{Total: 1, Changed: 1})
+		want := "✗ python_run_42 (python.run):\n  stdout:\n  - hello\n  - world\n  + hello\n"
+		be.Equal(t, out.String(), want)
+	})
+	t.Run("skipped", func(t *testing.T) {
+		log := entryLine("python", "hello", "hello", true) + "\n" + entryLine("go", "hi", "hi", false)
+		var out strings.Builder
+		sum, err := Replay(context.Background(), strings.NewReader(log), &out, opts)
+		be.Err(t, err, nil)
+		be.Equal(t, sum, ReplaySummary{Total: 2, Same: 1, Skipped: 1})
+	})
+	t.Run("session", func(t *testing.T) {
+		entry := Entry{
+			Session: "python_session_42",
+			Request: engine.Request{ID: "python_run_42", Sandbox: "python", Command: "run"},
+			Result:  engine.Execution{OK: true, Reason: engine.ReasonExit},
+		}
+		data, _ := json.Marshal(entry)
+		var out strings.Builder
+		sum, err := Replay(context.Background(), strings.NewReader(string(data)), &out, opts)
+		be.Err(t, err, nil)
+		be.Equal(t, sum, ReplaySummary{Total: 1, Skipped: 1})
+	})
+	t.Run("sandboxes", func(t *testing.T) {
+		log := entryLine("python", "hello", "hello", false) + entryLine("go", "hi", "hi", false)
+		opts := opts
+		opts.Sandboxes = []string{"go"}
+		var out strings.Builder
+		sum, err := Replay(context.Background(), strings.NewReader(log), &out, opts)
+		be.Err(t, err, nil)
+		be.Equal(t, sum, ReplaySummary{Total: 1, Same: 1})
+	})
+	t.Run("failed", func(t *testing.T) {
+		log := entryLine("python", "hello", "hello", false)
+		opts := opts
+		opts.Key = ""
+		var out strings.Builder
+		sum, err := Replay(context.Background(), strings.NewReader(log), &out, opts)
+		be.Err(t, err, nil)
+		be.Equal(t, sum, ReplaySummary{Total: 1, Failed: 1})
+		be.Equal(t, out.String(), "✗ python_run_42: 401 Unauthorized: missing or invalid API key\n")
+	})
+	t.Run("invalid entry", func(t *testing.T) {
+		var out strings.Builder
+		_, err := Replay(context.Background(), strings.NewReader("{"), &out, opts)
+		be.Err(t, err, "read entry")
+	})
+}
+
+func Test_diffResults(t *testing.T) {
+	want := engine.Execution{OK: true, Files: engine.Files{"a.txt": "one", "b.txt": "two"}}
+	t.Run("same", func(t *testing.T) {
+		got := engine.Execution{OK: true, Files: engine.Files{"a.txt": "one", "b.txt": "two"}}
+		be.Equal(t, diffResults(want, got), "")
+	})
+	t.Run("changed", func(t *testing.T) {
+		got := engine.Execution{OK: true, Files: engine.Files{"b.txt": "three", "c.txt": "four"}}
+		diff := "  files[a.txt]:\n  - one\n  + \n" +
+			"  files[b.txt]:\n  - two\n  + three\n" +
+			"  files[c.txt]:\n  - \n  + four\n"
+		be.Equal(t, diffResults(want, got), diff)
+	})
+}
+
+func TestReplaySummary_Add(t *testing.T) {
+	sum := ReplaySummary{Total: 2, Same: 1, Changed: 1}
+	sum.Add(ReplaySummary{Total: 3, Skipped: 1, Failed: 2})
+	be.Equal(t, sum, ReplaySummary{Total: 5, Same: 1, Changed: 1, Skipped: 1, Failed: 2})
+}
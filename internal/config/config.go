@@ -8,11 +8,41 @@ import (
 
 // A Config describes application config.
 type Config struct {
-	PoolSize int   `json:"pool_size"`
-	Verbose  bool  `json:"verbose"`
-	Box      *Box  `json:"box"`
-	Step     *Step `json:"step"`
-	HTTP     *HTTP `json:"http"`
+	PoolSize     int       `json:"pool_size"`
+	QueueSize    int       `json:"queue_size"`
+	QueueTimeout int       `json:"queue_timeout"`
+	Verbose      bool      `json:"verbose"`
+	Log          *Log      `json:"log"`
+	Box          *Box      `json:"box"`
+	Step         *Step     `json:"step"`
+	HTTP         *HTTP     `json:"http"`
+	Jobs         *Jobs     `json:"jobs"`
+	Sessions     *Sessions `json:"sessions"`
+	Metrics      *Metrics  `json:"metrics"`
+	CORS         *CORS     `json:"cors"`
+	Limits       *Limits   `json:"limits"`
+	Audit        *Audit    `json:"audit"`
+
+	// API keys (optional). If set, the API requires a valid key.
+	// The keys can also be read from a separate JSON file.
+	Keys     []*APIKey `json:"keys"`
+	KeysFile string    `json:"keys_file"`
+
+	// Per-client request rate limit (optional). Clients are identified
+	// by API key or IP address. If the server is behind a proxy,
+	// list its addresses (or networks) to use the X-Forwarded-For header.
+	RateLimit      *RateLimit `json:"rate_limit"`
+	TrustedProxies []string   `json:"trusted_proxies"`
+
+	// Docker Engine API socket for the docker-api engine
+	// (optional, /var/run/docker.sock by default).
+	DockerSocket string `json:"docker_socket"`
+
+	// Remote codapi servers for the remote engine (optional).
+	Remotes map[string]*Remote `json:"remotes"`
+
+	// Per-sandbox settings (optional).
+	Sandboxes map[string]*Sandbox `json:"sandboxes"`
 
 	// These are the available containers ("boxes").
 	Boxes map[string]*Box `json:"boxes"`
@@ -61,6 +91,7 @@ type Box struct {
 	Host
 
 	Files []string `json:"files"`
+	Pool  *Pool    `json:"pool"`
 }
 
 // A Host describes container Host attributes.
@@ -80,6 +111,34 @@ type Host struct {
 	NProc int `json:"nproc"`
 }
 
+// A Pool describes a pool of pre-started (warm) containers for a box.
+type Pool struct {
+	// The number of idle containers to keep (0 = no pool).
+	Size int `json:"size"`
+	// How long an idle container can wait for a request
+	// before being replaced with a fresh one, in seconds.
+	MaxAge int `json:"max_age"`
+	// When to start a replacement for a used container:
+	// "eager" - as soon as the container is taken from the pool (default),
+	// "lazy" - after the used container is destroyed.
+	Refill string `json:"refill"`
+	// The command that keeps an idle container running.
+	Command []string `json:"command"`
+}
+
+// A Sandbox describes sandbox-wide settings.
+type Sandbox struct {
+	// The maximum number of concurrent executions
+	// of all sandbox commands combined (0 = unlimited).
+	PoolSize int `json:"pool_size"`
+	// The default maximum number of concurrent executions
+	// of each sandbox command, for the commands that do not
+	// set their own pool_size (0 = unlimited).
+	CommandPoolSize int `json:"command_pool_size"`
+	// Per-client rate limit of the sandbox requests (optional).
+	RateLimit *RateLimit `json:"rate_limit"`
+}
+
 // SandboxCommands describes all commands available for a sandbox.
 // command name : command
 type SandboxCommands map[string]*Command
@@ -87,11 +146,55 @@ type SandboxCommands map[string]*Command
 // A Command describes a specific set of actions to take
 // when executing a command in a sandbox.
 type Command struct {
-	Engine string  `json:"engine"`
-	Entry  string  `json:"entry"`
-	Before *Step   `json:"before"`
-	Steps  []*Step `json:"steps"`
-	After  *Step   `json:"after"`
+	Engine   string  `json:"engine"`
+	Entry    string  `json:"entry"`
+	PoolSize int     `json:"pool_size"`
+	Before   *Step   `json:"before"`
+	Steps    []*Step `json:"steps"`
+	After    *Step   `json:"after"`
+	// Request input accepted in addition to the files.
+	Input *Input `json:"input"`
+	// Glob patterns of the files to return after execution,
+	// relative to the working directory.
+	Outputs []string `json:"outputs"`
+	// Maximum size of a single returned file and of all of them, in bytes.
+	NOutputFile  int `json:"noutput_file"`
+	NOutputFiles int `json:"noutput_files"`
+	// Request size limits (override the global ones).
+	Limits *Limits `json:"limits"`
+	// Remote server that executes the command (remote engine only).
+	Remote string `json:"remote"`
+}
+
+// A Limits describes the request size limits.
+// Zero values mean the default limits.
+type Limits struct {
+	// Maximum request body size in bytes.
+	NBody int `json:"nbody"`
+	// Maximum number of files.
+	NFiles int `json:"nfiles"`
+	// Maximum size of a single file and of all files, in bytes.
+	// Data URL-encoded files count with their decoded size.
+	NFile  int `json:"nfile"`
+	NTotal int `json:"ntotal"`
+	// Maximum file name length and directory nesting depth.
+	NName  int `json:"nname"`
+	NDepth int `json:"ndepth"`
+}
+
+// An Input describes the request input a command accepts
+// in addition to the files. Nothing is accepted by default.
+type Input struct {
+	// Whether stdin is allowed, and its maximum size in bytes.
+	Stdin  bool `json:"stdin"`
+	NStdin int  `json:"nstdin"`
+	// Whether arguments are allowed (they replace the :args
+	// placeholder in the step command), and their maximum
+	// total size in bytes.
+	Args  bool `json:"args"`
+	NArgs int  `json:"nargs"`
+	// Allowed environment variable names.
+	Env []string `json:"env"`
 }
 
 // A Step describes a single step of a command.
@@ -105,6 +208,8 @@ type Step struct {
 	Command []string `json:"command"`
 	Timeout int      `json:"timeout"`
 	NOutput int      `json:"noutput"`
+	// Appended to the output that exceeds NOutput (if set).
+	TruncationMarker string `json:"truncation_marker"`
 }
 
 // An HTTP describes HTTP engine settings.
@@ -112,6 +217,105 @@ type HTTP struct {
 	Hosts map[string]string `json:"hosts"`
 }
 
+// A Remote describes a remote codapi server.
+type Remote struct {
+	// Server URL, e.g. http://10.0.0.2:1313.
+	URL string `json:"url"`
+	// API key (optional), sent as a bearer token.
+	Key string `json:"key"`
+	// Request timeout in seconds.
+	Timeout int `json:"timeout"`
+}
+
+// An APIKey describes a client API key
+// and the restrictions that apply to it.
+type APIKey struct {
+	// Key name, used in logs instead of the key itself.
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// Allowed sandboxes ("python") or sandbox commands ("python.run").
+	// All sandboxes are allowed if empty.
+	Sandboxes []string `json:"sandboxes"`
+	// Request rate limit (unlimited if not set).
+	RateLimit *RateLimit `json:"rate_limit"`
+	// Maximum number of concurrent executions (unlimited if zero).
+	PoolSize int `json:"pool_size"`
+}
+
+// A RateLimit describes a request rate limit.
+type RateLimit struct {
+	// Requests per minute.
+	Rate int `json:"rate"`
+	// Maximum number of requests at once (equals Rate if zero).
+	Burst int `json:"burst"`
+}
+
+// A CORS describes the cross-origin request policy.
+type CORS struct {
+	// Allowed origins, either exact ("https://example.com")
+	// or with a wildcard subdomain ("https://*.example.com").
+	// All origins are allowed if empty.
+	Origins []string `json:"origins"`
+	// Allowed request headers.
+	Headers []string `json:"headers"`
+	// Whether to allow credentials (cookies).
+	Credentials bool `json:"credentials"`
+	// How long the preflight response can be cached, in seconds.
+	MaxAge int `json:"max_age"`
+}
+
+// A Log describes the logging settings.
+type Log struct {
+	// Log format: text (default) or json.
+	Format string `json:"format"`
+	// Minimum level of logged messages:
+	// debug, info (default), warn or error.
+	Level string `json:"level"`
+}
+
+// An Audit describes the audit log settings.
+// The audit log is disabled if the path is not set.
+type Audit struct {
+	// Path to the audit log file (JSON lines).
+	Path string `json:"path"`
+	// Maximum file size in bytes before it is rotated,
+	// and the number of rotated files to keep.
+	MaxSize  int `json:"max_size"`
+	MaxFiles int `json:"max_files"`
+	// Whether to omit the file contents from the log.
+	Redact bool `json:"redact"`
+}
+
+// A Metrics describes the metrics server settings.
+// The server is disabled if the port is not set.
+type Metrics struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// A Jobs describes asynchronous jobs settings.
+type Jobs struct {
+	// How long to keep the finished job results, in seconds.
+	TTL int `json:"ttl"`
+	// The maximum number of stored jobs (queued, running or finished).
+	MaxCount int `json:"max_count"`
+	// The maximum number of queued or running jobs per API key
+	// (or per client IP address for requests without a key).
+	MaxPending int `json:"max_pending"`
+	// How long a job waits for a free worker, in seconds.
+	QueueTimeout int `json:"queue_timeout"`
+}
+
+// A Sessions describes stateful sessions settings.
+type Sessions struct {
+	// How long a session can stay idle before being closed, in seconds.
+	IdleTimeout int `json:"idle_timeout"`
+	// The maximum session lifetime, in seconds.
+	MaxLifetime int `json:"max_lifetime"`
+	// The maximum number of open sessions.
+	MaxCount int `json:"max_count"`
+}
+
 // setBoxDefaults sets default box properties
 // instead of zero values.
 func setBoxDefaults(box, defs *Box) {
@@ -165,4 +369,7 @@ func setStepDefaults(step, defs *Step) {
 	if step.NOutput == 0 {
 		step.NOutput = defs.NOutput
 	}
+	if step.TruncationMarker == "" {
+		step.TruncationMarker = defs.TruncationMarker
+	}
 }
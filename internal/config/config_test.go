@@ -96,12 +96,13 @@ func Test_setBoxDefaults(t *testing.T) {
 func Test_setStepDefaults(t *testing.T) {
 	step := &Step{}
 	defs := &Step{
-		Box:     "python",
-		User:    "sandbox",
-		Action:  "run",
-		Command: []string{"python", "main.py"},
-		Timeout: 3,
-		NOutput: 4096,
+		Box:              "python",
+		User:             "sandbox",
+		Action:           "run",
+		Command:          []string{"python", "main.py"},
+		Timeout:          3,
+		NOutput:          4096,
+		TruncationMarker: "[truncated]",
 	}
 
 	setStepDefaults(step, defs)
@@ -111,4 +112,5 @@ func Test_setStepDefaults(t *testing.T) {
 	be.Equal(t, len(step.Command), 0)
 	be.Equal(t, step.Timeout, defs.Timeout)
 	be.Equal(t, step.NOutput, defs.NOutput)
+	be.Equal(t, step.TruncationMarker, defs.TruncationMarker)
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,6 +12,10 @@ import (
 	"github.com/nalgeon/codapi/internal/logx"
 )
 
+// defaultQueueTimeout is the default maximum time (in seconds)
+// a request waits in the queue for a free worker.
+const defaultQueueTimeout = 10
+
 // Currently, Codapi supports three config layouts.
 // Only the first layout is preferred, the other two will be removed in the future.
 //
@@ -66,6 +71,7 @@ const (
 var (
 	ErrMissingBox  = errors.New("missing 'box' section in codapi.json")
 	ErrMissingStep = errors.New("missing 'step' section in codapi.json")
+	ErrInvalidKey  = errors.New("invalid API key")
 )
 
 // Read reads application config from JSON files.
@@ -174,10 +180,58 @@ func readConfig(path string) (*Config, error) {
 	if cfg.HTTP == nil {
 		cfg.HTTP = &HTTP{}
 	}
+	if cfg.Jobs == nil {
+		cfg.Jobs = &Jobs{}
+	}
+	if cfg.Sessions == nil {
+		cfg.Sessions = &Sessions{}
+	}
+	if cfg.QueueSize > 0 && cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = defaultQueueTimeout
+	}
+	if cfg.KeysFile != "" {
+		// the keys file path is relative to the config file
+		keysPath := cfg.KeysFile
+		if !filepath.IsAbs(keysPath) {
+			keysPath = filepath.Join(filepath.Dir(path), keysPath)
+		}
+		keys, err := fileio.ReadJson[[]*APIKey](keysPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Keys = append(cfg.Keys, keys...)
+	}
+	err = validateKeys(cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
 
 	return cfg, err
 }
 
+// validateKeys checks if the API keys are valid and unique.
+func validateKeys(keys []*APIKey) error {
+	names := map[string]bool{}
+	values := map[string]bool{}
+	for i, key := range keys {
+		if key.Name == "" {
+			return fmt.Errorf("%w: keys[%d]: missing name", ErrInvalidKey, i)
+		}
+		if key.Key == "" {
+			return fmt.Errorf("%w: %s: missing key", ErrInvalidKey, key.Name)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("%w: %s: duplicate name", ErrInvalidKey, key.Name)
+		}
+		if values[key.Key] {
+			return fmt.Errorf("%w: %s: duplicate key", ErrInvalidKey, key.Name)
+		}
+		names[key.Name] = true
+		values[key.Key] = true
+	}
+	return nil
+}
+
 // readBoxesDir reads boxes config from the boxes dir.
 func readBoxesDir(path string, pattern string) (map[string]*Box, error) {
 	logx.Debug("reading boxes from %s/%s", path, pattern)
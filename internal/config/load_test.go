@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/nalgeon/be"
@@ -10,9 +11,14 @@ func TestRead(t *testing.T) {
 	cfg, err := Read("testdata")
 	be.Err(t, err, nil)
 	be.Equal(t, cfg.PoolSize, 8)
+	be.Equal(t, cfg.QueueSize, 16)
+	be.Equal(t, cfg.QueueTimeout, defaultQueueTimeout)
 	be.Equal(t, cfg.Verbose, true)
 	be.Equal(t, cfg.Box.Memory, 64)
 	be.Equal(t, cfg.Step.User, "sandbox")
+	be.True(t, cfg.HTTP != nil)
+	be.True(t, cfg.Jobs != nil)
+	be.True(t, cfg.Sessions != nil)
 
 	// alpine box
 	be.True(t, cfg.Boxes["custom-alpine"] != nil)
@@ -22,4 +28,39 @@ func TestRead(t *testing.T) {
 	be.True(t, cfg.Boxes["python"] != nil)
 	be.True(t, cfg.Commands["python"] != nil)
 	be.True(t, cfg.Commands["python"]["run"] != nil)
+
+	// api keys
+	be.Equal(t, len(cfg.Keys), 2)
+	be.Equal(t, cfg.Keys[0].Name, "alice")
+	be.Equal(t, cfg.Keys[1].Name, "bob")
+	be.Equal(t, cfg.Keys[1].Sandboxes, []string{"python.run"})
+	be.Equal(t, *cfg.Keys[1].RateLimit, RateLimit{Rate: 60, Burst: 10})
+	be.Equal(t, cfg.Keys[1].PoolSize, 2)
+}
+
+func TestValidateKeys(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		keys := []*APIKey{{Name: "alice", Key: "a"}, {Name: "bob", Key: "b"}}
+		be.Err(t, validateKeys(keys), nil)
+	})
+	t.Run("missing name", func(t *testing.T) {
+		keys := []*APIKey{{Key: "a"}}
+		err := validateKeys(keys)
+		be.True(t, errors.Is(err, ErrInvalidKey))
+	})
+	t.Run("missing key", func(t *testing.T) {
+		keys := []*APIKey{{Name: "alice"}}
+		err := validateKeys(keys)
+		be.True(t, errors.Is(err, ErrInvalidKey))
+	})
+	t.Run("duplicate name", func(t *testing.T) {
+		keys := []*APIKey{{Name: "alice", Key: "a"}, {Name: "alice", Key: "b"}}
+		err := validateKeys(keys)
+		be.Equal(t, err.Error(), "invalid API key: alice: duplicate name")
+	})
+	t.Run("duplicate key", func(t *testing.T) {
+		keys := []*APIKey{{Name: "alice", Key: "a"}, {Name: "bob", Key: "a"}}
+		err := validateKeys(keys)
+		be.Equal(t, err.Error(), "invalid API key: bob: duplicate key")
+	})
 }
@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,10 +18,27 @@ import (
 	"github.com/nalgeon/codapi/internal/execy"
 	"github.com/nalgeon/codapi/internal/fileio"
 	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/metrics"
 )
 
 var killTimeout = 5 * time.Second
 
+var killFailures = metrics.NewCounter(
+	"codapi_docker_kill_failures_total",
+	"Failed attempts to kill a timed out container.",
+	"sandbox", "command",
+)
+
+// Default output files size limits.
+const (
+	defaultNOutputFile  = 1 << 20
+	defaultNOutputFiles = 4 << 20
+)
+
+// maxWarmFiles is the maximum total size of the files
+// copied back from the directory of a warm container.
+const maxWarmFiles = 1 << 30
+
 const (
 	actionRun  = "run"
 	actionExec = "exec"
@@ -29,31 +48,43 @@ const (
 // A Docker engine executes a specific sandbox command
 // using Docker `run` or `exec` actions.
 type Docker struct {
-	cfg *config.Config
-	cmd *config.Command
+	cfg    *config.Config
+	cmd    *config.Command
+	podman bool          // use podman instead of docker
+	api    *dockerClient // use the Docker Engine API instead of docker
 }
 
 // NewDocker creates a new Docker engine for a specific command.
 func NewDocker(cfg *config.Config, sandbox, command string) Engine {
 	cmd := cfg.Commands[sandbox][command]
-	return &Docker{cfg, cmd}
+	return &Docker{cfg: cfg, cmd: cmd}
 }
 
 // Exec executes the command and returns the output.
 func (e *Docker) Exec(req Request) Execution {
+	return e.ExecStream(context.Background(), req, nil)
+}
+
+// ExecStream executes the command, writing the output
+// to the stream as it is produced, and returns the final output.
+// Only the main steps are streamed, not the before/after ones.
+// If the stream has stdin, it is passed to the last main step.
+// If the context is canceled, stops the current step and skips the rest,
+// except for the cleanup one.
+func (e *Docker) ExecStream(ctx context.Context, req Request, stream *Stream) Execution {
 	// all steps operate in the same temp directory
 	dir, err := fileio.MkdirTemp(0777)
 	if err != nil {
 		err = NewExecutionError("create temp dir", err)
 		return Fail(req.ID, err)
 	}
-	defer func() { _ = os.RemoveAll(dir) }()
+	defer e.removeDir(dir)
 
 	// if the command entry point file is not defined,
 	// there is no need to store request files in the temp directory
 	if e.cmd.Entry != "" {
 		// write request files to the temp directory
-		err = e.writeFiles(dir, req.Files)
+		err = writeFiles(dir, e.cmd.Entry, req.Files)
 		var argErr ArgumentError
 		if errors.As(err, &argErr) {
 			return Fail(req.ID, err)
@@ -65,41 +96,140 @@ func (e *Docker) Exec(req Request) Execution {
 
 	// initialization step
 	if e.cmd.Before != nil {
-		out := e.execStep(e.cmd.Before, req, dir, nil)
+		out := e.execStep(ctx, e.cmd.Before, req, dir, nil, nil)
 		if !out.OK {
 			return out
 		}
 	}
 
+	out := e.execSteps(ctx, req, dir, stream)
+	out = withOutputs(e.cmd, out, dir)
+
+	// cleanup step (runs even if the execution is canceled)
+	if e.cmd.After != nil {
+		afterOut := e.execStep(context.WithoutCancel(ctx), e.cmd.After, req, dir, nil, nil)
+		if out.OK && !afterOut.OK {
+			return afterOut
+		}
+	}
+
+	return out
+}
+
+// SupportsSessions reports whether the command can open sessions.
+// It can if the initialization step starts a detached container.
+func (e *Docker) SupportsSessions() bool {
+	return e.cmd.Before != nil && e.cmd.Before.Action == actionRun && e.cmd.Before.Detach
+}
+
+// OpenSession starts a detached container for the session
+// using the initialization step. The req.ID is the session ID,
+// so it becomes the container name.
+func (e *Docker) OpenSession(ctx context.Context, req Request) Execution {
+	if !e.SupportsSessions() {
+		return Fail(req.ID, ErrNoSessions)
+	}
+	// the session directory is mounted into the container,
+	// so it should exist as long as the session does
+	dir := sessionDir(req.ID)
+	err := os.MkdirAll(dir, 0777)
+	if err == nil {
+		err = os.Chmod(dir, 0777)
+	}
+	if err != nil {
+		err = NewExecutionError("create session dir", err)
+		return Fail(req.ID, err)
+	}
+	out := e.execStep(ctx, e.cmd.Before, req, dir, nil, nil)
+	if !out.OK {
+		e.removeDir(dir)
+	}
+	return out
+}
+
+// ExecSession executes the main command steps
+// in the session container (the :name placeholder).
+func (e *Docker) ExecSession(ctx context.Context, session string, req Request, stream *Stream) Execution {
+	id := req.ID
+	req.ID = session
+	dir := sessionDir(session)
+	if e.cmd.Entry != "" {
+		// write request files to the session directory
+		err := writeFiles(dir, e.cmd.Entry, req.Files)
+		var argErr ArgumentError
+		if errors.As(err, &argErr) {
+			return Fail(id, err)
+		} else if err != nil {
+			err = NewExecutionError("write files to session dir", err)
+			return Fail(id, err)
+		}
+	}
+	out := e.execSteps(ctx, req, dir, stream)
+	out = withOutputs(e.cmd, out, dir)
+	out.ID = id
+	return out
+}
+
+// CloseSession stops the session container using the cleanup step
+// (or removes it forcibly if there is no such step).
+// The req.ID is the session ID.
+func (e *Docker) CloseSession(req Request) Execution {
+	defer e.removeDir(sessionDir(req.ID))
+	if e.cmd.After != nil {
+		return e.execStep(context.Background(), e.cmd.After, req, sessionDir(req.ID), nil, nil)
+	}
+	var err error
+	if e.api != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+		defer cancel()
+		err = e.api.remove(ctx, req.ID)
+	} else {
+		err = removeContainer(e.binary(), req.ID)
+	}
+	if err != nil {
+		err = NewExecutionError("remove container", err)
+		return Fail(req.ID, err)
+	}
+	return Execution{ID: req.ID, OK: true, Reason: ReasonExit}
+}
+
+// execSteps executes the main command steps.
+func (e *Docker) execSteps(ctx context.Context, req Request, dir string, stream *Stream) Execution {
+	return execSteps(ctx, e.cmd.Steps, req, dir, stream, e.execStep)
+}
+
+// A stepFunc executes a single command step.
+type stepFunc func(ctx context.Context, step *config.Step, req Request, dir string, files Files, stream *Stream) Execution
+
+// execSteps executes the main command steps one by one
+// using the exec function, until one of them fails.
+func execSteps(ctx context.Context, steps []*config.Step, req Request, dir string, stream *Stream, exec stepFunc) Execution {
+	stream = withStdin(stream, req.Stdin)
+
 	// the first step is required
-	first, rest := e.cmd.Steps[0], e.cmd.Steps[1:]
-	out := e.execStep(first, req, dir, req.Files)
+	first, rest := steps[0], steps[1:]
+	out := exec(ctx, first, req, dir, req.Files, stepStream(stream, len(rest) == 0))
 
 	// the rest are optional
 	if out.OK && len(rest) > 0 {
 		// each step operates on the results of the previous one,
 		// without using the source files - hence `nil` instead of `files`
-		for _, step := range rest {
-			out = e.execStep(step, req, dir, nil)
+		for i, step := range rest {
+			out = exec(ctx, step, req, dir, nil, stepStream(stream, i == len(rest)-1))
 			if !out.OK {
 				break
 			}
 		}
 	}
-
-	// cleanup step
-	if e.cmd.After != nil {
-		afterOut := e.execStep(e.cmd.After, req, dir, nil)
-		if out.OK && !afterOut.OK {
-			return afterOut
-		}
-	}
-
 	return out
 }
 
 // execStep executes a step using the docker container.
-func (e *Docker) execStep(step *config.Step, req Request, dir string, files Files) Execution {
+func (e *Docker) execStep(ctx context.Context, step *config.Step, req Request, dir string, files Files, stream *Stream) Execution {
+	if ctx.Err() != nil {
+		return Fail(req.ID, ErrCanceled)
+	}
+
 	box, err := e.getBox(step, req)
 	if err != nil {
 		return Fail(req.ID, err)
@@ -111,17 +241,10 @@ func (e *Docker) execStep(step *config.Step, req Request, dir string, files File
 		return Fail(req.ID, err)
 	}
 
-	stdout, stderr, err := e.exec(box, step, req, dir, files)
-	if err != nil {
-		return Fail(req.ID, err)
-	}
-
-	return Execution{
-		ID:     req.ID,
-		OK:     true,
-		Stdout: stdout,
-		Stderr: stderr,
+	if e.api != nil {
+		return e.execAPI(ctx, box, step, req, dir, files, stream)
 	}
+	return e.exec(ctx, box, step, req, dir, files, stream)
 }
 
 // getBox selects an appropriate box for the step (if any).
@@ -168,11 +291,12 @@ func (e *Docker) copyFiles(box *config.Box, dir string) error {
 }
 
 // writeFiles writes request files to the temporary directory.
-func (e *Docker) writeFiles(dir string, files Files) error {
+// The unnamed file is written to the entry point file.
+func writeFiles(dir, entry string, files Files) error {
 	var err error
 	files.Range(func(name, content string) bool {
 		if name == "" {
-			name = e.cmd.Entry
+			name = entry
 		}
 		var path string
 		path, err = fileio.JoinDir(dir, name)
@@ -180,74 +304,256 @@ func (e *Docker) writeFiles(dir string, files Files) error {
 			err = NewArgumentError(fmt.Sprintf("files[%s]", name), err)
 			return false
 		}
+		// session directories may contain read-only files
+		// from the previous requests, so remove them first
+		_ = os.Remove(path)
 		err = fileio.WriteFile(path, content, 0444)
 		return err == nil
 	})
 	return err
 }
 
+// withOutputs adds the output files to the execution (if any).
+// Skipped if the execution failed due to an internal error.
+func withOutputs(cmd *config.Command, out Execution, dir string) Execution {
+	if len(cmd.Outputs) == 0 || out.Err != nil {
+		return out
+	}
+	files, err := readOutputs(cmd, dir)
+	if err != nil {
+		err = NewExecutionError("read output files", err)
+		return Fail(out.ID, err)
+	}
+	out.Files = files
+	return out
+}
+
+// readOutputs reads the files matching the command output patterns
+// from the temporary directory. Skips files that exceed the size limit,
+// and stops when the total size limit is reached. Only reads regular files
+// inside the directory, since the code can create symlinks to host files.
+func readOutputs(cmd *config.Command, dir string) (Files, error) {
+	maxFile := int64(defaultNOutputFile)
+	if cmd.NOutputFile > 0 {
+		maxFile = int64(cmd.NOutputFile)
+	}
+	maxTotal := int64(defaultNOutputFiles)
+	if cmd.NOutputFiles > 0 {
+		maxTotal = int64(cmd.NOutputFiles)
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = root.Close() }()
+
+	files := Files{}
+	var total int64
+	for _, pattern := range cmd.Outputs {
+		// make sure the pattern does not escape the directory
+		path, err := fileio.JoinDir(dir, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("outputs[%s]: %w", pattern, err)
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("outputs[%s]: %w", pattern, err)
+		}
+		for _, match := range matches {
+			name, err := filepath.Rel(dir, match)
+			if err != nil {
+				return nil, err
+			}
+			name = filepath.ToSlash(name)
+			if _, ok := files[name]; ok {
+				continue
+			}
+			data, err := fileio.ReadFileIn(root, name, maxFile)
+			if err != nil {
+				// skip directories, symlinks, special files,
+				// files outside the directory and large files
+				logx.Debug("skip output file %s: %v", name, err)
+				continue
+			}
+			size := int64(len(data))
+			if total+size > maxTotal {
+				logx.Debug("skip output file %s: total size limit reached", name)
+				return files, nil
+			}
+			files[name] = fileio.EncodeContent(name, data)
+			total += size
+		}
+	}
+	return files, nil
+}
+
 // exec executes the step in the docker container
 // using the files from in the temporary directory.
-func (e *Docker) exec(box *config.Box, step *config.Step, req Request, dir string, files Files) (stdout string, stderr string, err error) {
+func (e *Docker) exec(ctx context.Context, box *config.Box, step *config.Step, req Request, dir string, files Files, stream *Stream) Execution {
 	// limit the stdout/stderr size
-	prog := NewProgram(step.Timeout, int64(step.NOutput))
-	args := e.buildArgs(box, step, req, dir)
+	prog := NewProgram(step.Timeout, int64(step.NOutput)).WithMarker(step.TruncationMarker).
+		WithContext(ctx).WithStream(stream)
+	stdin := stepStdin(step, files, stream)
 
-	if step.Stdin {
-		// pass files to container from stdin
-		stdin := filesReader(files)
-		stdout, stderr, err = prog.RunStdin(stdin, req.ID, "docker", args...)
+	var args []string
+	warm, pool := e.getWarm(box, step)
+	if warm != nil {
+		// run in a pre-started container, then destroy it
+		defer pool.recycle(warm)
+		err := fileio.CopyDir(dir, warm.dir, 0)
+		if err != nil {
+			err = NewExecutionError("copy files to container dir", err)
+			return Fail(req.ID, err)
+		}
+		args = e.buildWarmArgs(warm, step, req, stdin != nil)
+	} else {
+		args = e.buildArgs(box, step, req, dir, stdin != nil)
+	}
+
+	var stdout, stderr string
+	var err error
+	if stdin != nil {
+		// pass files and/or interactive input to container from stdin
+		stdout, stderr, err = prog.RunStdin(stdin, req.ID, e.binary(), args...)
 	} else {
 		// pass files to container from temp directory
-		stdout, stderr, err = prog.Run(req.ID, "docker", args...)
+		stdout, stderr, err = prog.Run(req.ID, e.binary(), args...)
+	}
+
+	if err == nil && warm != nil && !strings.HasSuffix(box.Volume, ":ro") {
+		// the next steps may need the files created by this one;
+		// remove the container first, so that the code can't
+		// change the files while they are copied
+		err = pool.remove(warm)
+		if err == nil {
+			err = fileio.CopyDir(warm.dir, dir, maxWarmFiles)
+		}
+		if err != nil {
+			err = NewExecutionError("copy files from container dir", err)
+			return Fail(req.ID, err)
+		}
 	}
 
+	if isKilled(err) && step.Action == actionRun && warm == nil {
+		// we have to "docker kill" the container here, because the process
+		// inside the container is not related to the "docker run" process,
+		// and will hang forever after the "docker run" process is killed
+		go func() {
+			err := killContainer(e.binary(), req.ID)
+			if err == nil {
+				logx.Debug("%s: %s kill ok", req.ID, e.binary())
+			} else {
+				killFailures.Inc(req.Sandbox, req.Command)
+				logx.Warn("%s: %s kill failed: %v", req.ID, e.binary(), err)
+			}
+		}()
+	}
+
+	return programResult(ctx, req.ID, prog, stdout, stderr, err)
+}
+
+// isKilled reports whether the program was killed
+// because of the timeout or cancellation.
+func isKilled(err error) bool {
+	return err != nil && err.Error() == "signal: killed"
+}
+
+// programResult converts the result of the program run
+// into the execution output.
+func programResult(ctx context.Context, id string, prog *Program, stdout, stderr string, err error) Execution {
 	if err == nil {
 		// success
-		return
+		return Execution{
+			ID:     id,
+			OK:     true,
+			Reason: exitReason(prog.Truncated()),
+			Stdout: stdout,
+			Stderr: stderr,
+			Output: prog.Output(),
+		}
 	}
 
-	if err.Error() == "signal: killed" {
-		if step.Action == actionRun {
-			// we have to "docker kill" the container here, because the process
-			// inside the container is not related to the "docker run" process,
-			// and will hang forever after the "docker run" process is killed
-			go func() {
-				err = dockerKill(req.ID)
-				if err == nil {
-					logx.Debug("%s: docker kill ok", req.ID)
-				} else {
-					logx.Log("%s: docker kill failed: %v", req.ID, err)
-				}
-			}()
+	if isKilled(err) {
+		if ctx.Err() != nil {
+			// canceled by the caller
+			return Fail(id, ErrCanceled)
 		}
 		// context timeout
-		err = ErrTimeout
-		return
+		return Fail(id, ErrTimeout)
 	}
 
 	exitErr := new(exec.ExitError)
 	if errors.As(err, &exitErr) {
 		// the problem (if any) is the code, not the execution
-		// so we return the error without wrapping into ExecutionError
-		stderr, stdout = stdout+stderr, ""
-		if stderr != "" {
-			err = fmt.Errorf("%s (%s)", stderr, err)
+		// so we return the output without wrapping into ExecutionError
+		code := exitErr.ExitCode()
+		if stdout == "" && stderr == "" {
+			stderr = err.Error()
+		}
+		reason := exitReason(prog.Truncated())
+		if code == exitCodeKilled {
+			// the process was killed by someone else (e.g. the kernel OOM killer),
+			// but the container is already removed, so we can't tell why
+			reason = ReasonKilled
+		}
+		return Execution{
+			ID:       id,
+			OK:       false,
+			ExitCode: code,
+			Reason:   reason,
+			Stdout:   stdout,
+			Stderr:   stderr,
+			Output:   prog.Output(),
 		}
-		return
 	}
 
 	// other execution error
 	err = NewExecutionError("execute code", err)
-	return
+	return Fail(id, err)
+}
+
+// binary returns the name of the container engine executable.
+func (e *Docker) binary() string {
+	if e.podman {
+		return "podman"
+	}
+	return "docker"
+}
+
+// removeDir removes the temporary directory. Rootless podman
+// containers create files owned by subordinate users, which
+// the host user can only remove inside the podman user namespace.
+func (e *Docker) removeDir(dir string) {
+	err := os.RemoveAll(dir)
+	if err == nil || !e.podman || !podmanRootless {
+		return
+	}
+	err = podmanUnshareRemove(dir)
+	if err != nil {
+		logx.Warn("remove %s: %v", dir, err)
+	}
+}
+
+// exitReason returns the termination reason for the process
+// that exited on its own.
+func exitReason(truncated bool) string {
+	if truncated {
+		return ReasonOutputTruncated
+	}
+	return ReasonExit
 }
 
 // buildArgs prepares the arguments for the `docker` command.
-func (e *Docker) buildArgs(box *config.Box, step *config.Step, req Request, dir string) []string {
+func (e *Docker) buildArgs(box *config.Box, step *config.Step, req Request, dir string, interactive bool) []string {
 	var args []string
 	switch step.Action {
 	case actionRun:
-		args = dockerRunArgs(box, step, req, dir)
+		if e.podman {
+			args = podmanRunArgs(box, step, req, dir, interactive)
+		} else {
+			args = dockerRunArgs(box, step, req, dir, interactive)
+		}
 	case actionExec:
 		args = dockerExecArgs(step, req)
 	case actionStop:
@@ -257,14 +563,54 @@ func (e *Docker) buildArgs(box *config.Box, step *config.Step, req Request, dir
 		args = []string{"version"}
 	}
 
-	command := expandVars(step.Command, req.ID)
+	command := expandVars(step.Command, req.ID, req)
+	args = append(args, command...)
+	logx.Debug("%v", args)
+	return args
+}
+
+// getWarm takes a pre-started container for the step from the box pool.
+// Returns nil if the box has no pool, the pool is empty,
+// or the step needs a container of its own (a detached one).
+// Pools are managed with the `docker` command,
+// so the podman and API engines never use them.
+func (e *Docker) getWarm(box *config.Box, step *config.Step) (*warmContainer, *ContainerPool) {
+	if box == nil || step.Detach || e.podman || e.api != nil {
+		return nil, nil
+	}
+	pool, ok := pools[box]
+	if !ok {
+		return nil, nil
+	}
+	warm := pool.get()
+	if warm == nil {
+		logx.Debug("pool %s is empty, starting a new container", pool.name)
+		return nil, nil
+	}
+	return warm, pool
+}
+
+// buildWarmArgs prepares the arguments for the `docker exec` command
+// in a pre-started container.
+func (e *Docker) buildWarmArgs(warm *warmContainer, step *config.Step, req Request, interactive bool) []string {
+	args := []string{actionExec}
+	if interactive {
+		args = append(args, "--interactive")
+	}
+	if step.User != "" {
+		args = append(args, "--user", step.User)
+	}
+	args = append(args, dockerEnvArgs(req.Env)...)
+	args = append(args, warm.name)
+	// the step runs in the warm container, not in the request one
+	command := expandVars(step.Command, warm.name, req)
 	args = append(args, command...)
 	logx.Debug("%v", args)
 	return args
 }
 
 // buildArgs prepares the arguments for the `docker run` command.
-func dockerRunArgs(box *config.Box, step *config.Step, req Request, dir string) []string {
+func dockerRunArgs(box *config.Box, step *config.Step, req Request, dir string, interactive bool) []string {
 	args := []string{
 		actionRun, "--rm",
 		"--name", req.ID,
@@ -273,12 +619,14 @@ func dockerRunArgs(box *config.Box, step *config.Step, req Request, dir string)
 		"--memory", fmt.Sprintf("%dm", box.Memory),
 		"--network", box.Network,
 		"--pids-limit", strconv.Itoa(box.NProc),
-		"--user", step.User,
+	}
+	if step.User != "" {
+		args = append(args, "--user", step.User)
 	}
 	if step.Detach {
 		args = append(args, "--detach")
 	}
-	if step.Stdin {
+	if interactive {
 		args = append(args, "--interactive")
 	}
 	if !box.Writable {
@@ -302,6 +650,7 @@ func dockerRunArgs(box *config.Box, step *config.Step, req Request, dir string)
 	for _, lim := range box.Ulimit {
 		args = append(args, "--ulimit", lim)
 	}
+	args = append(args, dockerEnvArgs(req.Env)...)
 	args = append(args, box.Image)
 	return args
 }
@@ -310,11 +659,27 @@ func dockerRunArgs(box *config.Box, step *config.Step, req Request, dir string)
 func dockerExecArgs(step *config.Step, req Request) []string {
 	// :name means executing in the container passed in the request
 	box := strings.Replace(step.Box, ":name", req.ID, 1)
-	return []string{
+	args := []string{
 		actionExec, "--interactive",
 		"--user", step.User,
-		box,
 	}
+	args = append(args, dockerEnvArgs(req.Env)...)
+	return append(args, box)
+}
+
+// dockerEnvArgs prepares the environment variable arguments
+// for the `docker run` and `docker exec` commands.
+func dockerEnvArgs(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, 0, 2*len(env))
+	for _, name := range names {
+		args = append(args, "--env", name+"="+env[name])
+	}
+	return args
 }
 
 // dockerStopArgs prepares the arguments for the `docker stop` command.
@@ -324,6 +689,55 @@ func dockerStopArgs(step *config.Step, req Request) []string {
 	return []string{actionStop, box}
 }
 
+// withStdin returns the stream that reads the request stdin
+// before the stream's interactive input (if any).
+func withStdin(stream *Stream, stdin string) *Stream {
+	if stdin == "" {
+		return stream
+	}
+	if stream == nil {
+		return &Stream{Stdin: strings.NewReader(stdin)}
+	}
+	s := *stream
+	if s.Stdin == nil {
+		s.Stdin = strings.NewReader(stdin)
+	} else {
+		s.Stdin = io.MultiReader(strings.NewReader(stdin), s.Stdin)
+	}
+	return &s
+}
+
+// stepStream returns the stream for a main step.
+// Only the last step receives the stream's stdin,
+// the others receive the output part only.
+func stepStream(stream *Stream, last bool) *Stream {
+	if stream == nil || stream.Stdin == nil || last {
+		return stream
+	}
+	return &Stream{Stdout: stream.Stdout, Stderr: stream.Stderr}
+}
+
+// stepStdin returns the stdin for the step (if any).
+// Combines the files (if the step reads them from stdin)
+// with the stream's interactive input (if any).
+func stepStdin(step *config.Step, files Files, stream *Stream) io.Reader {
+	var readers []io.Reader
+	if step.Stdin {
+		readers = append(readers, filesReader(files))
+	}
+	if stream != nil && stream.Stdin != nil {
+		readers = append(readers, stream.Stdin)
+	}
+	switch len(readers) {
+	case 0:
+		return nil
+	case 1:
+		return readers[0]
+	default:
+		return io.MultiReader(readers...)
+	}
+}
+
 // filesReader creates a reader over an in-memory collection of files.
 func filesReader(files Files) io.Reader {
 	var input strings.Builder
@@ -333,21 +747,57 @@ func filesReader(files Files) io.Reader {
 	return strings.NewReader(input.String())
 }
 
+// sessionDir returns the host directory of the session.
+func sessionDir(id string) string {
+	return filepath.Join(os.TempDir(), "codapi_"+id)
+}
+
 // expandVars replaces variables in command arguments with values.
-// The only supported variable is :name = container name.
-func expandVars(command []string, name string) []string {
-	expanded := make([]string, len(command))
-	copy(expanded, command)
-	for i, cmd := range expanded {
-		expanded[i] = strings.ReplaceAll(cmd, ":name", name)
+// Supported variables:
+//   - :name = container name (usually the request ID);
+//   - :args = request arguments (must be a separate command argument).
+func expandVars(command []string, name string, req Request) []string {
+	expanded := make([]string, 0, len(command)+len(req.Args))
+	for _, cmd := range command {
+		if cmd == ":args" {
+			expanded = append(expanded, req.Args...)
+			continue
+		}
+		expanded = append(expanded, strings.ReplaceAll(cmd, ":name", name))
 	}
 	return expanded
 }
 
-// dockerKill kills the container with the specified id/name.
-func dockerKill(id string) error {
+// Images returns the references of the images available locally,
+// both as repository:tag and repository@digest (if the image has a digest).
+// Uses the same container engine as the executions (docker, podman
+// or the Docker API). Fails if the container engine does not respond.
+func (e *Docker) Images() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	if e.api != nil {
+		return e.api.images(ctx)
+	}
+	var stdout, stderr strings.Builder
+	format := "{{.Repository}}:{{.Tag}} {{.Repository}}@{{.Digest}}"
+	cmd := exec.CommandContext(ctx, e.binary(), "image", "ls", "--format", format)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := execy.Run(cmd)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s (%w)", msg, err)
+		}
+		return nil, err
+	}
+	return strings.Fields(stdout.String()), nil
+}
+
+// killContainer kills the container with the specified id/name
+// using the container engine executable (docker or podman).
+func killContainer(bin, id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "docker", "kill", id)
+	cmd := exec.CommandContext(ctx, bin, "kill", id)
 	return execy.Run(cmd)
 }
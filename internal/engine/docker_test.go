@@ -1,9 +1,16 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nalgeon/be"
 	"github.com/nalgeon/codapi/internal/config"
@@ -255,6 +262,291 @@ func TestDockerRun(t *testing.T) {
 	})
 }
 
+func TestDockerRun_Reason(t *testing.T) {
+	logx.Mock()
+	engine := NewDocker(dockerCfg, "python", "run")
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "python",
+		Command: "run",
+		Files: map[string]string{
+			"": "print('hello world')",
+		},
+	}
+
+	t.Run("exit", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Stdout: "hello world"},
+		})
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.ExitCode, 0)
+		be.Equal(t, out.Reason, ReasonExit)
+	})
+	t.Run("exit code", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Stdout: "1 passed", Stderr: "1 failed", Err: exitError(3)},
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, 3)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stdout, "1 passed")
+		be.Equal(t, out.Stderr, "1 failed")
+		be.Equal(t, out.Err, nil)
+	})
+	t.Run("exit code no output", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Err: exitError(1)},
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.ExitCode, 1)
+		be.Equal(t, out.Stdout, "")
+		be.Equal(t, out.Stderr, "exit status 1")
+	})
+	t.Run("timeout", func(t *testing.T) {
+		logMem := logx.Mock()
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Err: errors.New("signal: killed")},
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, -1)
+		be.Equal(t, out.Reason, ReasonTimeout)
+		be.Equal(t, out.Stderr, ErrTimeout.Error())
+
+		// wait for the container to be killed in the background
+		deadline := time.Now().Add(time.Second)
+		for !logMem.Has("docker kill ok") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	t.Run("killed", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Err: exitError(137)},
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, 137)
+		be.Equal(t, out.Reason, ReasonKilled)
+	})
+	t.Run("output truncated", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Stdout: strings.Repeat("a", 5000)},
+		})
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.ExitCode, 0)
+		be.Equal(t, out.Reason, ReasonOutputTruncated)
+		be.Equal(t, len(out.Stdout), 4096)
+		be.True(t, out.StdoutTruncated)
+		be.Equal(t, out.StderrTruncated, false)
+		be.Equal(t, out.StdoutBytes, int64(5000))
+	})
+	t.Run("internal", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Err: errors.New("docker: not found")},
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, -1)
+		be.Equal(t, out.Reason, ReasonInternal)
+	})
+}
+
+// exitError returns an error of a process
+// that exited with the given code.
+func exitError(code int) error {
+	return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+}
+
+func TestDockerRun_Outputs(t *testing.T) {
+	logx.Mock()
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello world"},
+	})
+	newEngine := func(outputs []string, nFile, nFiles int) *Docker {
+		cmd := *dockerCfg.Commands["python"]["run"]
+		cmd.Entry = "main.py"
+		cmd.Outputs = outputs
+		cmd.NOutputFile = nFile
+		cmd.NOutputFiles = nFiles
+		return &Docker{cfg: dockerCfg, cmd: &cmd}
+	}
+	// the mock does not run programs, so the request files
+	// play the role of the files created by the program
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "python",
+		Command: "run",
+		Files: map[string]string{
+			"":            "print('hello world')",
+			"data.csv":    "a,b\n1,2",
+			"plot.png":    "data:;base64,iVBORw0KGgo=",
+			"out/log.txt": "done",
+		},
+	}
+
+	t.Run("files", func(t *testing.T) {
+		engine := newEngine([]string{"*.csv", "*.png", "out/*"}, 0, 0)
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.Files, Files{
+			"data.csv":    "a,b\n1,2",
+			"plot.png":    "data:image/png;base64,iVBORw0KGgo=",
+			"out/log.txt": "done",
+		})
+	})
+	t.Run("no outputs", func(t *testing.T) {
+		engine := newEngine(nil, 0, 0)
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, len(out.Files), 0)
+	})
+	t.Run("no matches", func(t *testing.T) {
+		engine := newEngine([]string{"*.svg"}, 0, 0)
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, len(out.Files), 0)
+	})
+	t.Run("file limit", func(t *testing.T) {
+		engine := newEngine([]string{"*.csv", "out/*"}, 5, 0)
+		out := engine.Exec(req)
+		be.Equal(t, out.Files, Files{"out/log.txt": "done"})
+	})
+	t.Run("total limit", func(t *testing.T) {
+		engine := newEngine([]string{"*.csv", "out/*"}, 0, 10)
+		out := engine.Exec(req)
+		be.Equal(t, out.Files, Files{"data.csv": "a,b\n1,2"})
+	})
+	t.Run("invalid pattern", func(t *testing.T) {
+		engine := newEngine([]string{"../*"}, 0, 0)
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Reason, ReasonInternal)
+	})
+}
+
+func Test_readOutputs(t *testing.T) {
+	logx.Mock()
+	outside := t.TempDir()
+	_ = os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hello"), 0644)
+	// the code can link to host files and directories
+	_ = os.Symlink(outside, filepath.Join(dir, "results"))
+	_ = os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "secret.txt"))
+
+	cmd := &config.Command{Outputs: []string{"*.txt", "results/*"}}
+	files, err := readOutputs(cmd, dir)
+	be.Err(t, err, nil)
+	be.Equal(t, files, Files{"data.txt": "hello"})
+}
+
+func TestDockerRun_Input(t *testing.T) {
+	logx.Mock()
+	mem := execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello world"},
+	})
+	cmd := *dockerCfg.Commands["python"]["run"]
+	step := *cmd.Steps[0]
+	step.Command = []string{"python", "main.py", ":args"}
+	cmd.Steps = []*config.Step{&step}
+	engine := &Docker{cfg: dockerCfg, cmd: &cmd}
+
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "python",
+		Command: "run",
+		Files: map[string]string{
+			"": "print(input())",
+		},
+		Stdin: "alice",
+		Args:  []string{"--name", "bob"},
+		Env:   map[string]string{"DEBUG": "1"},
+	}
+	out := engine.Exec(req)
+	be.True(t, out.OK)
+	mem.MustHave(t, "--interactive")
+	mem.MustHave(t, "--env DEBUG=1 codapi/python")
+	mem.MustHave(t, "python main.py --name bob")
+}
+
+func TestDockerExecStream(t *testing.T) {
+	logx.Mock()
+	commands := map[string]execy.CmdOut{
+		"docker run":  {Stdout: "c958ff2", Stderr: "", Err: nil},
+		"docker exec": {Stdout: "hello", Stderr: "", Err: nil},
+		"docker stop": {Stdout: "alpine_42", Stderr: "", Err: nil},
+	}
+	execy.Mock(commands)
+	engine := NewDocker(dockerCfg, "alpine", "echo")
+
+	req := Request{
+		ID:      "alpine_42",
+		Sandbox: "alpine",
+		Command: "echo",
+		Files: map[string]string{
+			"": "echo hello",
+		},
+	}
+	var stdout, stderr strings.Builder
+	out := engine.ExecStream(context.Background(), req, &Stream{Stdout: &stdout, Stderr: &stderr})
+	be.True(t, out.OK)
+	be.Equal(t, out.Stdout, "hello")
+	// only the main step is streamed
+	be.Equal(t, stdout.String(), "hello")
+	be.Equal(t, stderr.String(), "")
+}
+
+func TestDockerExecStream_Stdin(t *testing.T) {
+	logx.Mock()
+	commands := map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello", Stderr: "", Err: nil},
+	}
+	mem := execy.Mock(commands)
+	engine := NewDocker(dockerCfg, "go", "run")
+
+	req := Request{
+		ID:      "go_42",
+		Sandbox: "go",
+		Command: "run",
+		Files: map[string]string{
+			"": "var n = 42",
+		},
+	}
+	var stdout strings.Builder
+	stream := &Stream{Stdin: strings.NewReader("42"), Stdout: &stdout, Stderr: io.Discard}
+	out := engine.ExecStream(context.Background(), req, stream)
+	be.True(t, out.OK)
+	// only the last step receives stdin
+	mem.MustHave(t, "docker run --rm --name go_42", "codapi/go go build")
+	mem.MustNotHave(t, "--interactive", "codapi/go go build")
+	mem.MustHave(t, "docker run --rm --name go_42", "--interactive", "codapi/alpine ./main")
+}
+
+func TestDockerExecStream_Canceled(t *testing.T) {
+	logx.Mock()
+	mem := execy.Mock(nil)
+	engine := NewDocker(dockerCfg, "alpine", "echo")
+
+	req := Request{
+		ID:      "alpine_42",
+		Sandbox: "alpine",
+		Command: "echo",
+		Files: map[string]string{
+			"": "echo hello",
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := engine.ExecStream(ctx, req, nil)
+	be.Equal(t, out.OK, false)
+	be.Equal(t, out.Stderr, ErrCanceled.Error())
+	// nothing was started, so there is nothing to clean up
+	mem.MustNotHave(t, "docker")
+}
+
 func TestDockerExec(t *testing.T) {
 	logx.Mock()
 	commands := map[string]execy.CmdOut{
@@ -285,6 +577,37 @@ func TestDockerExec(t *testing.T) {
 	})
 }
 
+func TestDocker_Images(t *testing.T) {
+	t.Run("docker", func(t *testing.T) {
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "codapi/python:latest codapi/python@<none>\n"},
+		})
+		engine := NewDocker(dockerCfg, "python", "run").(*Docker)
+		images, err := engine.Images()
+		be.Err(t, err, nil)
+		be.Equal(t, images, []string{"codapi/python:latest", "codapi/python@<none>"})
+		mem.MustHave(t, "docker image ls --format")
+	})
+	t.Run("podman", func(t *testing.T) {
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"podman image": {Stdout: "localhost/codapi/python:latest localhost/codapi/python@<none>\n"},
+		})
+		engine := NewPodman(dockerCfg, "python", "run").(*Docker)
+		images, err := engine.Images()
+		be.Err(t, err, nil)
+		be.Equal(t, len(images), 2)
+		mem.MustHave(t, "podman image ls --format")
+	})
+	t.Run("unavailable", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stderr: "Cannot connect to the Docker daemon", Err: errors.New("exit status 1")},
+		})
+		engine := NewDocker(dockerCfg, "python", "run").(*Docker)
+		_, err := engine.Images()
+		be.Equal(t, err.Error(), "Cannot connect to the Docker daemon (exit status 1)")
+	})
+}
+
 func TestDockerStop(t *testing.T) {
 	logx.Mock()
 	commands := map[string]execy.CmdOut{
@@ -317,17 +640,142 @@ func TestDockerStop(t *testing.T) {
 	})
 }
 
+func TestDockerSession(t *testing.T) {
+	logx.Mock()
+	commands := map[string]execy.CmdOut{
+		"docker run":  {Stdout: "c958ff2", Stderr: "", Err: nil},
+		"docker exec": {Stdout: "hello", Stderr: "", Err: nil},
+		"docker stop": {Stdout: "alpine_session_42", Stderr: "", Err: nil},
+	}
+	mem := execy.Mock(commands)
+	engine := NewDocker(dockerCfg, "alpine", "echo").(*Docker)
+
+	t.Run("supports", func(t *testing.T) {
+		be.True(t, engine.SupportsSessions())
+		python := NewDocker(dockerCfg, "python", "run").(*Docker)
+		be.Equal(t, python.SupportsSessions(), false)
+		out := python.OpenSession(context.Background(), Request{ID: "python_session_42"})
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Stderr, ErrNoSessions.Error())
+	})
+	t.Run("lifecycle", func(t *testing.T) {
+		sess := Request{ID: "alpine_session_42", Sandbox: "alpine", Command: "echo"}
+		out := engine.OpenSession(context.Background(), sess)
+		be.True(t, out.OK)
+		mem.MustHave(t, "docker run --rm --name alpine_session_42", "--detach")
+		be.True(t, fileExists(sessionDir(sess.ID)))
+
+		req := Request{
+			ID:      "alpine_42",
+			Sandbox: "alpine",
+			Command: "echo",
+			Files: map[string]string{
+				"": "echo hello",
+			},
+		}
+		out = engine.ExecSession(context.Background(), sess.ID, req, nil)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello")
+		mem.MustHave(t, "docker exec --interactive --user sandbox alpine_session_42 sh main.sh")
+		mem.MustNotHave(t, "docker stop")
+
+		out = engine.CloseSession(sess)
+		be.True(t, out.OK)
+		mem.MustHave(t, "docker stop alpine_session_42")
+		be.Equal(t, fileExists(sessionDir(sess.ID)), false)
+	})
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func Test_stepStdin(t *testing.T) {
+	files := Files{"": "select 1;"}
+	t.Run("none", func(t *testing.T) {
+		step := &config.Step{}
+		be.Equal(t, stepStdin(step, files, nil), nil)
+	})
+	t.Run("files", func(t *testing.T) {
+		step := &config.Step{Stdin: true}
+		data, _ := io.ReadAll(stepStdin(step, files, nil))
+		be.Equal(t, string(data), "select 1;")
+	})
+	t.Run("stream", func(t *testing.T) {
+		step := &config.Step{}
+		stream := &Stream{Stdin: strings.NewReader("42")}
+		data, _ := io.ReadAll(stepStdin(step, files, stream))
+		be.Equal(t, string(data), "42")
+	})
+	t.Run("files and stream", func(t *testing.T) {
+		step := &config.Step{Stdin: true}
+		stream := &Stream{Stdin: strings.NewReader("select 2;")}
+		data, _ := io.ReadAll(stepStdin(step, files, stream))
+		be.Equal(t, string(data), "select 1;select 2;")
+	})
+}
+
 func Test_expandVars(t *testing.T) {
 	const name = "codapi_01"
 	commands := map[string]string{
 		"python main.py":             "python main.py",
 		"sh create.sh :name":         "sh create.sh " + name,
 		"sh copy.sh :name new-:name": "sh copy.sh " + name + " new-" + name,
+		"python main.py :args":       "python main.py",
 	}
 	for cmd, want := range commands {
 		src := strings.Fields(cmd)
-		exp := expandVars(src, name)
+		exp := expandVars(src, name, Request{ID: "http_42"})
 		got := strings.Join(exp, " ")
 		be.Equal(t, got, want)
 	}
+
+	t.Run("args", func(t *testing.T) {
+		req := Request{ID: name, Args: []string{"--n", "42", "hello world"}}
+		exp := expandVars([]string{"python", "main.py", ":args", ":name"}, name, req)
+		want := []string{"python", "main.py", "--n", "42", "hello world", name}
+		be.Equal(t, exp, want)
+	})
+}
+
+func TestDocker_buildWarmArgs(t *testing.T) {
+	e := &Docker{}
+	warm := &warmContainer{name: "codapi_python_42"}
+	step := &config.Step{User: "sandbox", Command: []string{"sh", "copy.sh", ":name"}}
+	req := Request{ID: "python_run_42"}
+	args := e.buildWarmArgs(warm, step, req, true)
+	be.Equal(t, args, []string{
+		"exec", "--interactive", "--user", "sandbox", "codapi_python_42",
+		"sh", "copy.sh", "codapi_python_42",
+	})
+}
+
+func Test_dockerEnvArgs(t *testing.T) {
+	be.Equal(t, dockerEnvArgs(nil), []string{})
+	env := map[string]string{"LANG": "en_US.UTF-8", "DEBUG": "1"}
+	want := []string{"--env", "DEBUG=1", "--env", "LANG=en_US.UTF-8"}
+	be.Equal(t, dockerEnvArgs(env), want)
+}
+
+func Test_withStdin(t *testing.T) {
+	t.Run("no stdin", func(t *testing.T) {
+		stream := &Stream{}
+		be.Equal(t, withStdin(stream, ""), stream)
+		be.Equal(t, withStdin(nil, ""), (*Stream)(nil))
+	})
+	t.Run("no stream", func(t *testing.T) {
+		s := withStdin(nil, "hello")
+		data, _ := io.ReadAll(s.Stdin)
+		be.Equal(t, string(data), "hello")
+	})
+	t.Run("stream", func(t *testing.T) {
+		var stdout strings.Builder
+		stream := &Stream{Stdout: &stdout, Stdin: strings.NewReader(" world")}
+		s := withStdin(stream, "hello")
+		data, _ := io.ReadAll(s.Stdin)
+		be.Equal(t, string(data), "hello world")
+		be.Equal(t, s.Stdout, io.Writer(&stdout))
+	})
 }
@@ -0,0 +1,301 @@
+// Execute commands using the Docker Engine API.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// NewDockerAPI creates a new Docker engine for a specific command.
+// It works the same way as the Docker engine, but talks to the
+// Docker Engine API over the unix socket instead of executing
+// the `docker` command.
+func NewDockerAPI(cfg *config.Config, sandbox, command string) Engine {
+	cmd := cfg.Commands[sandbox][command]
+	socket := cfg.DockerSocket
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+	return &Docker{cfg: cfg, cmd: cmd, api: newDockerClient(socket)}
+}
+
+// execAPI executes the step using the Docker Engine API.
+func (e *Docker) execAPI(ctx context.Context, box *config.Box, step *config.Step, req Request, dir string, files Files, stream *Stream) Execution {
+	runCtx, cancel := context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+	defer cancel()
+
+	// limit the stdout/stderr size
+	var stdout, stderr strings.Builder
+	var streamOut, streamErr io.Writer
+	if stream != nil {
+		streamOut, streamErr = stream.Stdout, stream.Stderr
+	}
+	outw := LimitWriterMarker(TeeWriter(&stdout, streamOut), int64(step.NOutput), step.TruncationMarker)
+	errw := LimitWriterMarker(TeeWriter(&stderr, streamErr), int64(step.NOutput), step.TruncationMarker)
+	stdin := stepStdin(step, files, stream)
+
+	var code int
+	var oom bool
+	var err error
+	switch step.Action {
+	case actionRun:
+		code, oom, err = e.apiRun(runCtx, box, step, req, dir, stdin, outw, errw)
+	case actionExec:
+		code, err = e.apiExec(runCtx, step, req, stdin, outw, errw)
+	case actionStop:
+		err = e.api.stop(runCtx, containerName(step, req))
+	default:
+		// should never happen if the config is valid
+		err = fmt.Errorf("unknown action %s", step.Action)
+	}
+
+	if runCtx.Err() != nil {
+		if ctx.Err() != nil {
+			// canceled by the caller
+			return Fail(req.ID, ErrCanceled)
+		}
+		// context timeout
+		return Fail(req.ID, ErrTimeout)
+	}
+	if err != nil {
+		err = NewExecutionError("execute code", err)
+		return Fail(req.ID, err)
+	}
+
+	// the container state tells whether the process was OOM-killed,
+	// so the exit code alone does not define the reason
+	reason := exitReason(outw.Truncated() || errw.Truncated())
+	if oom {
+		reason = ReasonOOMKilled
+	}
+	out := Execution{
+		ID:       req.ID,
+		OK:       code == 0,
+		ExitCode: code,
+		Reason:   reason,
+		Stdout:   strings.TrimSpace(stdout.String()),
+		Stderr:   strings.TrimSpace(stderr.String()),
+		Output: Output{
+			StdoutTruncated: outw.Truncated(),
+			StderrTruncated: errw.Truncated(),
+			StdoutBytes:     outw.Total(),
+			StderrBytes:     errw.Total(),
+		},
+	}
+	if code != 0 && out.Stdout == "" && out.Stderr == "" {
+		out.Stderr = fmt.Sprintf("exit status %d", code)
+	}
+	return out
+}
+
+// apiRun runs the step in a new container and returns its exit code,
+// and whether it was killed for exceeding the memory limit.
+// A detached container keeps running after the step.
+func (e *Docker) apiRun(ctx context.Context, box *config.Box, step *config.Step, req Request, dir string, stdin io.Reader, stdout, stderr io.Writer) (int, bool, error) {
+	cfg, err := dockerContainerConfig(box, step, req, dir, stdin != nil)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err := e.api.create(ctx, req.ID, cfg)
+	if err != nil {
+		return 0, false, fmt.Errorf("create container: %w", err)
+	}
+	if step.Detach {
+		err = e.api.start(ctx, id)
+		if err != nil {
+			e.removeAPI(req, id)
+			return 0, false, fmt.Errorf("start container: %w", err)
+		}
+		_, _ = io.WriteString(stdout, id)
+		return 0, false, nil
+	}
+	defer e.removeAPI(req, id)
+
+	conn, err := e.api.attach(ctx, id, stdin != nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("attach to container: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	err = e.api.start(ctx, id)
+	if err != nil {
+		return 0, false, fmt.Errorf("start container: %w", err)
+	}
+
+	done := pipeConn(conn, stdin, stdout, stderr)
+	code, err := e.api.wait(ctx, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			e.killAPI(req, id)
+			return 0, false, ctx.Err()
+		}
+		return 0, false, fmt.Errorf("wait for container: %w", err)
+	}
+	// the output ends when the container exits
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("read output: %w", err)
+	}
+	oom, err := e.api.oomKilled(ctx, id)
+	if err != nil {
+		return 0, false, fmt.Errorf("inspect container: %w", err)
+	}
+	return code, oom, nil
+}
+
+// apiExec executes the step command in an existing container
+// and returns its exit code.
+func (e *Docker) apiExec(ctx context.Context, step *config.Step, req Request, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cfg := execConfig{
+		Cmd:          expandVars(step.Command, req.ID, req),
+		User:         step.User,
+		Env:          envVars(req.Env),
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	id, err := e.api.execCreate(ctx, containerName(step, req), cfg)
+	if err != nil {
+		return 0, fmt.Errorf("create exec: %w", err)
+	}
+	conn, err := e.api.execStart(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("start exec: %w", err)
+	}
+	// closing the connection on timeout does not stop the process,
+	// since the API cannot kill it, but the container will be stopped
+	// at the end of the session anyway
+	defer func() { _ = conn.Close() }()
+
+	done := pipeConn(conn, stdin, stdout, stderr)
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read output: %w", err)
+	}
+	code, err := e.api.execExitCode(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("inspect exec: %w", err)
+	}
+	return code, nil
+}
+
+// killAPI kills the timed out container.
+func (e *Docker) killAPI(req Request, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	err := e.api.kill(ctx, id)
+	if err == nil {
+		logx.Debug("%s: docker kill ok", req.ID)
+	} else {
+		killFailures.Inc(req.Sandbox, req.Command)
+		logx.Warn("%s: docker kill failed: %v", req.ID, err)
+	}
+}
+
+// removeAPI removes the container after the step.
+func (e *Docker) removeAPI(req Request, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	err := e.api.remove(ctx, id)
+	if err != nil {
+		logx.Warn("%s: remove container: %v", req.ID, err)
+	}
+}
+
+// pipeConn copies the input (if any) to the connection
+// and splits the connection output into stdout and stderr.
+// Returns a channel that receives the result when the output ends.
+func pipeConn(conn *hijackedConn, stdin io.Reader, stdout, stderr io.Writer) <-chan error {
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(conn, stdin)
+			_ = conn.CloseWrite()
+		}()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- demux(conn, stdout, stderr)
+	}()
+	return done
+}
+
+// dockerContainerConfig prepares the container configuration
+// for the run step, same as dockerRunArgs does for `docker run`.
+func dockerContainerConfig(box *config.Box, step *config.Step, req Request, dir string, interactive bool) (containerConfig, error) {
+	cfg := containerConfig{
+		Image:        box.Image,
+		Cmd:          expandVars(step.Command, req.ID, req),
+		User:         step.User,
+		Env:          envVars(req.Env),
+		AttachStdin:  interactive,
+		AttachStdout: !step.Detach,
+		AttachStderr: !step.Detach,
+		OpenStdin:    interactive,
+		StdinOnce:    interactive,
+		HostConfig: hostConfig{
+			Runtime:        box.Runtime,
+			NanoCpus:       int64(box.CPU) * 1e9,
+			Memory:         int64(box.Memory) * 1024 * 1024,
+			NetworkMode:    box.Network,
+			PidsLimit:      int64(box.NProc),
+			ReadonlyRootfs: !box.Writable,
+			CapAdd:         box.CapAdd,
+			CapDrop:        box.CapDrop,
+			// detached containers are removed when stopped,
+			// the others are removed explicitly after the step
+			AutoRemove: step.Detach,
+		},
+	}
+	if box.Storage != "" {
+		cfg.HostConfig.StorageOpt = map[string]string{"size": box.Storage}
+	}
+	if dir != "" {
+		cfg.HostConfig.Binds = []string{fmt.Sprintf(box.Volume, dir)}
+	}
+	if len(box.Tmpfs) > 0 {
+		cfg.HostConfig.Tmpfs = make(map[string]string, len(box.Tmpfs))
+		for _, fs := range box.Tmpfs {
+			path, opts, _ := strings.Cut(fs, ":")
+			cfg.HostConfig.Tmpfs[path] = opts
+		}
+	}
+	for _, s := range box.Ulimit {
+		lim, err := parseUlimit(s)
+		if err != nil {
+			return containerConfig{}, err
+		}
+		cfg.HostConfig.Ulimits = append(cfg.HostConfig.Ulimits, lim)
+	}
+	return cfg, nil
+}
+
+// envVars prepares the environment variables
+// in the NAME=value format, sorted by name.
+func envVars(env map[string]string) []string {
+	vars := make([]string, 0, len(env))
+	for name, value := range env {
+		vars = append(vars, name+"="+value)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// containerName returns the name of the existing container
+// for the exec and stop steps (:name means the request one).
+func containerName(step *config.Step, req Request) string {
+	return strings.Replace(step.Box, ":name", req.ID, 1)
+}
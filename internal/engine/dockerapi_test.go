@@ -0,0 +1,473 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// fakeDocker is a fake Docker Engine API server
+// listening on a local unix socket.
+type fakeDocker struct {
+	srv    *httptest.Server
+	socket string
+
+	// container behavior
+	stdout   string
+	stderr   string
+	exitCode int
+	oom      bool
+	echo     bool // write the input to stdout
+	hang     bool // run until killed
+	status   int  // create response status (if not 201)
+
+	mu      sync.Mutex
+	calls   []string
+	created containerConfig
+	conn    net.Conn
+	exited  chan struct{}
+}
+
+// newFakeDocker starts the fake server.
+func newFakeDocker(t *testing.T) *fakeDocker {
+	dir, err := os.MkdirTemp("", "codapi")
+	be.Err(t, err, nil)
+	f := &fakeDocker{socket: filepath.Join(dir, "docker.sock")}
+	ln, err := net.Listen("unix", f.socket)
+	be.Err(t, err, nil)
+
+	mux := http.NewServeMux()
+	prefix := "/" + dockerAPIVersion
+	mux.HandleFunc("POST "+prefix+"/containers/create", f.create)
+	mux.HandleFunc("POST "+prefix+"/containers/{id}/attach", f.attach)
+	mux.HandleFunc("POST "+prefix+"/containers/{id}/start", f.start)
+	mux.HandleFunc("POST "+prefix+"/containers/{id}/wait", f.wait)
+	mux.HandleFunc("GET "+prefix+"/containers/{id}/json", f.inspect)
+	mux.HandleFunc("POST "+prefix+"/containers/{id}/kill", f.kill)
+	mux.HandleFunc("POST "+prefix+"/containers/{id}/stop", f.noContent)
+	mux.HandleFunc("DELETE "+prefix+"/containers/{id}", f.noContent)
+	mux.HandleFunc("POST "+prefix+"/containers/{id}/exec", f.execCreate)
+	mux.HandleFunc("POST "+prefix+"/exec/{id}/start", f.execStart)
+	mux.HandleFunc("GET "+prefix+"/exec/{id}/json", f.execInspect)
+	mux.HandleFunc("GET "+prefix+"/images/json", f.images)
+
+	f.srv = httptest.NewUnstartedServer(mux)
+	f.srv.Listener = ln
+	f.srv.Start()
+	t.Cleanup(func() {
+		f.srv.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return f
+}
+
+// engine creates a Docker API engine for the command,
+// with the step timeouts set to one minute.
+func (f *fakeDocker) engine(sandbox, command string) *Docker {
+	cmd := *dockerCfg.Commands[sandbox][command]
+	withTimeout := func(step *config.Step) *config.Step {
+		if step == nil {
+			return nil
+		}
+		s := *step
+		s.Timeout = 60
+		return &s
+	}
+	cmd.Before = withTimeout(cmd.Before)
+	cmd.After = withTimeout(cmd.After)
+	cmd.Steps = make([]*config.Step, len(cmd.Steps))
+	for i, step := range dockerCfg.Commands[sandbox][command].Steps {
+		cmd.Steps[i] = withTimeout(step)
+	}
+	cfg := *dockerCfg
+	cfg.DockerSocket = f.socket
+	engine := NewDockerAPI(&cfg, sandbox, command).(*Docker)
+	engine.cmd = &cmd
+	return engine
+}
+
+// has reports whether the server received the call.
+func (f *fakeDocker) has(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, call) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDocker) record(r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/"+dockerAPIVersion)
+	f.calls = append(f.calls, r.Method+" "+path)
+}
+
+func (f *fakeDocker) create(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = io.WriteString(w, `{"message":"No such image: codapi/python"}`)
+		return
+	}
+	f.mu.Lock()
+	_ = json.NewDecoder(r.Body).Decode(&f.created)
+	f.exited = make(chan struct{})
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"Id":"%s"}`, r.URL.Query().Get("name"))
+}
+
+func (f *fakeDocker) attach(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	conn := hijackFake(w)
+	f.mu.Lock()
+	f.conn = conn
+	f.mu.Unlock()
+}
+
+func (f *fakeDocker) start(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	f.mu.Lock()
+	conn, exited := f.conn, f.exited
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+	if conn == nil {
+		// detached container
+		return
+	}
+	go func() {
+		defer func() { _ = conn.Close() }()
+		f.output(conn)
+		if !f.hang {
+			close(exited)
+		}
+	}()
+}
+
+func (f *fakeDocker) wait(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	f.mu.Lock()
+	exited := f.exited
+	f.mu.Unlock()
+	select {
+	case <-exited:
+		_, _ = fmt.Fprintf(w, `{"StatusCode":%d}`, f.exitCode)
+	case <-r.Context().Done():
+	}
+}
+
+func (f *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	_, _ = fmt.Fprintf(w, `{"State":{"OOMKilled":%v}}`, f.oom)
+}
+
+func (f *fakeDocker) images(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	_, _ = io.WriteString(w, `[{"RepoTags":["codapi/python:latest"],"RepoDigests":["codapi/python@sha256:abc"]},`+
+		`{"RepoTags":null,"RepoDigests":null}]`)
+}
+
+func (f *fakeDocker) kill(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) noContent(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) execCreate(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = io.WriteString(w, `{"Id":"exec_42"}`)
+}
+
+func (f *fakeDocker) execStart(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	conn := hijackFake(w)
+	defer func() { _ = conn.Close() }()
+	f.output(conn)
+}
+
+func (f *fakeDocker) execInspect(w http.ResponseWriter, r *http.Request) {
+	f.record(r)
+	_, _ = fmt.Fprintf(w, `{"ExitCode":%d}`, f.exitCode)
+}
+
+// output writes the process output to the connection.
+func (f *fakeDocker) output(conn net.Conn) {
+	if f.echo {
+		input, _ := io.ReadAll(conn)
+		writeFrame(conn, streamStdout, string(input))
+	}
+	if f.stdout != "" {
+		writeFrame(conn, streamStdout, f.stdout)
+	}
+	if f.stderr != "" {
+		writeFrame(conn, streamStderr, f.stderr)
+	}
+	if f.hang {
+		// wait until the client disconnects
+		_, _ = io.Copy(io.Discard, conn)
+	}
+}
+
+// hijackFake takes over the connection and confirms the upgrade.
+func hijackFake(w http.ResponseWriter) net.Conn {
+	conn, buf, _ := w.(http.Hijacker).Hijack()
+	_, _ = buf.WriteString("HTTP/1.1 101 UPGRADED\r\n" +
+		"Content-Type: application/vnd.docker.raw-stream\r\n" +
+		"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	_ = buf.Flush()
+	return conn
+}
+
+// writeFrame writes a multiplexed output frame.
+func writeFrame(w io.Writer, stream byte, data string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	_, _ = w.Write(header)
+	_, _ = io.WriteString(w, data)
+}
+
+func TestDockerAPIRun(t *testing.T) {
+	logx.Mock()
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "python",
+		Command: "run",
+		Files:   Files{"": "print('hello world')"},
+		Env:     map[string]string{"LANG": "C"},
+	}
+
+	t.Run("success", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.stdout = "hello world\n"
+		docker.stderr = "warning\n"
+		engine := docker.engine("python", "run")
+
+		out := engine.Exec(req)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, out.OK)
+		be.Equal(t, out.ExitCode, 0)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stdout, "hello world")
+		be.Equal(t, out.Stderr, "warning")
+		be.Equal(t, out.StdoutBytes, int64(12))
+		be.Equal(t, out.Err, nil)
+
+		be.Equal(t, docker.created.Image, "codapi/python")
+		be.Equal(t, docker.created.Cmd, []string{"python", "main.py"})
+		be.Equal(t, docker.created.User, "sandbox")
+		be.Equal(t, docker.created.Env, []string{"LANG=C"})
+		host := docker.created.HostConfig
+		be.Equal(t, host.Runtime, "runc")
+		be.Equal(t, host.NanoCpus, int64(1e9))
+		be.Equal(t, host.Memory, int64(64*1024*1024))
+		be.Equal(t, host.NetworkMode, "none")
+		be.Equal(t, host.PidsLimit, int64(64))
+		be.True(t, host.ReadonlyRootfs)
+		be.Equal(t, len(host.Binds), 1)
+		be.True(t, strings.HasSuffix(host.Binds[0], ":/sandbox:ro"))
+		be.Equal(t, host.AutoRemove, false)
+
+		for _, call := range []string{
+			"POST /containers/create", "POST /containers/http_42/attach",
+			"POST /containers/http_42/start", "POST /containers/http_42/wait",
+			"GET /containers/http_42/json", "DELETE /containers/http_42",
+		} {
+			be.True(t, docker.has(call))
+		}
+	})
+	t.Run("exit code", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.exitCode = 3
+		engine := docker.engine("python", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, 3)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stderr, "exit status 3")
+		be.Equal(t, out.Err, nil)
+	})
+	t.Run("oom killed", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.exitCode = 137
+		docker.oom = true
+		engine := docker.engine("python", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.ExitCode, 137)
+		be.Equal(t, out.Reason, ReasonOOMKilled)
+	})
+	t.Run("killed", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.exitCode = 137
+		engine := docker.engine("python", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.ExitCode, 137)
+		be.Equal(t, out.Reason, ReasonExit)
+	})
+	t.Run("output truncated", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.stdout = strings.Repeat("a", 5000)
+		engine := docker.engine("python", "run")
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.Reason, ReasonOutputTruncated)
+		be.Equal(t, len(out.Stdout), 4096)
+		be.True(t, out.StdoutTruncated)
+		be.Equal(t, out.StdoutBytes, int64(5000))
+	})
+	t.Run("stdin", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.echo = true
+		engine := docker.engine("python", "run")
+		in := req
+		in.Stdin = "hello"
+		out := engine.Exec(in)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello")
+		be.True(t, docker.created.OpenStdin)
+		be.True(t, docker.has("POST /containers/http_42/attach"))
+	})
+	t.Run("stream", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.stdout = "hello"
+		docker.stderr = "oops"
+		engine := docker.engine("python", "run")
+		var stdout, stderr strings.Builder
+		stream := &Stream{Stdout: &stdout, Stderr: &stderr}
+		out := engine.ExecStream(context.Background(), req, stream)
+		be.True(t, out.OK)
+		be.Equal(t, stdout.String(), "hello")
+		be.Equal(t, stderr.String(), "oops")
+	})
+	t.Run("canceled", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.hang = true
+		engine := docker.engine("python", "run")
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		out := engine.ExecStream(ctx, req, nil)
+		be.Equal(t, out.Reason, ReasonCanceled)
+		be.True(t, docker.has("POST /containers/http_42/kill"))
+		be.True(t, docker.has("DELETE /containers/http_42"))
+	})
+	t.Run("api error", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		docker.status = http.StatusNotFound
+		engine := docker.engine("python", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Reason, ReasonInternal)
+		be.Err(t, out.Err, "No such image: codapi/python")
+	})
+	t.Run("no daemon", func(t *testing.T) {
+		engine := newFakeDocker(t).engine("python", "run")
+		engine.api = newDockerClient(filepath.Join(t.TempDir(), "missing.sock"))
+		out := engine.Exec(req)
+		be.Equal(t, out.Reason, ReasonInternal)
+	})
+}
+
+func TestDockerAPISession(t *testing.T) {
+	logx.Mock()
+	docker := newFakeDocker(t)
+	docker.stdout = "hello"
+	engine := docker.engine("alpine", "echo")
+
+	sess := Request{ID: "alpine_session_42", Sandbox: "alpine", Command: "echo"}
+	out := engine.OpenSession(context.Background(), sess)
+	be.True(t, out.OK)
+	be.Equal(t, out.Stdout, "alpine_session_42")
+	be.True(t, docker.created.HostConfig.AutoRemove)
+	be.True(t, docker.has("POST /containers/alpine_session_42/start"))
+	be.Equal(t, docker.has("POST /containers/alpine_session_42/attach"), false)
+
+	req := Request{ID: "alpine_42", Sandbox: "alpine", Command: "echo", Files: Files{"": "echo hello"}}
+	out = engine.ExecSession(context.Background(), sess.ID, req, nil)
+	be.Equal(t, out.ID, req.ID)
+	be.True(t, out.OK)
+	be.Equal(t, out.Stdout, "hello")
+	be.True(t, docker.has("POST /containers/alpine_session_42/exec"))
+	be.True(t, docker.has("POST /exec/exec_42/start"))
+	be.True(t, docker.has("GET /exec/exec_42/json"))
+
+	out = engine.CloseSession(sess)
+	be.True(t, out.OK)
+	be.True(t, docker.has("POST /containers/alpine_session_42/stop"))
+}
+
+func TestDockerAPI_Images(t *testing.T) {
+	t.Run("list", func(t *testing.T) {
+		docker := newFakeDocker(t)
+		engine := docker.engine("python", "run")
+		images, err := engine.Images()
+		be.Err(t, err, nil)
+		be.Equal(t, images, []string{"codapi/python:latest", "codapi/python@sha256:abc"})
+	})
+	t.Run("unavailable", func(t *testing.T) {
+		engine := NewDockerAPI(dockerCfg, "python", "run").(*Docker)
+		engine.api = newDockerClient(filepath.Join(t.TempDir(), "docker.sock"))
+		_, err := engine.Images()
+		be.True(t, err != nil)
+	})
+}
+
+func Test_dockerContainerConfig(t *testing.T) {
+	box := &config.Box{
+		Image: "codapi/alpine",
+		Host: config.Host{
+			CPU: 2, Memory: 128, Network: "none", Writable: true,
+			Storage: "100m", Volume: "%s:/sandbox",
+			Tmpfs:  []string{"/tmp:rw,size=16m", "/run"},
+			CapAdd: []string{"SYS_PTRACE"}, CapDrop: []string{"all"},
+			Ulimit: []string{"nofile=96", "core=0:1"},
+		},
+	}
+	step := &config.Step{User: "sandbox", Command: []string{"sh", ":args"}}
+	req := Request{ID: "http_42", Args: []string{"-c", "ls"}}
+
+	t.Run("valid", func(t *testing.T) {
+		cfg, err := dockerContainerConfig(box, step, req, "/tmp/dir", false)
+		be.Err(t, err, nil)
+		be.Equal(t, cfg.Cmd, []string{"sh", "-c", "ls"})
+		be.Equal(t, cfg.OpenStdin, false)
+		host := cfg.HostConfig
+		be.Equal(t, host.NanoCpus, int64(2e9))
+		be.Equal(t, host.ReadonlyRootfs, false)
+		be.Equal(t, host.StorageOpt, map[string]string{"size": "100m"})
+		be.Equal(t, host.Binds, []string{"/tmp/dir:/sandbox"})
+		be.Equal(t, host.Tmpfs, map[string]string{"/tmp": "rw,size=16m", "/run": ""})
+		be.Equal(t, host.Ulimits, []dockerUlimit{
+			{Name: "nofile", Soft: 96, Hard: 96},
+			{Name: "core", Soft: 0, Hard: 1},
+		})
+	})
+	t.Run("invalid ulimit", func(t *testing.T) {
+		b := *box
+		b.Ulimit = []string{"nofile=many"}
+		_, err := dockerContainerConfig(&b, step, req, "/tmp/dir", false)
+		be.Err(t, err, "invalid ulimit: nofile=many")
+	})
+}
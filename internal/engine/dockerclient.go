@@ -0,0 +1,363 @@
+// Docker Engine API client.
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// defaultDockerSocket is the Docker Engine API socket
+// used if not set in the configuration.
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// dockerAPIVersion is the Docker Engine API version
+// (supported by Docker 20.10 and later).
+const dockerAPIVersion = "v1.41"
+
+// Multiplexed output stream types.
+const (
+	streamStdout = 1
+	streamStderr = 2
+)
+
+// A dockerClient talks to the Docker Engine API over a unix socket.
+type dockerClient struct {
+	socket string
+	http   *http.Client
+}
+
+// A containerConfig describes a container to create.
+type containerConfig struct {
+	Image        string
+	Cmd          []string
+	User         string `json:",omitempty"`
+	Env          []string
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	OpenStdin    bool
+	StdinOnce    bool
+	HostConfig   hostConfig
+}
+
+// A hostConfig describes the container resources and mounts.
+type hostConfig struct {
+	Runtime        string            `json:",omitempty"`
+	NanoCpus       int64             `json:",omitempty"`
+	Memory         int64             `json:",omitempty"`
+	NetworkMode    string            `json:",omitempty"`
+	PidsLimit      int64             `json:",omitempty"`
+	ReadonlyRootfs bool              `json:",omitempty"`
+	StorageOpt     map[string]string `json:",omitempty"`
+	Binds          []string          `json:",omitempty"`
+	Tmpfs          map[string]string `json:",omitempty"`
+	CapAdd         []string          `json:",omitempty"`
+	CapDrop        []string          `json:",omitempty"`
+	Ulimits        []dockerUlimit    `json:",omitempty"`
+	AutoRemove     bool              `json:",omitempty"`
+}
+
+// A dockerUlimit is a resource limit of the container processes.
+type dockerUlimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// An execConfig describes a process to execute in a running container.
+type execConfig struct {
+	Cmd          []string
+	User         string `json:",omitempty"`
+	Env          []string
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+}
+
+// A hijackedConn is a connection taken over from the HTTP client
+// to stream the process input and output.
+type hijackedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read reads the process output, including the part
+// buffered while reading the response headers.
+func (c *hijackedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite signals the end of the process input.
+func (c *hijackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// newDockerClient creates a client for the socket.
+func newDockerClient(socket string) *dockerClient {
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+	return &dockerClient{
+		socket: socket,
+		http:   &http.Client{Transport: &http.Transport{DialContext: dial}},
+	}
+}
+
+// create creates a container and returns its id.
+func (c *dockerClient) create(ctx context.Context, name string, cfg containerConfig) (string, error) {
+	var resp struct{ Id string }
+	err := c.do(ctx, http.MethodPost, "/containers/create?name="+url.QueryEscape(name), cfg, &resp)
+	return resp.Id, err
+}
+
+// attach attaches to the container input (if stdin is true) and output.
+// Must be called before starting the container to receive all the output.
+func (c *dockerClient) attach(ctx context.Context, id string, stdin bool) (*hijackedConn, error) {
+	query := url.Values{"stream": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	if stdin {
+		query.Set("stdin", "1")
+	}
+	return c.hijack(ctx, http.MethodPost, "/containers/"+id+"/attach?"+query.Encode(), nil)
+}
+
+// start starts the container.
+func (c *dockerClient) start(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil)
+}
+
+// wait waits for the container to exit and returns its exit code.
+func (c *dockerClient) wait(ctx context.Context, id string) (int, error) {
+	var resp struct {
+		StatusCode int
+		Error      *struct{ Message string }
+	}
+	err := c.do(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, &resp)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != nil && resp.Error.Message != "" {
+		return 0, errors.New(resp.Error.Message)
+	}
+	return resp.StatusCode, nil
+}
+
+// oomKilled reports whether the container was killed
+// for exceeding the memory limit.
+func (c *dockerClient) oomKilled(ctx context.Context, id string) (bool, error) {
+	var resp struct {
+		State struct{ OOMKilled bool }
+	}
+	err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json", nil, &resp)
+	return resp.State.OOMKilled, err
+}
+
+// kill kills the container.
+func (c *dockerClient) kill(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/kill", nil, nil)
+}
+
+// stop stops the container.
+func (c *dockerClient) stop(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/stop", nil, nil)
+}
+
+// remove forcibly removes the container.
+func (c *dockerClient) remove(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/containers/"+id+"?force=1", nil, nil)
+}
+
+// images returns the references of the local images,
+// both as repository:tag and repository@digest.
+func (c *dockerClient) images(ctx context.Context) ([]string, error) {
+	var resp []struct {
+		RepoTags    []string
+		RepoDigests []string
+	}
+	err := c.do(ctx, http.MethodGet, "/images/json", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, image := range resp {
+		refs = append(refs, image.RepoTags...)
+		refs = append(refs, image.RepoDigests...)
+	}
+	return refs, nil
+}
+
+// execCreate creates a process in the running container
+// and returns the process id.
+func (c *dockerClient) execCreate(ctx context.Context, container string, cfg execConfig) (string, error) {
+	var resp struct{ Id string }
+	err := c.do(ctx, http.MethodPost, "/containers/"+container+"/exec", cfg, &resp)
+	return resp.Id, err
+}
+
+// execStart starts the process and attaches to its input and output.
+func (c *dockerClient) execStart(ctx context.Context, id string) (*hijackedConn, error) {
+	body := map[string]bool{"Detach": false, "Tty": false}
+	return c.hijack(ctx, http.MethodPost, "/exec/"+id+"/start", body)
+}
+
+// execExitCode returns the exit code of the finished process.
+func (c *dockerClient) execExitCode(ctx context.Context, id string) (int, error) {
+	var resp struct{ ExitCode int }
+	err := c.do(ctx, http.MethodGet, "/exec/"+id+"/json", nil, &resp)
+	return resp.ExitCode, err
+}
+
+// do sends the request with the JSON body (if any)
+// and decodes the JSON response into out (if any).
+func (c *dockerClient) do(ctx context.Context, method, path string, in, out any) error {
+	req, err := c.newRequest(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode != http.StatusNotModified {
+		return dockerError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// hijack sends the request and takes over the connection
+// to stream the process input and output.
+func (c *dockerClient) hijack(ctx context.Context, method, path string, in any) (*hijackedConn, error) {
+	req, err := c.newRequest(ctx, method, path, in)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("connection", "Upgrade")
+	req.Header.Set("upgrade", "tcp")
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socket)
+	if err != nil {
+		return nil, err
+	}
+	err = req.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		err = dockerError(resp)
+		_ = conn.Close()
+		return nil, err
+	}
+	return &hijackedConn{Conn: conn, r: r}, nil
+}
+
+// newRequest creates an API request with the JSON body (if any).
+func (c *dockerClient) newRequest(ctx context.Context, method, path string, in any) (*http.Request, error) {
+	logx.Debug("docker api: %s %s", method, path)
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	uri := "http://docker/" + dockerAPIVersion + path
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	return req, nil
+}
+
+// dockerError returns the error described in the API response.
+func dockerError(resp *http.Response) error {
+	var body struct{ Message string }
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) == nil && body.Message != "" {
+		return fmt.Errorf("%s (%s)", body.Message, resp.Status)
+	}
+	return errors.New(resp.Status)
+}
+
+// demux splits the multiplexed process output into stdout and stderr.
+// Each frame starts with an 8-byte header: the stream type,
+// three zero bytes and the big-endian frame size.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, header)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var w io.Writer
+		switch header[0] {
+		case streamStdout:
+			w = stdout
+		case streamStderr:
+			w = stderr
+		default:
+			w = io.Discard
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		_, err = io.CopyN(w, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseUlimit parses the ulimit in the `docker run --ulimit`
+// format: name=limit or name=soft:hard.
+func parseUlimit(s string) (dockerUlimit, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return dockerUlimit{}, fmt.Errorf("invalid ulimit: %s", s)
+	}
+	softStr, hardStr, hasHard := strings.Cut(value, ":")
+	if !hasHard {
+		hardStr = softStr
+	}
+	soft, err := strconv.ParseInt(softStr, 10, 64)
+	if err != nil {
+		return dockerUlimit{}, fmt.Errorf("invalid ulimit: %s", s)
+	}
+	hard, err := strconv.ParseInt(hardStr, 10, 64)
+	if err != nil {
+		return dockerUlimit{}, fmt.Errorf("invalid ulimit: %s", s)
+	}
+	return dockerUlimit{Name: name, Soft: soft, Hard: hard}, nil
+}
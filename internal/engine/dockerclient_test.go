@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_demux(t *testing.T) {
+	t.Run("streams", func(t *testing.T) {
+		var in bytes.Buffer
+		writeFrame(&in, streamStdout, "hello ")
+		writeFrame(&in, streamStderr, "oops")
+		writeFrame(&in, streamStdout, "world")
+		writeFrame(&in, 0, "ignored")
+		var stdout, stderr strings.Builder
+		err := demux(&in, &stdout, &stderr)
+		be.Err(t, err, nil)
+		be.Equal(t, stdout.String(), "hello world")
+		be.Equal(t, stderr.String(), "oops")
+	})
+	t.Run("empty", func(t *testing.T) {
+		var stdout, stderr strings.Builder
+		err := demux(strings.NewReader(""), &stdout, &stderr)
+		be.Err(t, err, nil)
+		be.Equal(t, stdout.String(), "")
+	})
+	t.Run("truncated frame", func(t *testing.T) {
+		var in bytes.Buffer
+		writeFrame(&in, streamStdout, "hello")
+		in.Truncate(in.Len() - 2)
+		var stdout, stderr strings.Builder
+		err := demux(&in, &stdout, &stderr)
+		be.Err(t, err)
+		be.Equal(t, stdout.String(), "hel")
+	})
+}
+
+func Test_parseUlimit(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		lim, err := parseUlimit("nofile=96")
+		be.Err(t, err, nil)
+		be.Equal(t, lim, dockerUlimit{Name: "nofile", Soft: 96, Hard: 96})
+
+		lim, err = parseUlimit("nofile=64:128")
+		be.Err(t, err, nil)
+		be.Equal(t, lim, dockerUlimit{Name: "nofile", Soft: 64, Hard: 128})
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{"nofile", "=96", "nofile=", "nofile=64:", "nofile=64x"} {
+			_, err := parseUlimit(s)
+			be.Err(t, err, "invalid ulimit: "+s)
+		}
+	})
+}
@@ -2,19 +2,30 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/nalgeon/codapi/internal/stringx"
 )
 
 // A Request initiates code execution.
 type Request struct {
-	ID      string `json:"id"`
-	Sandbox string `json:"sandbox"`
-	Version string `json:"version,omitempty"`
-	Command string `json:"command"`
-	Files   Files  `json:"files"`
+	ID      string            `json:"id"`
+	Sandbox string            `json:"sandbox"`
+	Version string            `json:"version,omitempty"`
+	Command string            `json:"command"`
+	Files   Files             `json:"files"`
+	Stdin   string            `json:"stdin,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// Name of the API key the request is authorized with (if any).
+	Key string `json:"-"`
+	// Client IP address.
+	Client string `json:"-"`
+	// ID of the session the request is executed in (if any).
+	Session string `json:"-"`
 }
 
 // GenerateID() sets a unique ID for the request.
@@ -31,18 +42,82 @@ type Execution struct {
 	ID       string `json:"id"`
 	OK       bool   `json:"ok"`
 	Duration int    `json:"duration"`
+	Queued   int    `json:"queued,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Reason   string `json:"reason"`
 	Stdout   string `json:"stdout"`
 	Stderr   string `json:"stderr"`
-	Err      error  `json:"-"`
+	Output
+	Files Files `json:"files,omitempty"`
+	Err   error `json:"-"`
 }
 
+// Execution termination reasons.
+const (
+	// The code ran to completion and exited on its own
+	// (with a zero or non-zero exit code).
+	ReasonExit = "exit"
+	// The code was stopped because it exceeded the time limit.
+	ReasonTimeout = "timeout"
+	// The code was killed because it exceeded the memory limit.
+	ReasonOOMKilled = "oom_killed"
+	// The code was killed with SIGKILL for an unknown reason
+	// (e.g. by the kernel for exceeding the memory limit).
+	ReasonKilled = "killed"
+	// The code exited on its own, but its output exceeded
+	// the size limit and was truncated.
+	ReasonOutputTruncated = "output_truncated"
+	// The execution failed due to the application problem,
+	// not due to the problem with the code.
+	ReasonInternal = "internal"
+	// The execution was canceled by the client.
+	ReasonCanceled = "canceled"
+	// The execution was rejected because there were no free workers.
+	ReasonBusy = "busy"
+	// The execution was rejected because the request was invalid.
+	ReasonInvalid = "invalid"
+)
+
+// exitCodeKilled is the exit code Docker reports when
+// the container process is killed with SIGKILL.
+const exitCodeKilled = 137
+
 // An ErrTimeout is returned if code execution did not complete
 // in the allowed timeframe.
 var ErrTimeout = errors.New("code execution timeout")
 
+// An ErrCanceled is returned if code execution was canceled
+// before it completed.
+var ErrCanceled = errors.New("code execution canceled")
+
+// An ErrNoSessions is returned if the command does not support sessions.
+var ErrNoSessions = errors.New("command does not support sessions")
+
 // An ErrBusy is returned when there are no engines available.
 var ErrBusy = errors.New("busy: try again later")
 
+// A BusyError is returned when a specific concurrency limit is reached.
+type BusyError struct {
+	limit string
+}
+
+func NewBusyError(limit string) BusyError {
+	return BusyError{limit: limit}
+}
+
+func (err BusyError) Error() string {
+	return "busy (" + err.limit + " limit reached): try again later"
+}
+
+func (err BusyError) Unwrap() error {
+	return ErrBusy
+}
+
+// Limit returns the name of the reached limit.
+func (err BusyError) Limit() string {
+	return err.limit
+}
+
 // An ExecutionError is returned if code execution failed
 // due to the application problems, not due to the problems with the code.
 type ExecutionError struct {
@@ -107,34 +182,92 @@ func (f Files) Count() int {
 	return len(f)
 }
 
+// A Stream connects the running program with the client.
+// Stdin (optional) provides interactive input to the program.
+// Stdout and Stderr receive the program output as it is produced,
+// and can be written to concurrently.
+type Stream struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
 // An Engine executes a specific sandbox command on the code.
 // Engines must be concurrent-safe, since they can be accessed by multiple goroutines.
 type Engine interface {
 	// Exec executes the command and returns the output.
 	Exec(req Request) Execution
+	// ExecStream executes the command, writing the output
+	// to the stream as it is produced, and returns the final output.
+	// Stops the execution and returns ErrCanceled if the context is canceled.
+	// The stream can be nil, in which case the output is only returned.
+	ExecStream(ctx context.Context, req Request, stream *Stream) Execution
+}
+
+// A SessionEngine is an engine that can keep the execution environment
+// (e.g. a container) alive across multiple requests.
+type SessionEngine interface {
+	Engine
+	// SupportsSessions reports whether the command can open sessions.
+	SupportsSessions() bool
+	// OpenSession starts the execution environment for the session.
+	OpenSession(ctx context.Context, req Request) Execution
+	// ExecSession executes the command in the session's environment.
+	// The req.ID is the execution ID, not the session one.
+	ExecSession(ctx context.Context, session string, req Request, stream *Stream) Execution
+	// CloseSession stops the execution environment of the session.
+	CloseSession(req Request) Execution
+}
+
+// An ImageLister is an engine that runs code in container images
+// (docker, podman or docker-api).
+type ImageLister interface {
+	// Images returns the references of the images available locally.
+	// Fails if the container engine does not respond.
+	Images() ([]string, error)
 }
 
 // Fail creates an output from an error.
+// The exit code is -1, since the process did not exit on its own
+// (or did not start at all).
 func Fail(id string, err error) Execution {
 	if _, ok := err.(ExecutionError); ok {
 		return Execution{
-			ID:     id,
-			OK:     false,
-			Stderr: "internal error",
-			Err:    err,
+			ID:       id,
+			OK:       false,
+			ExitCode: -1,
+			Reason:   ReasonInternal,
+			Stderr:   "internal error",
+			Err:      err,
 		}
 	}
 	if errors.Is(err, ErrBusy) {
 		return Execution{
-			ID:     id,
-			OK:     false,
-			Stderr: err.Error(),
-			Err:    err,
+			ID:       id,
+			OK:       false,
+			ExitCode: -1,
+			Reason:   ReasonBusy,
+			Stderr:   err.Error(),
+			Err:      err,
 		}
 	}
 	return Execution{
-		ID:     id,
-		OK:     false,
-		Stderr: err.Error(),
+		ID:       id,
+		OK:       false,
+		ExitCode: -1,
+		Reason:   failReason(err),
+		Stderr:   err.Error(),
+	}
+}
+
+// failReason returns the termination reason for the error.
+func failReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return ReasonTimeout
+	case errors.Is(err, ErrCanceled):
+		return ReasonCanceled
+	default:
+		return ReasonInvalid
 	}
 }
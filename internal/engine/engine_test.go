@@ -35,6 +35,13 @@ func TestExecutionError(t *testing.T) {
 	be.Err(t, err, inner)
 }
 
+func TestBusyError(t *testing.T) {
+	err := NewBusyError("sandbox python")
+	be.Err(t, err, ErrBusy)
+	be.Equal(t, err.Limit(), "sandbox python")
+	be.Equal(t, err.Error(), "busy (sandbox python limit reached): try again later")
+}
+
 func TestFiles_Count(t *testing.T) {
 	var files Files = map[string]string{
 		"first":  "alice",
@@ -87,6 +94,8 @@ func TestFail(t *testing.T) {
 		be.Equal(t, out.OK, false)
 		be.Equal(t, out.Stderr, "internal error")
 		be.Equal(t, out.Stdout, "")
+		be.Equal(t, out.ExitCode, -1)
+		be.Equal(t, out.Reason, ReasonInternal)
 		be.Err(t, out.Err, err)
 	})
 	t.Run("ErrBusy", func(t *testing.T) {
@@ -96,8 +105,19 @@ func TestFail(t *testing.T) {
 		be.Equal(t, out.OK, false)
 		be.Equal(t, out.Stderr, err.Error())
 		be.Equal(t, out.Stdout, "")
+		be.Equal(t, out.Reason, ReasonBusy)
 		be.Err(t, out.Err, err)
 	})
+	t.Run("ErrTimeout", func(t *testing.T) {
+		out := Fail("42", ErrTimeout)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, -1)
+		be.Equal(t, out.Reason, ReasonTimeout)
+	})
+	t.Run("ErrCanceled", func(t *testing.T) {
+		out := Fail("42", ErrCanceled)
+		be.Equal(t, out.Reason, ReasonCanceled)
+	})
 	t.Run("Error", func(t *testing.T) {
 		err := errors.New("user error")
 		out := Fail("42", err)
@@ -105,6 +125,7 @@ func TestFail(t *testing.T) {
 		be.Equal(t, out.OK, false)
 		be.Equal(t, out.Stderr, err.Error())
 		be.Equal(t, out.Stdout, "")
+		be.Equal(t, out.Reason, ReasonInvalid)
 		be.Err(t, out.Err, nil)
 	})
 }
@@ -3,8 +3,10 @@ package engine
 import (
 	"context"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nalgeon/codapi/internal/execy"
@@ -13,18 +15,77 @@ import (
 
 // A Program is an executable program.
 type Program struct {
+	ctx     context.Context
 	timeout time.Duration
 	nOutput int64
+	marker  string
+	stream  *Stream
+	dir     string
+	env     []string
+	attr    *syscall.SysProcAttr
+	output  Output
+}
+
+// An Output describes the output produced by a program.
+type Output struct {
+	StdoutTruncated bool  `json:"stdout_truncated"`
+	StderrTruncated bool  `json:"stderr_truncated"`
+	StdoutBytes     int64 `json:"stdout_bytes"`
+	StderrBytes     int64 `json:"stderr_bytes"`
 }
 
 // NewProgram creates a new program.
 func NewProgram(timeoutSec int, nOutput int64) *Program {
 	return &Program{
+		ctx:     context.Background(),
 		timeout: time.Duration(timeoutSec) * time.Second,
 		nOutput: nOutput,
 	}
 }
 
+// WithContext makes the program stop (kill the process)
+// when the context is canceled, even before the timeout.
+func (p *Program) WithContext(ctx context.Context) *Program {
+	p.ctx = ctx
+	return p
+}
+
+// WithStream makes the program write its output
+// to the stream as it is produced (in addition to returning it).
+// The stream receives the same data as the returned output,
+// so the output limit applies to it as well.
+func (p *Program) WithStream(stream *Stream) *Program {
+	p.stream = stream
+	return p
+}
+
+// WithMarker makes the program append the marker
+// to the output (stdout or stderr) that exceeds the limit.
+func (p *Program) WithMarker(marker string) *Program {
+	p.marker = marker
+	return p
+}
+
+// WithDir makes the program run in the directory.
+func (p *Program) WithDir(dir string) *Program {
+	p.dir = dir
+	return p
+}
+
+// WithEnv makes the program run with the environment variables
+// (NAME=value) instead of inheriting the current ones.
+func (p *Program) WithEnv(env []string) *Program {
+	p.env = env
+	return p
+}
+
+// WithSysProcAttr makes the program start
+// with the OS-specific process attributes.
+func (p *Program) WithSysProcAttr(attr *syscall.SysProcAttr) *Program {
+	p.attr = attr
+	return p
+}
+
 // Run starts the program and waits for it to complete (or timeout).
 func (p *Program) Run(id, name string, arg ...string) (stdout string, stderr string, err error) {
 	return p.RunStdin(nil, id, name, arg...)
@@ -33,22 +94,75 @@ func (p *Program) Run(id, name string, arg ...string) (stdout string, stderr str
 // RunStdin starts the program with data from stdin
 // and waits for it to complete (or timeout).
 func (p *Program) RunStdin(stdin io.Reader, id, name string, arg ...string) (stdout string, stderr string, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
 	defer cancel()
 
 	var cmdout, cmderr strings.Builder
 	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Dir = p.dir
+	cmd.Env = p.env
+	cmd.SysProcAttr = p.attr
 	cmd.Cancel = func() error {
 		err := cmd.Process.Kill()
-		logx.Debug("%s: execution timeout, killed process=%d, err=%v", id, cmd.Process.Pid, err)
+		logx.Debug("%s: execution stopped (%v), killed process=%d, err=%v", id, ctx.Err(), cmd.Process.Pid, err)
 		return err
 	}
 
-	cmd.Stdin = stdin
-	cmd.Stdout = LimitWriter(&cmdout, p.nOutput)
-	cmd.Stderr = LimitWriter(&cmderr, p.nOutput)
+	if stdin != nil {
+		// pass stdin through an OS pipe, so that waiting for the program
+		// does not depend on the reader, which can block indefinitely
+		// in case of interactive input
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return "", "", err
+		}
+		defer func() { _ = pr.Close() }()
+		go func() {
+			_, _ = io.Copy(pw, stdin)
+			_ = pw.Close()
+		}()
+		cmd.Stdin = pr
+	}
+
+	outw := LimitWriterMarker(TeeWriter(&cmdout, p.stdout()), p.nOutput, p.marker)
+	errw := LimitWriterMarker(TeeWriter(&cmderr, p.stderr()), p.nOutput, p.marker)
+	cmd.Stdout = outw
+	cmd.Stderr = errw
 	err = execy.Run(cmd)
+	p.output = Output{
+		StdoutTruncated: outw.Truncated(),
+		StderrTruncated: errw.Truncated(),
+		StdoutBytes:     outw.Total(),
+		StderrBytes:     errw.Total(),
+	}
 	stdout = strings.TrimSpace(cmdout.String())
 	stderr = strings.TrimSpace(cmderr.String())
 	return
 }
+
+// Truncated reports whether the output of the last run
+// exceeded the limit and was truncated.
+func (p *Program) Truncated() bool {
+	return p.output.StdoutTruncated || p.output.StderrTruncated
+}
+
+// Output describes the output of the last run.
+func (p *Program) Output() Output {
+	return p.output
+}
+
+// stdout returns the stream's stdout writer (if any).
+func (p *Program) stdout() io.Writer {
+	if p.stream == nil {
+		return nil
+	}
+	return p.stream.Stdout
+}
+
+// stderr returns the stream's stderr writer (if any).
+func (p *Program) stderr() io.Writer {
+	if p.stream == nil {
+		return nil
+	}
+	return p.stream.Stderr
+}
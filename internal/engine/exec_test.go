@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"strings"
 	"testing"
 
@@ -44,16 +46,60 @@ func TestProgram_LimitOutput(t *testing.T) {
 		p := NewProgram(3, nOutput)
 		stdout, _, _ := p.Run("mock_42", "mock", "stdout")
 		be.Equal(t, stdout, "12345")
+		be.True(t, p.Truncated())
+	}
+	{
+		p := NewProgram(3, 10)
+		stdout, _, _ := p.Run("mock_42", "mock", "stdout")
+		be.Equal(t, stdout, "1234567890")
+		be.Equal(t, p.Truncated(), false)
 	}
 	{
 		p := NewProgram(3, nOutput)
 		_, stderr, _ := p.Run("mock_42", "mock", "stderr")
 		be.Equal(t, stderr, "12345")
+		be.Equal(t, p.Output(), Output{StderrTruncated: true, StderrBytes: 10})
 	}
 	{
 		p := NewProgram(3, nOutput)
 		stdout, stderr, _ := p.Run("mock_42", "mock", "outerr")
 		be.Equal(t, stdout, "12345")
 		be.Equal(t, stderr, "09876")
+		want := Output{
+			StdoutTruncated: true, StderrTruncated: true,
+			StdoutBytes: 10, StderrBytes: 10,
+		}
+		be.Equal(t, p.Output(), want)
+	}
+	{
+		p := NewProgram(3, nOutput).WithMarker("[truncated]")
+		stdout, stderr, _ := p.Run("mock_42", "mock", "stdout")
+		be.Equal(t, stdout, "12345[truncated]")
+		be.Equal(t, stderr, "")
+	}
+}
+
+func TestProgram_Stream(t *testing.T) {
+	commands := map[string]execy.CmdOut{
+		"mock outerr": {Stdout: "1234567890", Stderr: "0987654321"},
 	}
+	execy.Mock(commands)
+
+	var stdout, stderr bytes.Buffer
+	stream := &Stream{Stdout: &stdout, Stderr: &stderr}
+	p := NewProgram(3, 5).WithMarker("...").WithStream(stream)
+	out, err, _ := p.Run("mock_42", "mock", "outerr")
+	be.Equal(t, out, "12345...")
+	be.Equal(t, err, "09876...")
+	be.Equal(t, stdout.String(), "12345...")
+	be.Equal(t, stderr.String(), "09876...")
+}
+
+func TestProgram_RunStdin(t *testing.T) {
+	execy.Mock(nil)
+	p := NewProgram(3, 100)
+	// a reader that never returns must not block the program
+	stdin, _ := io.Pipe()
+	_, _, err := p.RunStdin(stdin, "mock_42", "mock", "stdin")
+	be.Err(t, err, nil)
 }
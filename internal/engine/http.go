@@ -3,6 +3,7 @@ package engine
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -31,12 +32,29 @@ func NewHTTP(cfg *config.Config, sandbox, command string) Engine {
 // Exec sends an HTTP request according to the spec
 // and returns the response as text with status, headers and body.
 func (e *HTTP) Exec(req Request) Execution {
+	return e.ExecStream(context.Background(), req, nil)
+}
+
+// ExecStream sends an HTTP request according to the spec,
+// and writes the response to the stream once it is received.
+func (e *HTTP) ExecStream(ctx context.Context, req Request, stream *Stream) Execution {
+	out := e.exec(ctx, req)
+	if stream != nil && stream.Stdout != nil && out.OK {
+		_, _ = io.WriteString(stream.Stdout, out.Stdout)
+	}
+	return out
+}
+
+// exec sends an HTTP request according to the spec
+// and returns the response as text with status, headers and body.
+func (e *HTTP) exec(ctx context.Context, req Request) Execution {
 	// build request from spec
 	httpReq, err := e.parse(req.Files.First())
 	if err != nil {
 		err = fmt.Errorf("parse spec: %w", err)
 		return Fail(req.ID, err)
 	}
+	httpReq = httpReq.WithContext(ctx)
 
 	// send request and receive response
 	allowed := e.translateHost(httpReq)
@@ -47,6 +65,9 @@ func (e *HTTP) Exec(req Request) Execution {
 
 	logx.Log("%s: %s %s", req.ID, httpReq.Method, httpReq.URL.String())
 	resp, err := httpx.Do(httpReq)
+	if err != nil && ctx.Err() != nil {
+		return Fail(req.ID, ErrCanceled)
+	}
 	if err != nil {
 		err = fmt.Errorf("http request: %w", err)
 		return Fail(req.ID, err)
@@ -65,7 +86,9 @@ func (e *HTTP) Exec(req Request) Execution {
 	return Execution{
 		ID:     req.ID,
 		OK:     true,
+		Reason: ReasonExit,
 		Stdout: stdout,
+		Output: Output{StdoutBytes: int64(len(stdout))},
 	}
 }
 
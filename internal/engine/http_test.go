@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"context"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/nalgeon/be"
@@ -62,6 +64,26 @@ hello`
 	})
 }
 
+func TestHTTP_ExecStream(t *testing.T) {
+	logx.Mock()
+	httpx.Mock()
+	engine := NewHTTP(httpCfg, "http", "run")
+
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "http",
+		Command: "run",
+		Files: map[string]string{
+			"": "GET https://codapi.org/example.txt",
+		},
+	}
+	var stdout, stderr strings.Builder
+	out := engine.ExecStream(context.Background(), req, &Stream{Stdout: &stdout, Stderr: &stderr})
+	be.True(t, out.OK)
+	be.Equal(t, stdout.String(), out.Stdout)
+	be.Equal(t, stderr.String(), "")
+}
+
 func TestHTTP_parse(t *testing.T) {
 	logx.Mock()
 	httpx.Mock()
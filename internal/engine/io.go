@@ -4,28 +4,97 @@ import "io"
 
 // A LimitedWriter writes to w but limits the amount
 // of data to only n bytes. After reaching the limit,
-// silently discards the rest of the data without errors.
+// writes the marker (if any) once and silently discards
+// the rest of the data without errors.
 type LimitedWriter struct {
-	w io.Writer
-	n int64
+	w         io.Writer
+	n         int64
+	marker    string
+	total     int64
+	truncated bool
 }
 
 // LimitWriter returns a writer that writes no more
 // than n bytes and silently discards the rest.
 func LimitWriter(w io.Writer, n int64) io.Writer {
-	return &LimitedWriter{w, n}
+	return &LimitedWriter{w: w, n: n}
+}
+
+// LimitWriterMarker returns a writer that writes no more
+// than n bytes, followed by the marker if some of the data
+// was discarded.
+func LimitWriterMarker(w io.Writer, n int64, marker string) *LimitedWriter {
+	return &LimitedWriter{w: w, n: n, marker: marker}
 }
 
 // Write implements the io.Writer interface.
 func (w *LimitedWriter) Write(p []byte) (int, error) {
 	lenp := len(p)
-	if w.n <= 0 {
-		return lenp, nil
+	w.total += int64(lenp)
+	if lenp > 0 && w.n <= 0 {
+		return lenp, w.truncate()
 	}
 	if int64(lenp) > w.n {
-		p = p[:w.n]
+		n, err := w.w.Write(p[:w.n])
+		w.n -= int64(n)
+		if err != nil {
+			return lenp, err
+		}
+		return lenp, w.truncate()
 	}
 	n, err := w.w.Write(p)
 	w.n -= int64(n)
 	return lenp, err
 }
+
+// truncate marks the data as truncated and writes the marker
+// the first time it is called.
+func (w *LimitedWriter) truncate() error {
+	if w.truncated {
+		return nil
+	}
+	w.truncated = true
+	if w.marker == "" {
+		return nil
+	}
+	_, err := io.WriteString(w.w, w.marker)
+	return err
+}
+
+// Truncated reports whether some of the data was discarded.
+func (w *LimitedWriter) Truncated() bool {
+	return w.truncated
+}
+
+// Total returns the total number of bytes written to the writer,
+// including the discarded ones.
+func (w *LimitedWriter) Total() int64 {
+	return w.total
+}
+
+// TeeWriter returns a writer that writes to w and duplicates
+// its writes to s. Errors from s are ignored, so that a failing s
+// (e.g. a disconnected client) does not affect writing to w.
+// If s is nil, returns w as is.
+func TeeWriter(w io.Writer, s io.Writer) io.Writer {
+	if s == nil {
+		return w
+	}
+	return &teeWriter{w, s}
+}
+
+// A teeWriter writes to w and duplicates the writes to s.
+type teeWriter struct {
+	w io.Writer
+	s io.Writer
+}
+
+// Write implements the io.Writer interface.
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, _ = t.s.Write(p[:n])
+	return n, nil
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/nalgeon/be"
@@ -26,6 +27,7 @@ func TestLimitedWriter(t *testing.T) {
 		be.Equal(t, n, 2)
 		want := []byte{1, 2, 3, 4, 5}
 		be.Equal(t, b.Bytes(), want)
+		be.Equal(t, w.(*LimitedWriter).Truncated(), false)
 	}
 
 	{
@@ -35,5 +37,62 @@ func TestLimitedWriter(t *testing.T) {
 		be.Equal(t, n, 3)
 		want := []byte{1, 2, 3, 4, 5}
 		be.Equal(t, b.Bytes(), want)
+		be.Equal(t, w.(*LimitedWriter).Truncated(), true)
+		be.Equal(t, w.(*LimitedWriter).Total(), int64(8))
 	}
 }
+
+func TestLimitWriterMarker(t *testing.T) {
+	t.Run("truncated", func(t *testing.T) {
+		var b bytes.Buffer
+		w := LimitWriterMarker(&b, 5, "...")
+		n, err := w.Write([]byte("hello world"))
+		be.Err(t, err, nil)
+		be.Equal(t, n, 11)
+		n, err = w.Write([]byte("!"))
+		be.Err(t, err, nil)
+		be.Equal(t, n, 1)
+		be.Equal(t, b.String(), "hello...")
+		be.Equal(t, w.Truncated(), true)
+		be.Equal(t, w.Total(), int64(12))
+	})
+	t.Run("not truncated", func(t *testing.T) {
+		var b bytes.Buffer
+		w := LimitWriterMarker(&b, 5, "...")
+		_, err := w.Write([]byte("hello"))
+		be.Err(t, err, nil)
+		be.Equal(t, b.String(), "hello")
+		be.Equal(t, w.Truncated(), false)
+	})
+}
+
+func TestTeeWriter(t *testing.T) {
+	t.Run("tee", func(t *testing.T) {
+		var b1, b2 bytes.Buffer
+		w := TeeWriter(&b1, &b2)
+		n, err := w.Write([]byte("hello"))
+		be.Err(t, err, nil)
+		be.Equal(t, n, 5)
+		be.Equal(t, b1.String(), "hello")
+		be.Equal(t, b2.String(), "hello")
+	})
+	t.Run("nil", func(t *testing.T) {
+		var b bytes.Buffer
+		w := TeeWriter(&b, nil)
+		be.Equal(t, w.(*bytes.Buffer), &b)
+	})
+	t.Run("ignore errors", func(t *testing.T) {
+		var b bytes.Buffer
+		w := TeeWriter(&b, failWriter{})
+		n, err := w.Write([]byte("hello"))
+		be.Err(t, err, nil)
+		be.Equal(t, n, 5)
+		be.Equal(t, b.String(), "hello")
+	})
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("failed")
+}
@@ -0,0 +1,290 @@
+// Execute commands using external plugins.
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// maxPluginMessage is the maximum size of a single plugin message.
+const maxPluginMessage = 16 * 1024 * 1024
+
+// pluginWaitDelay is how long to wait for the plugin output
+// after the plugin is stopped (its child processes can keep
+// the output open).
+const pluginWaitDelay = time.Second
+
+// maxPluginLog is the maximum size of the plugin stderr
+// kept for diagnostics.
+const maxPluginLog = 4096
+
+var errNoResult = errors.New("plugin exited without result")
+
+// A Plugin engine executes a specific sandbox command
+// using external executables (plugins), one for each step.
+// Codapi and the plugin talk JSON over stdin and stdout:
+// see docs/plugins.md for the protocol.
+type Plugin struct {
+	cfg *config.Config
+	cmd *config.Command
+}
+
+// A pluginInput is the first message sent to the plugin.
+type pluginInput struct {
+	Request Request         `json:"request"`
+	Command *config.Command `json:"command"`
+	Step    *config.Step    `json:"step"`
+}
+
+// A pluginMessage is a message received from the plugin:
+// either a piece of output, or the final result.
+type pluginMessage struct {
+	Stdout string     `json:"stdout"`
+	Stderr string     `json:"stderr"`
+	Result *Execution `json:"result"`
+}
+
+// NewPlugin creates a new Plugin engine for a specific command.
+func NewPlugin(cfg *config.Config, sandbox, command string) Engine {
+	cmd := cfg.Commands[sandbox][command]
+	for _, step := range cmd.Steps {
+		if len(step.Command) == 0 {
+			msg := fmt.Sprintf("%s %s: plugin engine requires the plugin command in each step", sandbox, command)
+			panic(msg)
+		}
+	}
+	return &Plugin{cfg, cmd}
+}
+
+// Exec executes the command and returns the output.
+func (e *Plugin) Exec(req Request) Execution {
+	return e.ExecStream(context.Background(), req, nil)
+}
+
+// ExecStream executes the command steps using the plugins, writing
+// the output to the stream as it is produced, and returns the final output.
+func (e *Plugin) ExecStream(ctx context.Context, req Request, stream *Stream) Execution {
+	return execSteps(ctx, e.cmd.Steps, req, "", stream, e.execStep)
+}
+
+// execStep executes a step using the plugin.
+func (e *Plugin) execStep(ctx context.Context, step *config.Step, req Request, _ string, _ Files, stream *Stream) Execution {
+	if ctx.Err() != nil {
+		return Fail(req.ID, ErrCanceled)
+	}
+	// the request stdin is sent as stdin messages
+	// along with the interactive input
+	in := pluginInput{Request: req, Command: e.cmd, Step: step}
+	in.Request.Stdin = ""
+	input, err := json.Marshal(in)
+	if err != nil {
+		err = NewExecutionError("encode plugin input", err)
+		return Fail(req.ID, err)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+	defer cancel()
+
+	// pass the input through an OS pipe, so that waiting for the plugin
+	// does not depend on the interactive input, which can block indefinitely
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		err = NewExecutionError("create stdin pipe", err)
+		return Fail(req.ID, err)
+	}
+	defer func() { _ = pr.Close() }()
+	go writePluginInput(pw, input, stream)
+
+	var stdout, stderr strings.Builder
+	var streamOut, streamErr io.Writer
+	if stream != nil {
+		streamOut, streamErr = stream.Stdout, stream.Stderr
+	}
+	dec := &pluginDecoder{
+		stdout: LimitWriterMarker(TeeWriter(&stdout, streamOut), int64(step.NOutput), step.TruncationMarker),
+		stderr: LimitWriterMarker(TeeWriter(&stderr, streamErr), int64(step.NOutput), step.TruncationMarker),
+	}
+	var pluginLog bytes.Buffer
+
+	cmd := exec.CommandContext(runCtx, step.Command[0], step.Command[1:]...)
+	cmd.Stdin = pr
+	cmd.Stdout = dec
+	cmd.Stderr = LimitWriter(&pluginLog, maxPluginLog)
+	cmd.Cancel = func() error {
+		logx.Debug("%s: plugin stopped (%v)", req.ID, runCtx.Err())
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = pluginWaitDelay
+	logx.Debug("%s: plugin %v", req.ID, step.Command)
+	err = execy.Run(cmd)
+	dec.close()
+
+	if runCtx.Err() != nil {
+		if ctx.Err() != nil {
+			// canceled by the caller
+			return Fail(req.ID, ErrCanceled)
+		}
+		return Fail(req.ID, ErrTimeout)
+	}
+	if dec.err != nil {
+		err = NewExecutionError("read plugin output", dec.err)
+		return Fail(req.ID, err)
+	}
+	if dec.result == nil {
+		if err == nil {
+			err = errNoResult
+		}
+		if msg := strings.TrimSpace(pluginLog.String()); msg != "" {
+			err = fmt.Errorf("%w (%s)", err, msg)
+		}
+		err = NewExecutionError("execute plugin", err)
+		return Fail(req.ID, err)
+	}
+	if err != nil {
+		// the result is all that matters
+		logx.Debug("%s: plugin exited after result: %v", req.ID, err)
+	}
+	return pluginResult(req.ID, step, *dec.result, dec, stdout.String(), stderr.String())
+}
+
+// pluginResult completes the result received from the plugin.
+// Uses the streamed output if the result has none,
+// and limits the output size according to the step.
+func pluginResult(id string, step *config.Step, out Execution, dec *pluginDecoder, stdout, stderr string) Execution {
+	out.ID = id
+	out.Err = nil
+	out.Output = Output{
+		StdoutTruncated: dec.stdout.Truncated(),
+		StderrTruncated: dec.stderr.Truncated(),
+		StdoutBytes:     dec.stdout.Total(),
+		StderrBytes:     dec.stderr.Total(),
+	}
+	if out.Stdout == "" {
+		out.Stdout = stdout
+	} else {
+		out.StdoutBytes = int64(len(out.Stdout))
+		out.Stdout, out.StdoutTruncated = limitString(out.Stdout, step)
+	}
+	if out.Stderr == "" {
+		out.Stderr = stderr
+	} else {
+		out.StderrBytes = int64(len(out.Stderr))
+		out.Stderr, out.StderrTruncated = limitString(out.Stderr, step)
+	}
+	out.Stdout = strings.TrimSpace(out.Stdout)
+	out.Stderr = strings.TrimSpace(out.Stderr)
+	if out.Reason == "" {
+		out.Reason = exitReason(out.StdoutTruncated || out.StderrTruncated)
+	}
+	return out
+}
+
+// limitString limits the string size according to the step,
+// and reports whether it was truncated.
+func limitString(s string, step *config.Step) (string, bool) {
+	var b strings.Builder
+	w := LimitWriterMarker(&b, int64(step.NOutput), step.TruncationMarker)
+	_, _ = io.WriteString(w, s)
+	return b.String(), w.Truncated()
+}
+
+// writePluginInput writes the input message to the plugin,
+// followed by the interactive input messages (if any).
+func writePluginInput(w io.WriteCloser, input []byte, stream *Stream) {
+	defer func() { _ = w.Close() }()
+	_, err := w.Write(append(input, '\n'))
+	if err != nil || stream == nil || stream.Stdin == nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stream.Stdin.Read(buf)
+		if n > 0 {
+			msg := map[string]string{"stdin": string(buf[:n])}
+			if enc.Encode(msg) != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// A pluginDecoder reads the plugin messages (one JSON object per line)
+// as they are written, writes the output ones to stdout and stderr,
+// and keeps the result.
+type pluginDecoder struct {
+	stdout *LimitedWriter
+	stderr *LimitedWriter
+	buf    []byte
+	result *Execution
+	err    error
+}
+
+// Write implements the io.Writer interface.
+func (d *pluginDecoder) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	d.buf = append(d.buf, p...)
+	for {
+		i := bytes.IndexByte(d.buf, '\n')
+		if i < 0 {
+			break
+		}
+		d.handle(d.buf[:i])
+		d.buf = d.buf[i+1:]
+		if d.err != nil {
+			return 0, d.err
+		}
+	}
+	if len(d.buf) > maxPluginMessage {
+		d.err = fmt.Errorf("message exceeds %d bytes", maxPluginMessage)
+		return 0, d.err
+	}
+	return len(p), nil
+}
+
+// close handles the last message if it does not end with a newline.
+func (d *pluginDecoder) close() {
+	if d.err == nil && len(d.buf) > 0 {
+		d.handle(d.buf)
+		d.buf = nil
+	}
+}
+
+// handle processes a single message.
+func (d *pluginDecoder) handle(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || d.result != nil {
+		// ignore anything after the result
+		return
+	}
+	var msg pluginMessage
+	err := json.Unmarshal(line, &msg)
+	if err != nil {
+		d.err = fmt.Errorf("invalid message: %w", err)
+		return
+	}
+	if msg.Stdout != "" {
+		_, _ = io.WriteString(d.stdout, msg.Stdout)
+	}
+	if msg.Stderr != "" {
+		_, _ = io.WriteString(d.stderr, msg.Stderr)
+	}
+	d.result = msg.Result
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+var pluginCfg = &config.Config{
+	Commands: map[string]config.SandboxCommands{
+		"sim": map[string]*config.Command{
+			"run": {
+				Engine: "plugin",
+				Entry:  "main.sim",
+				Steps: []*config.Step{
+					{Command: []string{"sim-plugin", "run"}, Timeout: 1, NOutput: 16},
+				},
+			},
+			"test": {
+				Engine: "plugin",
+				Entry:  "main.sim",
+				Steps: []*config.Step{
+					{Command: []string{"sim-plugin", "test"}, Timeout: 1, NOutput: 16,
+						TruncationMarker: "..."},
+				},
+			},
+		},
+	},
+}
+
+func TestPluginExec(t *testing.T) {
+	logx.Mock()
+	req := Request{
+		ID:      "sim_run_42",
+		Sandbox: "sim",
+		Command: "run",
+		Files:   Files{"": "42"},
+	}
+
+	t.Run("streamed output", func(t *testing.T) {
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {Stdout: `{"stdout":"hello "}
+{"stdout":"world"}
+{"stderr":"warning"}
+{"result":{"ok":true,"exit_code":0}}
+`},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		var stdout, stderr bytes.Buffer
+		stream := &Stream{Stdout: &stdout, Stderr: &stderr}
+		out := engine.(*Plugin).ExecStream(context.Background(), req, stream)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, out.OK)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stdout, "hello world")
+		be.Equal(t, out.Stderr, "warning")
+		be.Equal(t, out.StdoutBytes, int64(11))
+		be.Equal(t, out.Err, nil)
+		be.Equal(t, stdout.String(), "hello world")
+		be.Equal(t, stderr.String(), "warning")
+		mem.MustHave(t, "sim-plugin run")
+	})
+	t.Run("result output", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {Stdout: `{"result":{"id":"other","ok":false,"exit_code":1,"stderr":"failed"}}`},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, !out.OK)
+		be.Equal(t, out.ExitCode, 1)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stdout, "")
+		be.Equal(t, out.Stderr, "failed")
+		be.Equal(t, out.Err, nil)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin test": {Stdout: `{"stdout":"0123456789"}
+{"stdout":"0123456789"}
+{"result":{"ok":true,"stderr":"abcdefghijklmnopqrstuvwxyz"}}
+`},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "test")
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.Reason, ReasonOutputTruncated)
+		be.Equal(t, out.Stdout, "0123456789012345...")
+		be.True(t, out.StdoutTruncated)
+		be.Equal(t, out.StdoutBytes, int64(20))
+		be.Equal(t, out.Stderr, "abcdefghijklmnop...")
+		be.True(t, out.StderrTruncated)
+		be.Equal(t, out.StderrBytes, int64(26))
+	})
+	t.Run("truncated result", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {Stdout: `{"result":{"ok":true,"stdout":"0123456789abcdefghij"}}`},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.Reason, ReasonOutputTruncated)
+		be.Equal(t, out.Stdout, "0123456789abcdef")
+		be.True(t, out.StdoutTruncated)
+		be.Equal(t, out.StdoutBytes, int64(20))
+		be.Equal(t, out.StderrBytes, int64(0))
+	})
+	t.Run("reason", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {Stdout: `{"result":{"ok":false,"reason":"oom_killed","exit_code":137}}`},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonOOMKilled)
+	})
+	t.Run("no result", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {
+				Stdout: `{"stdout":"hello"}`,
+				Stderr: "plugin crashed",
+				Err:    errors.New("exit status 2"),
+			},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInternal)
+		var execErr ExecutionError
+		be.True(t, errors.As(out.Err, &execErr))
+		be.Equal(t, out.Err.Error(), "execute plugin: exit status 2 (plugin crashed)")
+	})
+	t.Run("exit without result", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {Stdout: `{"stdout":"hello"}`},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.True(t, errors.Is(out.Err, errNoResult))
+	})
+	t.Run("invalid message", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"sim-plugin run": {Stdout: "hello\n"},
+		})
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInternal)
+		be.True(t, strings.HasPrefix(out.Err.Error(), "read plugin output: invalid message"))
+	})
+	t.Run("canceled", func(t *testing.T) {
+		execy.Mock(nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		engine := NewPlugin(pluginCfg, "sim", "run")
+		out := engine.(*Plugin).ExecStream(ctx, req, nil)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonCanceled)
+		be.Equal(t, out.Stderr, ErrCanceled.Error())
+	})
+}
+
+func TestNewPlugin(t *testing.T) {
+	cfg := &config.Config{
+		Commands: map[string]config.SandboxCommands{
+			"sim": map[string]*config.Command{
+				"run": {Engine: "plugin", Steps: []*config.Step{{}}},
+			},
+		},
+	}
+	defer func() {
+		be.True(t, recover() != nil)
+	}()
+	NewPlugin(cfg, "sim", "run")
+}
+
+func Test_writePluginInput(t *testing.T) {
+	t.Run("input only", func(t *testing.T) {
+		var buf bytes.Buffer
+		writePluginInput(nopCloser{&buf}, []byte(`{"request":{}}`), nil)
+		be.Equal(t, buf.String(), "{\"request\":{}}\n")
+	})
+	t.Run("stdin", func(t *testing.T) {
+		var buf bytes.Buffer
+		stream := &Stream{Stdin: strings.NewReader("hello\n")}
+		writePluginInput(nopCloser{&buf}, []byte(`{"request":{}}`), stream)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		be.Equal(t, len(lines), 2)
+		var msg map[string]string
+		err := json.Unmarshal([]byte(lines[1]), &msg)
+		be.Err(t, err, nil)
+		be.Equal(t, msg, map[string]string{"stdin": "hello\n"})
+	})
+}
+
+func Test_pluginDecoder(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	dec := &pluginDecoder{
+		stdout: &LimitedWriter{w: &stdout, n: 100},
+		stderr: &LimitedWriter{w: &stderr, n: 100},
+	}
+	// messages can be split across writes
+	for _, chunk := range []string{`{"stdo`, `ut":"he`, "llo\"}\n\n{\"stderr\":\"oops\"}\n", `{"result":{"ok":true}}`} {
+		n, err := dec.Write([]byte(chunk))
+		be.Err(t, err, nil)
+		be.Equal(t, n, len(chunk))
+	}
+	be.Equal(t, dec.result, (*Execution)(nil))
+	dec.close()
+	be.Err(t, dec.err, nil)
+	be.Equal(t, stdout.String(), "hello")
+	be.Equal(t, stderr.String(), "oops")
+	be.True(t, dec.result != nil)
+	be.True(t, dec.result.OK)
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,46 @@
+// Execute commands using Podman.
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"slices"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+)
+
+// podmanRootless reports whether podman runs without root privileges.
+var podmanRootless = os.Geteuid() != 0
+
+// NewPodman creates a new Podman engine for a specific command.
+// It works the same way as the Docker engine, but executes
+// the `podman` command with podman-compatible arguments.
+func NewPodman(cfg *config.Config, sandbox, command string) Engine {
+	cmd := cfg.Commands[sandbox][command]
+	return &Docker{cfg: cfg, cmd: cmd, podman: true}
+}
+
+// podmanRunArgs prepares the arguments for the `podman run` command.
+// In rootless mode, maps the host user to the same user id inside
+// the container, so that the container can access the temp dir
+// files instead of seeing them as owned by `nobody`.
+func podmanRunArgs(box *config.Box, step *config.Step, req Request, dir string, interactive bool) []string {
+	args := dockerRunArgs(box, step, req, dir, interactive)
+	if !podmanRootless {
+		return args
+	}
+	// the image is the last argument
+	return slices.Insert(args, len(args)-1, "--userns", "keep-id")
+}
+
+// podmanUnshareRemove removes the directory inside the podman
+// user namespace, where the host user owns the files created
+// by the subordinate users of rootless containers.
+func podmanUnshareRemove(dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "podman", "unshare", "rm", "-rf", dir)
+	return execy.Run(cmd)
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+func TestPodmanRun(t *testing.T) {
+	logx.Mock()
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "python",
+		Command: "run",
+		Files: map[string]string{
+			"": "print('hello world')",
+		},
+	}
+
+	t.Run("rootful", func(t *testing.T) {
+		defer setRootless(false)()
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"podman run": {Stdout: "hello world"},
+		})
+		engine := NewPodman(dockerCfg, "python", "run")
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello world")
+		mem.MustHave(t, "podman run --rm --name http_42 --runtime runc --cpus 1 --memory 64m",
+			"--network none --pids-limit 64", "codapi/python python main.py")
+		mem.MustNotHave(t, "--userns")
+		mem.MustNotHave(t, "docker")
+	})
+	t.Run("rootless", func(t *testing.T) {
+		defer setRootless(true)()
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"podman run": {Stdout: "hello world"},
+		})
+		engine := NewPodman(dockerCfg, "python", "run")
+		out := engine.Exec(req)
+		be.True(t, out.OK)
+		mem.MustHave(t, "--userns keep-id codapi/python python main.py")
+	})
+	t.Run("timeout", func(t *testing.T) {
+		logMem := logx.Mock()
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"podman run": {Err: errors.New("signal: killed")},
+		})
+		engine := NewPodman(dockerCfg, "python", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.Reason, ReasonTimeout)
+
+		// wait for the container to be killed in the background
+		deadline := time.Now().Add(time.Second)
+		for !logMem.Has("podman kill ok") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		be.True(t, logMem.Has("podman kill ok"))
+		mem.MustHave(t, "podman kill http_42")
+	})
+}
+
+func TestPodmanSession(t *testing.T) {
+	logx.Mock()
+	mem := execy.Mock(map[string]execy.CmdOut{
+		"podman run":  {Stdout: "c958ff2"},
+		"podman exec": {Stdout: "hello"},
+		"podman stop": {Stdout: "alpine_session_42"},
+	})
+	engine := NewPodman(dockerCfg, "alpine", "echo").(*Docker)
+
+	sess := Request{ID: "alpine_session_42", Sandbox: "alpine", Command: "echo"}
+	out := engine.OpenSession(context.Background(), sess)
+	be.True(t, out.OK)
+	mem.MustHave(t, "podman run --rm --name alpine_session_42", "--detach")
+
+	req := Request{ID: "alpine_42", Sandbox: "alpine", Command: "echo", Files: Files{"": "echo hello"}}
+	out = engine.ExecSession(context.Background(), sess.ID, req, nil)
+	be.True(t, out.OK)
+	mem.MustHave(t, "podman exec --interactive --user sandbox alpine_session_42 sh main.sh")
+
+	out = engine.CloseSession(sess)
+	be.True(t, out.OK)
+	mem.MustHave(t, "podman stop alpine_session_42")
+	be.Equal(t, fileExists(sessionDir(sess.ID)), false)
+}
+
+func TestPodman_noPool(t *testing.T) {
+	box := *dockerCfg.Boxes["python"]
+	box.Pool = &config.Pool{Size: 1}
+	pools[&box] = NewContainerPool("python", &box)
+	defer delete(pools, &box)
+
+	engine := NewPodman(dockerCfg, "python", "run").(*Docker)
+	warm, pool := engine.getWarm(&box, &config.Step{})
+	be.Equal(t, warm, (*warmContainer)(nil))
+	be.Equal(t, pool, (*ContainerPool)(nil))
+}
+
+func Test_podmanUnshareRemove(t *testing.T) {
+	mem := execy.Mock(map[string]execy.CmdOut{
+		"podman unshare": {},
+	})
+	err := podmanUnshareRemove("/tmp/codapi_42")
+	be.Err(t, err, nil)
+	mem.MustHave(t, "podman unshare rm -rf /tmp/codapi_42")
+}
+
+// setRootless sets the podman rootless mode
+// and returns a function that restores the previous one.
+func setRootless(rootless bool) func() {
+	prev := podmanRootless
+	podmanRootless = rootless
+	return func() { podmanRootless = prev }
+}
@@ -0,0 +1,237 @@
+// Warm container pools for the Docker engine.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/fileio"
+	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/stringx"
+)
+
+// Default warm pool settings.
+const (
+	defaultPoolMaxAge = 10 * time.Minute
+	poolStartTimeout  = 30 // seconds
+	poolRetryDelay    = 5 * time.Second
+)
+
+var defaultPoolCommand = []string{"sleep", "infinity"}
+
+// pools is the registry of warm container pools.
+// box : pool
+var pools = map[*config.Box]*ContainerPool{}
+
+// A warmContainer is a pre-started idle container
+// with a host directory mounted as its volume.
+type warmContainer struct {
+	name    string
+	dir     string
+	done    chan struct{}
+	removed bool
+}
+
+// A ContainerPool keeps a number of pre-started idle containers for a box,
+// so that the steps can run in them using `docker exec` instead of starting
+// a new container with `docker run`. Each container is used only once,
+// then destroyed and replaced with a fresh one.
+type ContainerPool struct {
+	name    string
+	box     *config.Box
+	maxAge  time.Duration
+	lazy    bool
+	command []string
+
+	idle chan *warmContainer
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewContainerPool creates a new pool for the box
+// according to its configuration. Call Start to fill the pool.
+func NewContainerPool(name string, box *config.Box) *ContainerPool {
+	p := &ContainerPool{
+		name:    name,
+		box:     box,
+		maxAge:  defaultPoolMaxAge,
+		lazy:    box.Pool.Refill == "lazy",
+		command: defaultPoolCommand,
+		idle:    make(chan *warmContainer),
+		stop:    make(chan struct{}),
+	}
+	if box.Pool.MaxAge > 0 {
+		p.maxAge = time.Duration(box.Pool.MaxAge) * time.Second
+	}
+	if len(box.Pool.Command) > 0 {
+		p.command = box.Pool.Command
+	}
+	return p
+}
+
+// Start starts the idle containers in the background.
+func (p *ContainerPool) Start() {
+	for i := 0; i < p.box.Pool.Size; i++ {
+		p.wg.Add(1)
+		go p.keep()
+	}
+}
+
+// Stop destroys the idle containers and stops refilling the pool.
+// Containers that are in use are destroyed after use as usual.
+func (p *ContainerPool) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// get takes an idle container from the pool.
+// Returns nil if there are no idle containers.
+func (p *ContainerPool) get() *warmContainer {
+	select {
+	case c := <-p.idle:
+		return c
+	default:
+		return nil
+	}
+}
+
+// recycle destroys the used container in the background.
+func (p *ContainerPool) recycle(c *warmContainer) {
+	go func() {
+		p.destroy(c)
+		close(c.done)
+	}()
+}
+
+// keep maintains a single idle container in the pool:
+// starts it, waits for it to be taken (or to expire),
+// then starts a replacement, until the pool is stopped.
+func (p *ContainerPool) keep() {
+	defer p.wg.Done()
+	for {
+		c, err := p.start()
+		if err != nil {
+			logx.Warn("pool %s: %v", p.name, err)
+			select {
+			case <-time.After(poolRetryDelay):
+				continue
+			case <-p.stop:
+				return
+			}
+		}
+
+		timer := time.NewTimer(p.maxAge)
+		select {
+		case p.idle <- c:
+			timer.Stop()
+			if p.lazy {
+				// wait until the container is used and destroyed
+				select {
+				case <-c.done:
+				case <-p.stop:
+					return
+				}
+			}
+		case <-timer.C:
+			logx.Debug("pool %s: container %s expired", p.name, c.name)
+			p.destroy(c)
+		case <-p.stop:
+			timer.Stop()
+			p.destroy(c)
+			return
+		}
+	}
+}
+
+// start starts a new idle container.
+func (p *ContainerPool) start() (*warmContainer, error) {
+	dir, err := fileio.MkdirTemp(0777)
+	if err != nil {
+		return nil, NewExecutionError("create temp dir", err)
+	}
+	// container names only allow [a-zA-Z0-9_.-]
+	name := fmt.Sprintf("codapi_%s_%s", strings.ReplaceAll(p.name, ":", "."), stringx.RandString(8))
+	req := Request{ID: name}
+	step := &config.Step{Detach: true}
+	args := dockerRunArgs(p.box, step, req, dir, false)
+	args = append(args, p.command...)
+
+	prog := NewProgram(poolStartTimeout, 4096)
+	_, stderr, err := prog.Run(name, "docker", args...)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		if stderr != "" {
+			err = fmt.Errorf("%s (%s)", strings.TrimSpace(stderr), err)
+		}
+		return nil, NewExecutionError("start container", err)
+	}
+	logx.Debug("pool %s: started container %s", p.name, name)
+	return &warmContainer{name: name, dir: dir, done: make(chan struct{})}, nil
+}
+
+// remove removes the used container, but keeps its directory.
+// Must be called before recycle.
+func (p *ContainerPool) remove(c *warmContainer) error {
+	err := removeContainer("docker", c.name)
+	if err != nil {
+		return err
+	}
+	c.removed = true
+	return nil
+}
+
+// destroy removes the container along with its directory.
+func (p *ContainerPool) destroy(c *warmContainer) {
+	if !c.removed {
+		err := removeContainer("docker", c.name)
+		if err != nil {
+			logx.Warn("pool %s: remove container %s: %v", p.name, c.name, err)
+		}
+	}
+	_ = os.RemoveAll(c.dir)
+}
+
+// StartPools creates and starts warm container pools
+// for the boxes that have them configured.
+// Must be called before executing any commands.
+func StartPools(cfg *config.Config) {
+	var names []string
+	for name, box := range cfg.Boxes {
+		if box.Pool == nil || box.Pool.Size <= 0 {
+			continue
+		}
+		pool := NewContainerPool(name, box)
+		pool.Start()
+		pools[box] = pool
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		logx.Log("warm pools: %v", names)
+	}
+}
+
+// StopPools stops all warm container pools.
+func StopPools() {
+	for box, pool := range pools {
+		pool.Stop()
+		delete(pools, box)
+	}
+}
+
+// removeContainer forcibly removes the container with the specified id/name
+// using the container engine executable (docker or podman).
+func removeContainer(bin, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin, "rm", "--force", id)
+	return execy.Run(cmd)
+}
@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+func newPoolBox(pool *config.Pool) *config.Box {
+	return &config.Box{
+		Image:   "codapi/python",
+		Runtime: "runc",
+		Host: config.Host{
+			CPU: 1, Memory: 64, Network: "none",
+			Volume: "%s:/sandbox:ro",
+			NProc:  64,
+		},
+		Pool: pool,
+	}
+}
+
+// waitWarm waits for an idle container in the pool.
+func waitWarm(t *testing.T, p *ContainerPool) *warmContainer {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if c := p.get(); c != nil {
+			return c
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no idle container")
+	return nil
+}
+
+func TestContainerPool(t *testing.T) {
+	logx.Mock()
+
+	t.Run("start", func(t *testing.T) {
+		mem := execy.Mock(nil)
+		pool := NewContainerPool("python:dev", newPoolBox(&config.Pool{Size: 1}))
+		pool.Start()
+		defer pool.Stop()
+
+		c := waitWarm(t, pool)
+		be.True(t, strings.HasPrefix(c.name, "codapi_python.dev_"))
+		be.True(t, c.dir != "")
+		mem.MustHave(t, "docker run --rm --name "+c.name)
+		mem.MustHave(t, "--detach", "codapi/python sleep infinity")
+		mem.MustNotHave(t, "--user")
+
+		pool.recycle(c)
+		<-c.done
+		mem.MustHave(t, "docker rm --force "+c.name)
+		_, err := os.Stat(c.dir)
+		be.True(t, os.IsNotExist(err))
+	})
+	t.Run("refill", func(t *testing.T) {
+		execy.Mock(nil)
+		pool := NewContainerPool("python", newPoolBox(&config.Pool{Size: 1}))
+		pool.Start()
+		defer pool.Stop()
+
+		c1 := waitWarm(t, pool)
+		c2 := waitWarm(t, pool)
+		be.True(t, c1.name != c2.name)
+		pool.recycle(c1)
+		pool.recycle(c2)
+		<-c1.done
+		<-c2.done
+	})
+	t.Run("lazy refill", func(t *testing.T) {
+		execy.Mock(nil)
+		pool := NewContainerPool("python", newPoolBox(&config.Pool{Size: 1, Refill: "lazy"}))
+		pool.Start()
+		defer pool.Stop()
+
+		c := waitWarm(t, pool)
+		time.Sleep(10 * time.Millisecond)
+		be.Equal(t, pool.get(), (*warmContainer)(nil))
+		pool.recycle(c)
+		<-c.done
+		waitWarm(t, pool)
+	})
+	t.Run("command", func(t *testing.T) {
+		mem := execy.Mock(nil)
+		box := newPoolBox(&config.Pool{Size: 1, Command: []string{"tail", "-f", "/dev/null"}})
+		pool := NewContainerPool("python", box)
+		pool.Start()
+		defer pool.Stop()
+
+		waitWarm(t, pool)
+		mem.MustHave(t, "codapi/python tail -f /dev/null")
+	})
+	t.Run("stop", func(t *testing.T) {
+		mem := execy.Mock(nil)
+		pool := NewContainerPool("python", newPoolBox(&config.Pool{Size: 1}))
+		pool.Start()
+		deadline := time.Now().Add(time.Second)
+		for !mem.Has("docker run") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		pool.Stop()
+		mem.MustHave(t, "docker rm --force codapi_python_")
+	})
+}
+
+func TestDockerExec_Pool(t *testing.T) {
+	logx.Mock()
+	mem := execy.Mock(map[string]execy.CmdOut{
+		"docker exec": {Stdout: "hello world", Stderr: "", Err: nil},
+	})
+
+	box := newPoolBox(&config.Pool{Size: 1})
+	cfg := &config.Config{
+		Boxes: map[string]*config.Box{"python": box},
+		Commands: map[string]config.SandboxCommands{
+			"python": map[string]*config.Command{
+				"run": {
+					Engine: "docker",
+					Entry:  "main.py",
+					Steps: []*config.Step{
+						{
+							Box: "python", User: "sandbox", Action: "run",
+							Command: []string{"python", "main.py"},
+							NOutput: 4096,
+						},
+					},
+				},
+			},
+		},
+	}
+	// a pool with a single idle container
+	pool := NewContainerPool("python", box)
+	c := &warmContainer{name: "codapi_python_42", dir: t.TempDir(), done: make(chan struct{})}
+	pool.idle = make(chan *warmContainer, 1)
+	pool.idle <- c
+	pools[box] = pool
+	defer delete(pools, box)
+
+	engine := NewDocker(cfg, "python", "run")
+	req := Request{
+		ID:      "python_42",
+		Sandbox: "python",
+		Command: "run",
+		Files: map[string]string{
+			"": "print('hello world')",
+		},
+	}
+	out := engine.Exec(req)
+	be.True(t, out.OK)
+	be.Equal(t, out.Stdout, "hello world")
+	mem.MustHave(t, "docker exec --user sandbox "+c.name+" python main.py")
+	mem.MustNotHave(t, "--name python_42")
+
+	// the container is destroyed after use
+	<-c.done
+	mem.MustHave(t, "docker rm --force "+c.name)
+	_, err := os.Stat(c.dir)
+	be.True(t, os.IsNotExist(err))
+}
+
+func TestDockerExec_PoolWritable(t *testing.T) {
+	logx.Mock()
+	mem := execy.Mock(map[string]execy.CmdOut{
+		"docker exec": {Stdout: "hello world", Stderr: "", Err: nil},
+	})
+
+	box := newPoolBox(&config.Pool{Size: 1})
+	box.Volume = "%s:/sandbox"
+	cfg := &config.Config{
+		Boxes: map[string]*config.Box{"python": box},
+		Commands: map[string]config.SandboxCommands{
+			"python": map[string]*config.Command{
+				"run": {
+					Engine: "docker",
+					Entry:  "main.py",
+					Steps: []*config.Step{
+						{
+							Box: "python", User: "sandbox", Action: "run",
+							Command: []string{"python", "main.py"},
+							NOutput: 4096,
+						},
+					},
+				},
+			},
+		},
+	}
+	pool := NewContainerPool("python", box)
+	c := &warmContainer{name: "codapi_python_42", dir: t.TempDir(), done: make(chan struct{})}
+	pool.idle = make(chan *warmContainer, 1)
+	pool.idle <- c
+	pools[box] = pool
+	defer delete(pools, box)
+
+	engine := NewDocker(cfg, "python", "run")
+	req := Request{
+		ID:      "python_42",
+		Sandbox: "python",
+		Command: "run",
+		Files:   map[string]string{"": "print('hello world')"},
+	}
+	out := engine.Exec(req)
+	be.True(t, out.OK)
+
+	// the container is removed before the files are copied back,
+	// and is not removed again when destroyed
+	be.True(t, c.removed)
+	<-c.done
+	var removed int
+	for _, line := range mem.Lines {
+		if strings.Contains(line, "docker rm --force "+c.name) {
+			removed++
+		}
+	}
+	be.Equal(t, removed, 1)
+}
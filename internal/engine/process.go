@@ -0,0 +1,244 @@
+// Execute commands as local processes.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/fileio"
+)
+
+// processInitArg makes codapi act as the init process of the process
+// engine: set the resource limits and execute the step command.
+const processInitArg = "process-init"
+
+// processPath is the PATH of the executed commands.
+const processPath = "/usr/local/bin:/usr/bin:/bin"
+
+// processExe is the codapi executable, which is started
+// as the init process of each step.
+var processExe, _ = os.Executable()
+
+// processRoot reports whether codapi runs as root. The process engine
+// refuses to run as root, because the code would have root access
+// to the host files.
+var processRoot = os.Getuid() == 0
+
+// A Process engine executes a specific sandbox command
+// as a local process in a temporary directory, isolated
+// with Linux namespaces and limited with rlimits.
+// Only suitable for trusted code.
+type Process struct {
+	cfg    *config.Config
+	cmd    *config.Command
+	hidden []string
+}
+
+// NewProcess creates a new Process engine for a specific command.
+func NewProcess(cfg *config.Config, sandbox, command string) Engine {
+	cmd := cfg.Commands[sandbox][command]
+	return &Process{cfg, cmd, processHidden(cfg)}
+}
+
+// Exec executes the command and returns the output.
+func (e *Process) Exec(req Request) Execution {
+	return e.ExecStream(context.Background(), req, nil)
+}
+
+// ExecStream executes the command, writing the output
+// to the stream as it is produced, and returns the final output.
+// Works the same way as the Docker engine, but runs
+// the step commands on the host instead of containers.
+func (e *Process) ExecStream(ctx context.Context, req Request, stream *Stream) Execution {
+	// all steps operate in the same temp directory
+	dir, err := fileio.MkdirTemp(0700)
+	if err != nil {
+		err = NewExecutionError("create temp dir", err)
+		return Fail(req.ID, err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if e.cmd.Entry != "" {
+		// write request files to the temp directory
+		err = writeFiles(dir, e.cmd.Entry, req.Files)
+		var argErr ArgumentError
+		if errors.As(err, &argErr) {
+			return Fail(req.ID, err)
+		} else if err != nil {
+			err = NewExecutionError("write files to temp dir", err)
+			return Fail(req.ID, err)
+		}
+	}
+
+	// initialization step
+	if e.cmd.Before != nil {
+		out := e.execStep(ctx, e.cmd.Before, req, dir, nil, nil)
+		if !out.OK {
+			return out
+		}
+	}
+
+	out := execSteps(ctx, e.cmd.Steps, req, dir, stream, e.execStep)
+	out = withOutputs(e.cmd, out, dir)
+
+	// cleanup step (runs even if the execution is canceled)
+	if e.cmd.After != nil {
+		afterOut := e.execStep(context.WithoutCancel(ctx), e.cmd.After, req, dir, nil, nil)
+		if out.OK && !afterOut.OK {
+			return afterOut
+		}
+	}
+
+	return out
+}
+
+// execStep executes a step as a local process.
+func (e *Process) execStep(ctx context.Context, step *config.Step, req Request, dir string, files Files, stream *Stream) Execution {
+	if ctx.Err() != nil {
+		return Fail(req.ID, ErrCanceled)
+	}
+	if !processSupported {
+		err := NewExecutionError("execute code", errors.New("process engine requires linux"))
+		return Fail(req.ID, err)
+	}
+	if step.Action != actionRun || step.Detach {
+		// there are no containers to exec into or stop
+		err := NewExecutionError("execute code", fmt.Errorf("unsupported action %s", step.Action))
+		return Fail(req.ID, err)
+	}
+	if processExe == "" {
+		err := NewExecutionError("execute code", errors.New("unknown codapi executable"))
+		return Fail(req.ID, err)
+	}
+	if processRoot {
+		err := NewExecutionError("execute code", errors.New("process engine refuses to run as root"))
+		return Fail(req.ID, err)
+	}
+
+	// limit the stdout/stderr size
+	prog := NewProgram(step.Timeout, int64(step.NOutput)).WithMarker(step.TruncationMarker).
+		WithContext(ctx).WithStream(stream).
+		WithDir(dir).WithEnv(processEnv(dir, req.Env)).WithSysProcAttr(processAttr())
+	stdin := stepStdin(step, files, stream)
+	args := processArgs(e.getHost(step), step, req, e.hidden)
+	unlock := lockProcessThread()
+	stdout, stderr, err := prog.RunStdin(stdin, req.ID, processExe, args...)
+	unlock()
+
+	// killing the init process (pid 1 in its namespace)
+	// kills all the processes started by the step
+	return programResult(ctx, req.ID, prog, stdout, stderr, err)
+}
+
+// getHost returns the resource limits for the step:
+// from the step box if it is configured, or the default ones.
+func (e *Process) getHost(step *config.Step) config.Host {
+	if box, ok := e.cfg.Boxes[step.Box]; ok {
+		return box.Host
+	}
+	if e.cfg.Box != nil {
+		return e.cfg.Box.Host
+	}
+	return config.Host{}
+}
+
+// processHidden returns the host paths hidden from the code:
+// the codapi working directory (with the configuration),
+// the API keys file, the audit log directory, and the temp
+// directory with the working directories of other executions.
+func processHidden(cfg *config.Config) []string {
+	var paths []string
+	if wd, err := os.Getwd(); err == nil {
+		paths = append(paths, wd)
+	}
+	if cfg.KeysFile != "" {
+		paths = append(paths, cfg.KeysFile)
+	}
+	if cfg.Audit != nil && cfg.Audit.Path != "" {
+		paths = append(paths, filepath.Dir(cfg.Audit.Path))
+	}
+	paths = append(paths, os.TempDir())
+	for i, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			paths[i] = abs
+		}
+	}
+	return paths
+}
+
+// processArgs prepares the arguments for the init process:
+// the resource limits and the hidden paths followed by the step command.
+// The CPU time limit is the step timeout times the number of CPUs.
+func processArgs(host config.Host, step *config.Step, req Request, hidden []string) []string {
+	args := []string{processInitArg}
+	if step.Timeout > 0 {
+		args = append(args, "cpu="+strconv.Itoa(step.Timeout*max(host.CPU, 1)))
+	}
+	if host.Memory > 0 {
+		args = append(args, "as="+strconv.Itoa(host.Memory*1024*1024))
+	}
+	if host.NProc > 0 {
+		args = append(args, "nproc="+strconv.Itoa(host.NProc))
+	}
+	for _, path := range hidden {
+		args = append(args, "hide="+path)
+	}
+	args = append(args, "--")
+	return append(args, expandVars(step.Command, req.ID, req)...)
+}
+
+// processEnv prepares the environment variables for the process.
+// Does not inherit the codapi ones, so as not to expose them.
+func processEnv(dir string, env map[string]string) []string {
+	vars := []string{"PATH=" + processPath, "HOME=" + dir, "TMPDIR=" + dir}
+	return append(vars, envVars(env)...)
+}
+
+// A processLimit is a resource limit of the process.
+type processLimit struct {
+	name  string
+	value uint64
+}
+
+// A processSpec describes the init process: the resource limits,
+// the host paths to hide, and the command to execute.
+type processSpec struct {
+	limits  []processLimit
+	hidden  []string
+	command []string
+}
+
+// parseProcessArgs parses the init process arguments
+// into the resource limits, the hidden paths and the command.
+func parseProcessArgs(args []string) (processSpec, error) {
+	var spec processSpec
+	for i, arg := range args {
+		if arg == "--" {
+			if i == len(args)-1 {
+				return processSpec{}, errors.New("missing command")
+			}
+			spec.command = args[i+1:]
+			return spec, nil
+		}
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return processSpec{}, fmt.Errorf("invalid limit: %s", arg)
+		}
+		if name == "hide" {
+			spec.hidden = append(spec.hidden, value)
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return processSpec{}, fmt.Errorf("invalid limit: %s", arg)
+		}
+		spec.limits = append(spec.limits, processLimit{name, n})
+	}
+	return processSpec{}, errors.New("missing command")
+}
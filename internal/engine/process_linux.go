@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// processSupported reports whether the process engine
+// is supported on the current platform.
+const processSupported = true
+
+// processResources maps the limit names to the rlimit resources.
+var processResources = map[string]int{
+	"cpu":   syscall.RLIMIT_CPU,
+	"as":    syscall.RLIMIT_AS,
+	"nproc": unix.RLIMIT_NPROC,
+}
+
+// processAttr returns the attributes of the init process.
+// It starts in new user, pid, mount and network namespaces,
+// with the current user mapped to root in the user namespace
+// (which has no privileges outside of it). Killed if the thread
+// that started it exits (see lockProcessThread).
+func processAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		Pdeathsig:   syscall.SIGKILL,
+	}
+}
+
+// lockProcessThread locks the calling goroutine to its OS thread
+// until the returned function is called. The init process is killed
+// when the thread that started it exits (see processAttr), so the thread
+// must stay alive until the process completes, and only exit with codapi.
+func lockProcessThread() func() {
+	runtime.LockOSThread()
+	return runtime.UnlockOSThread
+}
+
+// ProcessInit sets the resource limits and replaces the current process
+// with the step command if codapi is started as the process engine init.
+// Otherwise, does nothing. Must be called at the start of the program.
+func ProcessInit() {
+	if len(os.Args) < 2 || os.Args[1] != processInitArg {
+		return
+	}
+	err := processExec(os.Args[2:])
+	fmt.Fprintf(os.Stderr, "codapi: %v\n", err)
+	if errors.Is(err, exec.ErrNotFound) {
+		os.Exit(127)
+	}
+	os.Exit(126)
+}
+
+// processExec sets the resource limits and executes the command.
+// Only returns on error.
+func processExec(args []string) error {
+	spec, err := parseProcessArgs(args)
+	if err != nil {
+		return err
+	}
+	// the init process is root in its user namespace,
+	// so it can make the mounts private (not to affect the host)
+	// and mount /proc for the new pid namespace
+	err = syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	err = isolateFiles(spec.hidden)
+	if err != nil {
+		return err
+	}
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+	for _, lim := range spec.limits {
+		resource, ok := processResources[lim.name]
+		if !ok {
+			return fmt.Errorf("unknown limit: %s", lim.name)
+		}
+		rlim := &syscall.Rlimit{Cur: lim.value, Max: lim.value}
+		err := syscall.Setrlimit(resource, rlim)
+		if err != nil {
+			return fmt.Errorf("set %s limit: %w", lim.name, err)
+		}
+	}
+	path, err := exec.LookPath(spec.command[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, spec.command, os.Environ())
+}
+
+// isolateFiles hides the given host paths and makes the whole
+// filesystem read-only, except for the working directory
+// (the step's temp dir), so that the code can't read the codapi
+// files or modify the host files. Requires Linux 5.12+.
+func isolateFiles(hidden []string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("get working dir: %w", err)
+	}
+	// bind-mount the working directory onto itself,
+	// so that it can stay writable while the rest is read-only
+	err = syscall.Mount(dir, dir, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("bind-mount working dir: %w", err)
+	}
+	for _, path := range hidden {
+		err = hidePath(path, dir)
+		if err != nil {
+			return fmt.Errorf("hide %s: %w", path, err)
+		}
+	}
+	err = unix.MountSetattr(unix.AT_FDCWD, "/", unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY})
+	if err != nil {
+		return fmt.Errorf("make filesystem read-only: %w", err)
+	}
+	err = unix.MountSetattr(unix.AT_FDCWD, dir, unix.AT_RECURSIVE, &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY})
+	if err != nil {
+		return fmt.Errorf("make working dir writable: %w", err)
+	}
+	// the current directory still points to the original mount
+	err = os.Chdir(dir)
+	if err != nil {
+		return fmt.Errorf("change working dir: %w", err)
+	}
+	return nil
+}
+
+// hidePath replaces the directory at the path with an empty one,
+// or the file with /dev/null. If the directory contains the working
+// directory, the working directory stays in place.
+func hidePath(path, dir string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) || path == "/" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	err = syscall.Mount("tmpfs", path, "tmpfs", flags, "size=64k,mode=755")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(dir, filepath.Clean(path)+"/") {
+		return nil
+	}
+	// the current directory is still the original working directory,
+	// so it can be bind-mounted back to its place in the empty one
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	return syscall.Mount(".", dir, "", syscall.MS_BIND|syscall.MS_REC, "")
+}
@@ -0,0 +1,18 @@
+package engine
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func Test_processAttr(t *testing.T) {
+	attr := processAttr()
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET
+	be.Equal(t, attr.Cloneflags, uintptr(flags))
+	be.Equal(t, attr.UidMappings, []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}})
+	be.Equal(t, attr.GidMappings, []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}})
+	be.Equal(t, attr.Pdeathsig, syscall.SIGKILL)
+}
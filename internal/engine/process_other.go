@@ -0,0 +1,24 @@
+//go:build !linux
+
+package engine
+
+import "syscall"
+
+// processSupported reports whether the process engine
+// is supported on the current platform.
+const processSupported = false
+
+// processAttr returns the attributes of the init process.
+func processAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// lockProcessThread does nothing, since the process engine
+// is only supported on Linux.
+func lockProcessThread() func() {
+	return func() {}
+}
+
+// ProcessInit does nothing, since the process engine
+// is only supported on Linux.
+func ProcessInit() {}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+var processCfg = &config.Config{
+	Box: &config.Box{
+		Host: config.Host{CPU: 1, Memory: 64, NProc: 32},
+	},
+	Boxes: map[string]*config.Box{
+		"ash": {
+			Host: config.Host{CPU: 2, Memory: 128, NProc: 64},
+		},
+	},
+	Commands: map[string]config.SandboxCommands{
+		"ash": map[string]*config.Command{
+			"run": {
+				Engine: "process",
+				Entry:  "main.sh",
+				Steps: []*config.Step{
+					{
+						Box: "ash", Action: "run", Timeout: 5,
+						Command: []string{"sh", "main.sh", ":args"},
+						NOutput: 4096,
+					},
+				},
+			},
+			"exec": {
+				Engine: "process",
+				Steps: []*config.Step{
+					{
+						Box: ":name", Action: "exec", Timeout: 5,
+						Command: []string{"sh"},
+						NOutput: 4096,
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestProcessRun(t *testing.T) {
+	if !processSupported {
+		t.Skip("process engine requires linux")
+	}
+	logx.Mock()
+	defer setProcessExe("codapi")()
+	defer setProcessRoot(false)()
+	req := Request{
+		ID:      "http_42",
+		Sandbox: "ash",
+		Command: "run",
+		Files:   Files{"": "echo hello"},
+		Args:    []string{"-v"},
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"codapi process-init": {Stdout: "hello"},
+		})
+		engine := NewProcess(processCfg, "ash", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, out.OK)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stdout, "hello")
+		wd, _ := os.Getwd()
+		hidden := "hide=" + wd + " hide=" + os.TempDir()
+		mem.MustHave(t, "codapi process-init cpu=10 as=134217728 nproc=64 "+hidden+" -- sh main.sh -v")
+	})
+	t.Run("exit code", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"codapi process-init": {Stderr: "oops", Err: exitError(2)},
+		})
+		engine := NewProcess(processCfg, "ash", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.ExitCode, 2)
+		be.Equal(t, out.Stderr, "oops")
+		be.Equal(t, out.Err, nil)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"codapi process-init": {Err: errors.New("signal: killed")},
+		})
+		engine := NewProcess(processCfg, "ash", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.Reason, ReasonTimeout)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		mem := execy.Mock(nil)
+		engine := NewProcess(processCfg, "ash", "run")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		out := engine.ExecStream(ctx, req, nil)
+		be.Equal(t, out.Reason, ReasonCanceled)
+		mem.MustNotHave(t, "process-init")
+	})
+	t.Run("unsupported action", func(t *testing.T) {
+		execy.Mock(nil)
+		engine := NewProcess(processCfg, "ash", "exec")
+		out := engine.Exec(Request{ID: "http_42", Sandbox: "ash", Command: "exec"})
+		be.Equal(t, out.Reason, ReasonInternal)
+		be.Err(t, out.Err, "unsupported action exec")
+	})
+	t.Run("root", func(t *testing.T) {
+		defer setProcessRoot(true)()
+		mem := execy.Mock(nil)
+		engine := NewProcess(processCfg, "ash", "run")
+		out := engine.Exec(req)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Reason, ReasonInternal)
+		be.Err(t, out.Err, "process engine refuses to run as root")
+		be.Equal(t, len(mem.Lines), 0)
+	})
+	t.Run("directory traversal attack", func(t *testing.T) {
+		execy.Mock(nil)
+		engine := NewProcess(processCfg, "ash", "run")
+		in := req
+		in.Files = Files{"": "echo hello", "../main.sh": "rm -rf /"}
+		out := engine.Exec(in)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Stderr, "files[../main.sh]: invalid name")
+	})
+}
+
+func Test_processHidden(t *testing.T) {
+	wd, _ := os.Getwd()
+	t.Run("default", func(t *testing.T) {
+		hidden := processHidden(&config.Config{})
+		be.Equal(t, hidden, []string{wd, os.TempDir()})
+	})
+	t.Run("keys and audit", func(t *testing.T) {
+		cfg := &config.Config{
+			KeysFile: "/etc/codapi/keys.json",
+			Audit:    &config.Audit{Path: "audit/audit.log"},
+		}
+		hidden := processHidden(cfg)
+		be.Equal(t, hidden, []string{wd, "/etc/codapi/keys.json", filepath.Join(wd, "audit"), os.TempDir()})
+	})
+}
+
+func Test_processArgs(t *testing.T) {
+	step := &config.Step{Timeout: 5, Command: []string{"sh", "main.sh", ":args"}}
+	req := Request{Args: []string{"-x"}}
+	t.Run("limits", func(t *testing.T) {
+		host := config.Host{CPU: 2, Memory: 64, NProc: 16}
+		args := processArgs(host, step, req, nil)
+		be.Equal(t, args, []string{
+			"process-init", "cpu=10", "as=67108864", "nproc=16", "--", "sh", "main.sh", "-x",
+		})
+	})
+	t.Run("no limits", func(t *testing.T) {
+		args := processArgs(config.Host{}, &config.Step{Command: step.Command}, req, nil)
+		be.Equal(t, args, []string{"process-init", "--", "sh", "main.sh", "-x"})
+	})
+	t.Run("hidden", func(t *testing.T) {
+		args := processArgs(config.Host{}, &config.Step{Command: step.Command}, req, []string{"/opt/codapi", "/tmp"})
+		be.Equal(t, args, []string{
+			"process-init", "hide=/opt/codapi", "hide=/tmp", "--", "sh", "main.sh", "-x",
+		})
+	})
+}
+
+func Test_processEnv(t *testing.T) {
+	env := processEnv("/tmp/dir", map[string]string{"LANG": "C", "DEBUG": "1"})
+	be.Equal(t, env, []string{
+		"PATH=" + processPath, "HOME=/tmp/dir", "TMPDIR=/tmp/dir", "DEBUG=1", "LANG=C",
+	})
+}
+
+func Test_parseProcessArgs(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		spec, err := parseProcessArgs([]string{"cpu=5", "hide=/tmp", "as=1024", "--", "sh", "-c", "echo"})
+		be.Err(t, err, nil)
+		be.Equal(t, spec.limits, []processLimit{{"cpu", 5}, {"as", 1024}})
+		be.Equal(t, spec.hidden, []string{"/tmp"})
+		be.Equal(t, spec.command, []string{"sh", "-c", "echo"})
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseProcessArgs([]string{"cpu=5"})
+		be.Err(t, err, "missing command")
+		_, err = parseProcessArgs([]string{"cpu=5", "--"})
+		be.Err(t, err, "missing command")
+		_, err = parseProcessArgs([]string{"cpu", "--", "sh"})
+		be.Err(t, err, "invalid limit: cpu")
+		_, err = parseProcessArgs([]string{"cpu=-1", "--", "sh"})
+		be.Err(t, err, "invalid limit: cpu=-1")
+	})
+}
+
+// setProcessExe sets the codapi executable
+// and returns a function that restores the previous one.
+func setProcessExe(exe string) func() {
+	prev := processExe
+	processExe = exe
+	return func() { processExe = prev }
+}
+
+// setProcessRoot sets whether codapi runs as root
+// and returns a function that restores the previous value.
+func setProcessRoot(root bool) func() {
+	prev := processRoot
+	processRoot = root
+	return func() { processRoot = prev }
+}
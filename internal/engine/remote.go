@@ -0,0 +1,236 @@
+// Execute commands on remote codapi servers.
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// defaultRemoteTimeout is the default remote request timeout in seconds.
+const defaultRemoteTimeout = 60
+
+// maxRemoteResponse is the maximum size of the remote response
+// (or a single event in case of a streaming response).
+const maxRemoteResponse = 32 * 1024 * 1024
+
+var errNoDone = errors.New("stream ended without result")
+
+// A Remote engine executes a specific sandbox command
+// by forwarding the request to another codapi server,
+// which has the same sandbox command configured.
+type Remote struct {
+	name    string
+	url     string
+	key     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewRemote creates a new Remote engine for a specific command.
+func NewRemote(cfg *config.Config, sandbox, command string) Engine {
+	cmd := cfg.Commands[sandbox][command]
+	remote := cfg.Remotes[cmd.Remote]
+	if remote == nil || remote.URL == "" {
+		msg := fmt.Sprintf("%s %s: remote engine requires a configured remote server", sandbox, command)
+		panic(msg)
+	}
+	return &Remote{
+		name:    cmd.Remote,
+		url:     strings.TrimSuffix(remote.URL, "/") + "/v1/exec",
+		key:     remote.Key,
+		timeout: time.Duration(RemoteTimeout(remote)) * time.Second,
+		client:  &http.Client{},
+	}
+}
+
+// RemoteTimeout returns the request timeout
+// of the remote server in seconds.
+func RemoteTimeout(remote *config.Remote) int {
+	if remote == nil || remote.Timeout <= 0 {
+		return defaultRemoteTimeout
+	}
+	return remote.Timeout
+}
+
+// Exec executes the command on the remote server and returns the output.
+func (e *Remote) Exec(req Request) Execution {
+	return e.ExecStream(context.Background(), req, nil)
+}
+
+// ExecStream executes the command on the remote server, writing the output
+// to the stream as it is received, and returns the final output.
+// The interactive input is not supported (only the request stdin).
+func (e *Remote) ExecStream(ctx context.Context, req Request, stream *Stream) Execution {
+	if ctx.Err() != nil {
+		return Fail(req.ID, ErrCanceled)
+	}
+	runCtx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	httpReq, err := e.newRequest(runCtx, req, stream != nil)
+	if err != nil {
+		err = NewExecutionError("create remote request", err)
+		return Fail(req.ID, err)
+	}
+
+	logx.Debug("%s: remote %s %s", req.ID, e.name, e.url)
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return remoteFail(ctx, runCtx, req.ID, "remote request", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteStatusFail(req.ID, resp)
+	}
+
+	var out Execution
+	events := strings.HasPrefix(resp.Header.Get("content-type"), "text/event-stream")
+	if events {
+		out, err = readRemoteEvents(resp.Body, stream)
+	} else {
+		err = json.NewDecoder(io.LimitReader(resp.Body, maxRemoteResponse)).Decode(&out)
+	}
+	if err != nil {
+		return remoteFail(ctx, runCtx, req.ID, "read remote response", err)
+	}
+	if !events && stream != nil {
+		// the remote server does not support streaming,
+		// so write the whole output at once
+		writeString(stream.Stdout, out.Stdout)
+		writeString(stream.Stderr, out.Stderr)
+	}
+
+	// the remote server assigns its own execution ID
+	logx.Debug("%s: remote %s id=%s", req.ID, e.name, out.ID)
+	out.ID = req.ID
+	return out
+}
+
+// newRequest creates a remote code execution request.
+func (e *Remote) newRequest(ctx context.Context, req Request, stream bool) (*http.Request, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("content-type", "application/json")
+	if stream {
+		httpReq.Header.Set("accept", "text/event-stream")
+	}
+	if e.key != "" {
+		httpReq.Header.Set("authorization", "Bearer "+e.key)
+	}
+	if req.Client != "" {
+		// the remote server can use the original client address
+		// if it trusts this server as a proxy
+		httpReq.Header.Set("x-forwarded-for", req.Client)
+	}
+	return httpReq, nil
+}
+
+// remoteFail creates an output from the remote request error.
+func remoteFail(ctx, runCtx context.Context, id, msg string, err error) Execution {
+	if ctx.Err() != nil {
+		// canceled by the caller
+		return Fail(id, ErrCanceled)
+	}
+	if runCtx.Err() != nil {
+		// request timeout
+		return Fail(id, ErrTimeout)
+	}
+	return Fail(id, NewExecutionError(msg, err))
+}
+
+// remoteStatusFail creates an output from the remote error response.
+func remoteStatusFail(id string, resp *http.Response) Execution {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return Fail(id, ErrBusy)
+	}
+
+	// the error response is an execution with the message in stderr
+	var out Execution
+	_ = json.NewDecoder(io.LimitReader(resp.Body, maxRemoteResponse)).Decode(&out)
+	msg := out.Stderr
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		// the remote server rejected the request,
+		// so the problem is the request, not the execution
+		return Fail(id, errors.New(msg))
+	default:
+		err := fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+		return Fail(id, NewExecutionError("remote exec", err))
+	}
+}
+
+// readRemoteEvents reads the server-sent events from the remote server,
+// writes the output ones to the stream, and returns the final result.
+func readRemoteEvents(r io.Reader, stream *Stream) (Execution, error) {
+	var stdout, stderr io.Writer
+	if stream != nil {
+		stdout, stderr = stream.Stdout, stream.Stderr
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRemoteResponse)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event:"); ok {
+			event = strings.TrimSpace(name)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "stdout", "stderr":
+			var chunk string
+			err := json.Unmarshal([]byte(data), &chunk)
+			if err != nil {
+				return Execution{}, fmt.Errorf("invalid %s event: %w", event, err)
+			}
+			if event == "stdout" {
+				writeString(stdout, chunk)
+			} else {
+				writeString(stderr, chunk)
+			}
+		case "done":
+			var out Execution
+			err := json.Unmarshal([]byte(data), &out)
+			if err != nil {
+				return Execution{}, fmt.Errorf("invalid done event: %w", err)
+			}
+			return out, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Execution{}, err
+	}
+	return Execution{}, errNoDone
+}
+
+// writeString writes the string to the writer (if any).
+func writeString(w io.Writer, s string) {
+	if w != nil && s != "" {
+		_, _ = io.WriteString(w, s)
+	}
+}
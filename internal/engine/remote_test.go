@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// newRemoteServer starts a fake codapi server
+// that responds with the given handler.
+func newRemoteServer(t *testing.T, handler http.HandlerFunc) *Remote {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cfg := &config.Config{
+		Remotes: map[string]*config.Remote{
+			"big": {URL: srv.URL + "/", Key: "secret", Timeout: 1},
+		},
+		Commands: map[string]config.SandboxCommands{
+			"python": map[string]*config.Command{
+				"run": {Engine: "remote", Remote: "big"},
+			},
+		},
+	}
+	return NewRemote(cfg, "python", "run").(*Remote)
+}
+
+// waitCanceled waits until the client cancels the request.
+func waitCanceled(w http.ResponseWriter, r *http.Request) {
+	// the server detects the closed connection
+	// only after the request body is read
+	_, _ = io.Copy(io.Discard, r.Body)
+	select {
+	case <-r.Context().Done():
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestRemoteExec(t *testing.T) {
+	logx.Mock()
+	req := Request{
+		ID:      "python_run_42",
+		Sandbox: "python",
+		Command: "run",
+		Files:   Files{"": "print(42)"},
+		Stdin:   "hello",
+		Key:     "alice",
+		Client:  "10.0.0.42",
+	}
+
+	t.Run("success", func(t *testing.T) {
+		var got Request
+		var header http.Header
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.Header().Set("content-type", "application/json")
+			fmt.Fprint(w, `{"id":"python_run_remote","ok":true,"reason":"exit","stdout":"42","stdout_bytes":2}`)
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, out.OK)
+		be.Equal(t, out.Reason, ReasonExit)
+		be.Equal(t, out.Stdout, "42")
+		be.Equal(t, out.StdoutBytes, int64(2))
+		be.Equal(t, out.Err, nil)
+
+		be.Equal(t, got.Sandbox, "python")
+		be.Equal(t, got.Command, "run")
+		be.Equal(t, got.Files, req.Files)
+		be.Equal(t, got.Stdin, "hello")
+		be.Equal(t, got.Key, "")
+		be.Equal(t, header.Get("authorization"), "Bearer secret")
+		be.Equal(t, header.Get("x-forwarded-for"), "10.0.0.42")
+		be.Equal(t, header.Get("accept"), "")
+	})
+	t.Run("failed", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id":"python_run_remote","ok":false,"exit_code":1,"reason":"exit","stderr":"NameError"}`)
+		})
+		out := engine.Exec(req)
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, !out.OK)
+		be.Equal(t, out.ExitCode, 1)
+		be.Equal(t, out.Stderr, "NameError")
+		be.Equal(t, out.Err, nil)
+	})
+	t.Run("busy", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"id":"python_run_remote","ok":false,"reason":"busy","stderr":"busy: try again later"}`)
+		})
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonBusy)
+		be.Err(t, out.Err, ErrBusy)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprint(w, `{"id":"-","ok":false,"stderr":"files[]: exceeds nfile limit of 10 bytes"}`)
+		})
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInvalid)
+		be.Equal(t, out.Stderr, "files[]: exceeds nfile limit of 10 bytes")
+	})
+	t.Run("internal error", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"id":"-","ok":false,"stderr":"missing or invalid API key"}`)
+		})
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInternal)
+		var execErr ExecutionError
+		be.True(t, errors.As(out.Err, &execErr))
+		be.Equal(t, out.Err.Error(), "remote exec: status 401: missing or invalid API key")
+	})
+	t.Run("invalid response", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "hello")
+		})
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInternal)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		engine := newRemoteServer(t, waitCanceled)
+		engine.timeout = 50 * time.Millisecond
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonTimeout)
+		be.Err(t, out.Err, nil)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		engine := newRemoteServer(t, waitCanceled)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		out := engine.ExecStream(ctx, req, nil)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonCanceled)
+	})
+	t.Run("no server", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {})
+		engine.url = "http://127.0.0.1:1/v1/exec"
+		out := engine.Exec(req)
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInternal)
+	})
+}
+
+func TestRemoteExecStream(t *testing.T) {
+	logx.Mock()
+	req := Request{ID: "python_run_42", Sandbox: "python", Command: "run"}
+
+	t.Run("events", func(t *testing.T) {
+		var accept string
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			accept = r.Header.Get("accept")
+			w.Header().Set("content-type", "text/event-stream")
+			fmt.Fprint(w, "event: stdout\ndata: \"hello \"\n\n")
+			fmt.Fprint(w, "event: stderr\ndata: \"oops\"\n\n")
+			fmt.Fprint(w, "event: stdout\ndata: \"world\"\n\n")
+			fmt.Fprint(w, "event: done\ndata: {\"id\":\"remote\",\"ok\":true,\"stdout\":\"hello world\",\"stderr\":\"oops\"}\n\n")
+		})
+		var stdout, stderr bytes.Buffer
+		out := engine.ExecStream(context.Background(), req, &Stream{Stdout: &stdout, Stderr: &stderr})
+		be.Equal(t, accept, "text/event-stream")
+		be.Equal(t, out.ID, req.ID)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello world")
+		be.Equal(t, stdout.String(), "hello world")
+		be.Equal(t, stderr.String(), "oops")
+	})
+	t.Run("no done", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "text/event-stream")
+			fmt.Fprint(w, "event: stdout\ndata: \"hello\"\n\n")
+		})
+		var stdout bytes.Buffer
+		out := engine.ExecStream(context.Background(), req, &Stream{Stdout: &stdout})
+		be.True(t, !out.OK)
+		be.Equal(t, out.Reason, ReasonInternal)
+		be.True(t, errors.Is(out.Err, errNoDone))
+		be.Equal(t, stdout.String(), "hello")
+	})
+	t.Run("no streaming", func(t *testing.T) {
+		engine := newRemoteServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/json")
+			fmt.Fprint(w, `{"id":"remote","ok":true,"stdout":"hello"}`)
+		})
+		var stdout bytes.Buffer
+		out := engine.ExecStream(context.Background(), req, &Stream{Stdout: &stdout})
+		be.True(t, out.OK)
+		be.Equal(t, stdout.String(), "hello")
+	})
+}
+
+func TestNewRemote(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg := &config.Config{
+			Remotes: map[string]*config.Remote{"big": {URL: "http://10.0.0.2:1313"}},
+			Commands: map[string]config.SandboxCommands{
+				"python": map[string]*config.Command{"run": {Engine: "remote", Remote: "big"}},
+			},
+		}
+		engine := NewRemote(cfg, "python", "run").(*Remote)
+		be.Equal(t, engine.url, "http://10.0.0.2:1313/v1/exec")
+		be.Equal(t, engine.timeout, defaultRemoteTimeout*time.Second)
+	})
+	t.Run("unknown remote", func(t *testing.T) {
+		cfg := &config.Config{
+			Commands: map[string]config.SandboxCommands{
+				"python": map[string]*config.Command{"run": {Engine: "remote", Remote: "big"}},
+			},
+		}
+		defer func() {
+			be.True(t, recover() != nil)
+		}()
+		NewRemote(cfg, "python", "run")
+	})
+}
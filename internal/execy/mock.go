@@ -36,8 +36,12 @@ func (r *mockRunner) Run(cmd *exec.Cmd) error {
 		// so let's return an empty "success" result
 		out = CmdOut{}
 	}
-	_, _ = cmd.Stdout.Write([]byte(out.Stdout))
-	_, _ = cmd.Stderr.Write([]byte(out.Stderr))
+	if cmd.Stdout != nil {
+		_, _ = cmd.Stdout.Write([]byte(out.Stdout))
+	}
+	if cmd.Stderr != nil {
+		_, _ = cmd.Stderr.Write([]byte(out.Stderr))
+	}
 	return out.Err
 }
 
@@ -2,14 +2,23 @@
 package fileio
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/fs"
+	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotRegular = errors.New("not a regular file")
+	ErrTooLarge   = errors.New("file too large")
 )
 
 // Exists checks if the specified path exists.
@@ -110,6 +119,98 @@ func WriteFile(path, content string, perm fs.FileMode) (err error) {
 	return os.WriteFile(path, data, perm)
 }
 
+// DecodedSize returns the size of the file content as written
+// by WriteFile (decoded size for data URL-encoded content).
+func DecodedSize(content string) int {
+	if !strings.HasPrefix(content, "data:") {
+		return len(content)
+	}
+	meta, encoded, found := strings.Cut(content, ",")
+	if !found {
+		return len(content)
+	}
+	if !strings.HasSuffix(meta, "base64") {
+		return len(encoded)
+	}
+	encoded = strings.TrimRight(encoded, "=")
+	return len(encoded) * 3 / 4
+}
+
+// ReadFile reads the file from disk.
+// The reverse of WriteFile: returns text content as is,
+// and binary content (or text that looks like a data URL)
+// encoded as a data URL, e.g. data:image/png;base64,iVBORw0KGgo=
+func ReadFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return EncodeContent(path, data), nil
+}
+
+// EncodeContent encodes the file data the same way as ReadFile.
+// Uses the file extension to determine the data URL media type.
+func EncodeContent(name string, data []byte) string {
+	if utf8.Valid(data) && !bytes.Contains(data, []byte{0}) && !bytes.HasPrefix(data, []byte("data:")) {
+		// text file
+		return string(data)
+	}
+	// drop the parameters (e.g. charset), if any
+	mtype, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(name)), ";")
+	if mtype == "" {
+		mtype = "application/octet-stream"
+	}
+	return "data:" + mtype + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// ReadFileIn reads a regular file relative to the root directory.
+// Does not follow symlinks outside of the root, and does not trust
+// the file size reported by the file system. Fails with ErrNotRegular
+// if the file is a directory, symlink or special file, and with
+// ErrTooLarge if the file exceeds maxSize bytes (unlimited if maxSize <= 0).
+func ReadFileIn(root *os.Root, name string, maxSize int64) ([]byte, error) {
+	data, _, err := readFileIn(root, name, maxSize)
+	return data, err
+}
+
+// readFileIn reads a regular file relative to the root directory
+// and returns its content and permissions. See ReadFileIn for details.
+func readFileIn(root *os.Root, name string, maxSize int64) ([]byte, fs.FileMode, error) {
+	info, err := root.Lstat(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, 0, ErrNotRegular
+	}
+	// the file can be replaced after the check, so open it without
+	// blocking (in case it is a fifo now) and check it again
+	f, err := root.OpenFile(name, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err = f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, 0, ErrNotRegular
+	}
+	var r io.Reader = f
+	if maxSize > 0 {
+		r = io.LimitReader(f, maxSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, 0, ErrTooLarge
+	}
+	return data, info.Mode().Perm(), nil
+}
+
 // JoinDir joins a directory path with a relative file path,
 // making sure that the resulting path is still inside the directory.
 // Returns an error otherwise.
@@ -153,3 +254,55 @@ func MkdirTemp(perm fs.FileMode) (string, error) {
 	}
 	return dir, nil
 }
+
+// CopyDir copies the contents of the source directory
+// to the destination directory, including subdirectories.
+// Overwrites existing files and preserves file permissions.
+// Skips symlinks and special files, and never reads outside
+// of the source directory. Fails with ErrTooLarge if the total size
+// of the files exceeds maxSize bytes (unlimited if maxSize <= 0).
+func CopyDir(srcDir, dstDir string, maxSize int64) error {
+	root, err := os.OpenRoot(srcDir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = root.Close() }()
+
+	var total int64
+	return fs.WalkDir(root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, filepath.FromSlash(name))
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(dst, info.Mode().Perm()|0700)
+		}
+		if !d.Type().IsRegular() {
+			// skip symlinks and special files
+			return nil
+		}
+		limit := int64(0)
+		if maxSize > 0 {
+			limit = max(maxSize-total, 1)
+		}
+		data, perm, err := readFileIn(root, name, limit)
+		if errors.Is(err, ErrNotRegular) {
+			// replaced after listing the directory
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		total += int64(len(data))
+		if maxSize > 0 && total > maxSize {
+			return ErrTooLarge
+		}
+		// remove the existing file in case it is read-only
+		_ = os.Remove(dst)
+		return os.WriteFile(dst, data, perm)
+	})
+}
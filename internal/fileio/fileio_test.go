@@ -1,6 +1,7 @@
 package fileio
 
 import (
+	"errors"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -187,6 +188,58 @@ func TestWriteFile(t *testing.T) {
 	})
 }
 
+func TestDecodedSize(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"data:text/plain,hello", 5},
+		{"data:application/octet-stream;base64,MTIz", 3},
+		{"data:application/octet-stream;base64,MTI=", 2},
+		{"data:application/octet-stream;base64,MQ==", 1},
+		{"data:invalid", 12},
+	}
+	for _, test := range tests {
+		be.Equal(t, DecodedSize(test.content), test.want)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, data, want string
+	}{
+		{"text.txt", "hello", "hello"},
+		{"empty.txt", "", ""},
+		{"image.png", "\x89PNG\r\n", "data:image/png;base64,iVBORw0K"},
+		{"data.bin", "\x00\x01", "data:application/octet-stream;base64,AAE="},
+		{"url.txt", "data:,hello", "data:text/plain;base64,ZGF0YTosaGVsbG8="},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name)
+			err := os.WriteFile(path, []byte(test.data), 0644)
+			be.Err(t, err, nil)
+			got, err := ReadFile(path)
+			be.Err(t, err, nil)
+			be.Equal(t, got, test.want)
+
+			// the result can be written back as is
+			err = WriteFile(path, got, 0644)
+			be.Err(t, err, nil)
+			data, err := os.ReadFile(path)
+			be.Err(t, err, nil)
+			be.Equal(t, string(data), test.data)
+		})
+	}
+	t.Run("not found", func(t *testing.T) {
+		_, err := ReadFile(filepath.Join(dir, "missing.txt"))
+		be.True(t, errors.Is(err, os.ErrNotExist))
+	})
+}
+
 func TestJoinDir(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -301,3 +354,100 @@ func TestMkdirTemp(t *testing.T) {
 		be.Equal(t, info.Mode().Perm(), perm)
 	})
 }
+
+func TestCopyDir(t *testing.T) {
+	src := t.TempDir()
+	_ = os.WriteFile(filepath.Join(src, "main.py"), []byte("print(42)"), 0444)
+	_ = os.MkdirAll(filepath.Join(src, "lib"), 0755)
+	_ = os.WriteFile(filepath.Join(src, "lib", "util.py"), []byte("pass"), 0644)
+
+	t.Run("copy", func(t *testing.T) {
+		dst := t.TempDir()
+		err := CopyDir(src, dst, 0)
+		be.Err(t, err, nil)
+
+		data, err := os.ReadFile(filepath.Join(dst, "main.py"))
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "print(42)")
+		info, err := os.Stat(filepath.Join(dst, "main.py"))
+		be.Err(t, err, nil)
+		be.Equal(t, info.Mode().Perm(), os.FileMode(0444))
+
+		data, err = os.ReadFile(filepath.Join(dst, "lib", "util.py"))
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "pass")
+	})
+	t.Run("overwrite", func(t *testing.T) {
+		dst := t.TempDir()
+		_ = os.WriteFile(filepath.Join(dst, "main.py"), []byte("old"), 0444)
+		err := CopyDir(src, dst, 0)
+		be.Err(t, err, nil)
+		data, err := os.ReadFile(filepath.Join(dst, "main.py"))
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "print(42)")
+	})
+	t.Run("symlinks", func(t *testing.T) {
+		outside := t.TempDir()
+		_ = os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+		src := t.TempDir()
+		_ = os.Symlink(outside, filepath.Join(src, "results"))
+		_ = os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(src, "secret.txt"))
+
+		dst := t.TempDir()
+		err := CopyDir(src, dst, 0)
+		be.Err(t, err, nil)
+		be.True(t, !Exists(filepath.Join(dst, "results")))
+		be.True(t, !Exists(filepath.Join(dst, "secret.txt")))
+	})
+	t.Run("too large", func(t *testing.T) {
+		err := CopyDir(src, t.TempDir(), 10)
+		be.Err(t, err, ErrTooLarge)
+		err = CopyDir(src, t.TempDir(), 13)
+		be.Err(t, err, nil)
+	})
+	t.Run("missing source", func(t *testing.T) {
+		err := CopyDir(filepath.Join(src, "missing"), t.TempDir(), 0)
+		be.Err(t, err)
+	})
+}
+
+func TestReadFileIn(t *testing.T) {
+	outside := t.TempDir()
+	_ = os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hello"), 0644)
+	_ = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	_ = os.Symlink(filepath.Join(dir, "data.txt"), filepath.Join(dir, "link.txt"))
+	_ = os.Symlink(outside, filepath.Join(dir, "results"))
+
+	root, err := os.OpenRoot(dir)
+	be.Err(t, err, nil)
+	defer func() { _ = root.Close() }()
+
+	t.Run("regular", func(t *testing.T) {
+		data, err := ReadFileIn(root, "data.txt", 5)
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "hello")
+	})
+	t.Run("too large", func(t *testing.T) {
+		_, err := ReadFileIn(root, "data.txt", 4)
+		be.Err(t, err, ErrTooLarge)
+	})
+	t.Run("not regular", func(t *testing.T) {
+		_, err := ReadFileIn(root, "sub", 0)
+		be.Err(t, err, ErrNotRegular)
+		_, err = ReadFileIn(root, "link.txt", 0)
+		be.Err(t, err, ErrNotRegular)
+	})
+	t.Run("outside of root", func(t *testing.T) {
+		_, err := ReadFileIn(root, "results/secret.txt", 0)
+		be.Err(t, err)
+		_, err = ReadFileIn(root, "../secret.txt", 0)
+		be.Err(t, err)
+	})
+}
+
+func TestEncodeContent(t *testing.T) {
+	be.Equal(t, EncodeContent("main.py", []byte("print(42)")), "print(42)")
+	be.Equal(t, EncodeContent("plot.png", []byte{0x89, 0x50, 0}), "data:image/png;base64,iVAA")
+}
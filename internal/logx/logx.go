@@ -2,17 +2,70 @@
 package logx
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 )
 
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var output io.Writer = os.Stderr
 var logger = log.New(os.Stderr, "", log.LstdFlags)
+
+// jsonLogger writes JSON records (nil for the text format).
+var jsonLogger *slog.Logger
+
+// level is the minimum level of logged messages.
+var level = new(slog.LevelVar)
+
+// Verbose enables debug messages regardless of the level.
 var Verbose = false
 
 // SetOutput sets the output destination.
 func SetOutput(w io.Writer) {
+	output = w
 	logger.SetOutput(w)
+	if jsonLogger != nil {
+		jsonLogger = newJSONLogger(w)
+	}
+}
+
+// SetFormat sets the log format (text or json).
+func SetFormat(format string) error {
+	switch format {
+	case "", FormatText:
+		jsonLogger = nil
+	case FormatJSON:
+		jsonLogger = newJSONLogger(output)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	return nil
+}
+
+// SetLevel sets the minimum level of logged messages
+// (debug, info, warn or error).
+func SetLevel(name string) error {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(name))
+	if err != nil {
+		return fmt.Errorf("unknown log level: %s", name)
+	}
+	level.Set(lvl)
+	return nil
+}
+
+// JSON returns true if the log format is JSON.
+func JSON() bool {
+	return jsonLogger != nil
 }
 
 // Printf prints a formatted message.
@@ -27,19 +80,72 @@ func Println(v ...any) {
 
 // Log prints a message.
 func Log(message string, args ...any) {
-	if len(args) == 0 {
-		logger.Println(message)
-	} else {
-		logger.Printf(message+"\n", args...)
-	}
+	logf(slog.LevelInfo, message, args...)
+}
+
+// Warn prints a warning message.
+func Warn(message string, args ...any) {
+	logf(slog.LevelWarn, message, args...)
+}
+
+// Error prints an error message.
+func Error(message string, args ...any) {
+	logf(slog.LevelError, message, args...)
 }
 
-// Debug prints a message if the verbose mode is on.
+// Debug prints a message if the verbose mode is on
+// or the level is debug.
 func Debug(message string, args ...any) {
-	if !Verbose {
+	logf(slog.LevelDebug, message, args...)
+}
+
+// LogAttrs prints a message with the attributes.
+// The text format lists the attributes as key=value pairs
+// after the message.
+func LogAttrs(lvl slog.Level, message string, attrs ...slog.Attr) {
+	if !enabled(lvl) {
+		return
+	}
+	if jsonLogger != nil {
+		jsonLogger.LogAttrs(context.Background(), lvl, message, attrs...)
+		return
+	}
+	var b strings.Builder
+	b.WriteString(message)
+	for _, attr := range attrs {
+		b.WriteString(" " + attr.String())
+	}
+	logger.Println(b.String())
+}
+
+// logf prints a formatted message with the given level.
+func logf(lvl slog.Level, message string, args ...any) {
+	if !enabled(lvl) {
 		return
 	}
-	Log(message, args...)
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+	if jsonLogger != nil {
+		jsonLogger.Log(context.Background(), lvl, message)
+		return
+	}
+	logger.Println(message)
+}
+
+// enabled returns true if the messages with the level should be logged.
+func enabled(lvl slog.Level) bool {
+	if lvl == slog.LevelDebug && Verbose {
+		return true
+	}
+	return lvl >= level.Level()
+}
+
+// newJSONLogger creates a logger that writes JSON records.
+// The logx functions filter messages by level themselves.
+func newJSONLogger(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	return slog.New(slog.NewJSONHandler(w, opts))
 }
 
 // Mock creates a new Memory and installs it as the logger output
@@ -1,6 +1,9 @@
 package logx
 
 import (
+	"encoding/json"
+	"log/slog"
+	"strings"
 	"testing"
 
 	"github.com/nalgeon/be"
@@ -54,3 +57,97 @@ func TestDebug(t *testing.T) {
 		be.Equal(t, len(mem.Lines), 0)
 	})
 }
+
+func TestLevels(t *testing.T) {
+	mem := NewMemory("log")
+	SetOutput(mem)
+	Verbose = false
+	defer func() { _ = SetLevel("info") }()
+
+	t.Run("info", func(t *testing.T) {
+		mem.Clear()
+		Debug("debug")
+		Log("info")
+		Warn("warn")
+		Error("error")
+		be.Equal(t, len(mem.Lines), 3)
+		mem.MustNotHave(t, "debug")
+	})
+	t.Run("error", func(t *testing.T) {
+		mem.Clear()
+		err := SetLevel("error")
+		be.Err(t, err, nil)
+		Log("info")
+		Warn("warn")
+		Error("error")
+		be.Equal(t, len(mem.Lines), 1)
+		mem.MustHave(t, "error")
+	})
+	t.Run("debug", func(t *testing.T) {
+		mem.Clear()
+		err := SetLevel("DEBUG")
+		be.Err(t, err, nil)
+		Debug("debug")
+		be.Equal(t, len(mem.Lines), 1)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		err := SetLevel("verbose")
+		be.Err(t, err, "unknown log level: verbose")
+	})
+}
+
+func TestSetFormat(t *testing.T) {
+	mem := NewMemory("log")
+	SetOutput(mem)
+	defer func() { _ = SetFormat(FormatText) }()
+
+	t.Run("json", func(t *testing.T) {
+		err := SetFormat(FormatJSON)
+		be.Err(t, err, nil)
+		be.True(t, JSON())
+		Log("value: %d", 42)
+		Warn("careful")
+		be.Equal(t, len(mem.Lines), 2)
+		var rec map[string]any
+		err = json.Unmarshal([]byte(mem.Lines[0]), &rec)
+		be.Err(t, err, nil)
+		be.Equal(t, rec["level"], "INFO")
+		be.Equal(t, rec["msg"], "value: 42")
+		mem.MustHave(t, `"level":"WARN"`, `"msg":"careful"`)
+	})
+	t.Run("text", func(t *testing.T) {
+		mem.Clear()
+		err := SetFormat(FormatText)
+		be.Err(t, err, nil)
+		be.Equal(t, JSON(), false)
+		Log("value: %d", 42)
+		be.True(t, strings.HasSuffix(mem.Lines[0], " value: 42\n"))
+	})
+	t.Run("unknown", func(t *testing.T) {
+		err := SetFormat("xml")
+		be.Err(t, err, "unknown log format: xml")
+	})
+}
+
+func TestLogAttrs(t *testing.T) {
+	mem := NewMemory("log")
+	SetOutput(mem)
+	Verbose = false
+	defer func() { _ = SetFormat(FormatText) }()
+
+	t.Run("text", func(t *testing.T) {
+		LogAttrs(slog.LevelInfo, "execution", slog.String("id", "42"), slog.Int("duration", 10))
+		mem.MustHave(t, "execution id=42 duration=10")
+	})
+	t.Run("json", func(t *testing.T) {
+		mem.Clear()
+		_ = SetFormat(FormatJSON)
+		LogAttrs(slog.LevelInfo, "execution", slog.String("id", "42"), slog.Int("duration", 10))
+		mem.MustHave(t, `"msg":"execution","id":"42","duration":10`)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		mem.Clear()
+		LogAttrs(slog.LevelDebug, "execution")
+		be.Equal(t, len(mem.Lines), 0)
+	})
+}
@@ -3,15 +3,18 @@ package logx
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/nalgeon/be"
 )
 
 // Memory stores logged messages in a slice.
+// It is safe for concurrent use (except for direct access to Lines).
 type Memory struct {
 	Name  string
 	Lines []string
+	mu    sync.Mutex
 }
 
 // NewMemory creates a new memory destination.
@@ -22,17 +25,23 @@ func NewMemory(name string) *Memory {
 // Write implements the io.Writer interface.
 func (m *Memory) Write(p []byte) (n int, err error) {
 	msg := string(p)
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Lines = append(m.Lines, msg)
 	return len(p), nil
 }
 
 // WriteString writes a string to the memory.
 func (m *Memory) WriteString(s string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Lines = append(m.Lines, s)
 }
 
 // Has returns true if the memory has the message.
 func (m *Memory) Has(message ...string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, line := range m.Lines {
 		containsAll := true
 		for _, part := range message {
@@ -64,11 +73,15 @@ func (m *Memory) MustNotHave(t *testing.T, message ...string) {
 
 // Clear clears the memory.
 func (m *Memory) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Lines = []string{}
 }
 
 // Print prints memory lines to stdout.
 func (m *Memory) Print() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, line := range m.Lines {
 		fmt.Println(line)
 	}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func write(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	err := r.Write(&b)
+	be.Err(t, err, nil)
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Total requests.", "sandbox", "outcome")
+	c.Inc("python", "ok")
+	c.Inc("python", "ok")
+	c.Add(3, "go", "timeout")
+	c.Add(-1, "go", "timeout")
+	be.Equal(t, c.Value("python", "ok"), 2.0)
+	be.Equal(t, c.Value("go", "timeout"), 3.0)
+	be.Equal(t, c.Value("go", "ok"), 0.0)
+
+	want := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{sandbox="go",outcome="timeout"} 3
+requests_total{sandbox="python",outcome="ok"} 2
+`
+	be.Equal(t, write(t, r), want)
+}
+
+func TestCounter_NoLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("failures_total", "Total failures.")
+	c.Inc()
+	want := `# HELP failures_total Total failures.
+# TYPE failures_total counter
+failures_total 1
+`
+	be.Equal(t, write(t, r), want)
+}
+
+func TestCounter_Labels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Total requests.", "name")
+	c.Inc("a \"quoted\"\nname\\")
+	got := write(t, r)
+	be.True(t, strings.Contains(got, `requests_total{name="a \"quoted\"\nname\\"} 1`))
+
+	defer func() {
+		be.True(t, recover() != nil)
+	}()
+	c.Inc("a", "b")
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("duration_seconds", "Duration.", []float64{0.1, 1}, "sandbox")
+	h.Observe(0.05, "python")
+	h.Observe(0.5, "python")
+	h.Observe(5, "python")
+	be.Equal(t, h.Count("python"), uint64(3))
+	be.Equal(t, h.Count("go"), uint64(0))
+
+	want := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{sandbox="python",le="0.1"} 1
+duration_seconds_bucket{sandbox="python",le="1"} 2
+duration_seconds_bucket{sandbox="python",le="+Inf"} 3
+duration_seconds_sum{sandbox="python"} 5.55
+duration_seconds_count{sandbox="python"} 3
+`
+	be.Equal(t, write(t, r), want)
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	value := 1.0
+	r.NewGaugeFunc("workers_busy", "Busy workers.", func() []Sample {
+		return []Sample{
+			{Values: []string{""}, Value: value},
+			{Values: []string{"python"}, Value: 2 * value},
+		}
+	}, "sandbox")
+	value = 2
+
+	want := `# HELP workers_busy Busy workers.
+# TYPE workers_busy gauge
+workers_busy{sandbox=""} 2
+workers_busy{sandbox="python"} 4
+`
+	be.Equal(t, write(t, r), want)
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Total requests.")
+	defer func() {
+		be.True(t, recover() != nil)
+	}()
+	r.NewCounter("requests_total", "Total requests.")
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Total requests.").Inc()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	be.Equal(t, w.Code, 200)
+	be.Equal(t, w.Header().Get("content-type"), contentType)
+	be.True(t, strings.Contains(w.Body.String(), "requests_total 1\n"))
+}
@@ -0,0 +1,129 @@
+// Sandbox discovery.
+package sandbox
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+// A SandboxInfo describes a sandbox and its commands.
+type SandboxInfo struct {
+	Name     string        `json:"name"`
+	Versions []string      `json:"versions"`
+	Commands []CommandInfo `json:"commands"`
+}
+
+// A CommandInfo describes a sandbox command.
+type CommandInfo struct {
+	Name   string `json:"name"`
+	Engine string `json:"engine"`
+	Entry  string `json:"entry,omitempty"`
+	// Total timeout of the command, in seconds (0 if not limited).
+	Timeout int `json:"timeout"`
+	// Maximum output size of the command steps, in bytes.
+	NOutput int `json:"noutput"`
+	// The command steps, including the before and after ones.
+	Steps []StepInfo `json:"steps,omitempty"`
+}
+
+// A StepInfo describes a command step.
+type StepInfo struct {
+	// Timeout in seconds.
+	Timeout int `json:"timeout"`
+	// Maximum output size in bytes.
+	NOutput int `json:"noutput"`
+}
+
+// Sandboxes returns the configured sandboxes sorted by name.
+func Sandboxes() []SandboxInfo {
+	infos := make([]SandboxInfo, 0, len(commands))
+	for _, name := range sortedKeys(commands) {
+		infos = append(infos, sandboxInfo(name, commands[name]))
+	}
+	return infos
+}
+
+// GetSandbox returns the sandbox with the given name.
+func GetSandbox(name string) (SandboxInfo, error) {
+	cmds, ok := commands[name]
+	if !ok {
+		return SandboxInfo{}, ErrUnknownSandbox
+	}
+	return sandboxInfo(name, cmds), nil
+}
+
+// sandboxInfo describes the sandbox according to its configuration.
+func sandboxInfo(name string, cmds config.SandboxCommands) SandboxInfo {
+	info := SandboxInfo{
+		Name:     name,
+		Versions: []string{},
+		Commands: make([]CommandInfo, 0, len(cmds)),
+	}
+	// the sandbox versions are the versions of the boxes
+	// its commands run in, e.g. python:3.12 -> 3.12
+	versions := map[string]bool{}
+	for _, cmdName := range sortedKeys(cmds) {
+		cmd := cmds[cmdName]
+		info.Commands = append(info.Commands, commandInfo(cmdName, cmd))
+		for _, step := range commandSteps(cmd) {
+			if step.Version != "" {
+				// the step always runs in the pinned version
+				continue
+			}
+			for _, version := range boxVersions(step.Box) {
+				versions[version] = true
+			}
+		}
+	}
+	info.Versions = append(info.Versions, sortedKeys(versions)...)
+	return info
+}
+
+// commandInfo describes the command according to its configuration.
+func commandInfo(name string, cmd *config.Command) CommandInfo {
+	info := CommandInfo{Name: name, Engine: cmd.Engine, Entry: cmd.Entry}
+	for _, step := range commandSteps(cmd) {
+		info.Timeout += step.Timeout
+		info.NOutput = max(info.NOutput, step.NOutput)
+		info.Steps = append(info.Steps, StepInfo{Timeout: step.Timeout, NOutput: step.NOutput})
+	}
+	if cmd.Engine == "remote" {
+		// the remote server enforces the step limits,
+		// the remote engine only limits the request time
+		info.Timeout = engine.RemoteTimeout(remotes[cmd.Remote])
+	}
+	return info
+}
+
+// commandSteps returns the command steps in the execution order,
+// including the before and after ones.
+func commandSteps(cmd *config.Command) []*config.Step {
+	steps := make([]*config.Step, 0, len(cmd.Steps)+2)
+	if cmd.Before != nil {
+		steps = append(steps, cmd.Before)
+	}
+	steps = append(steps, cmd.Steps...)
+	if cmd.After != nil {
+		steps = append(steps, cmd.After)
+	}
+	return steps
+}
+
+// boxVersions returns the versions of the box
+// according to the configured box names.
+func boxVersions(box string) []string {
+	var versions []string
+	if box == "" {
+		return versions
+	}
+	for name := range boxes {
+		if version, ok := strings.CutPrefix(name, box+":"); ok {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
@@ -0,0 +1,113 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+)
+
+var catalogCfg = &config.Config{
+	PoolSize: 8,
+	HTTP: &config.HTTP{
+		Hosts: map[string]string{"localhost": "localhost"},
+	},
+	Boxes: map[string]*config.Box{
+		"python":      {},
+		"python:3.11": {},
+		"python:3.12": {},
+		"pytest":      {},
+		"pytest:dev":  {},
+		"ruff":        {},
+		"ruff:0.5":    {},
+	},
+	Remotes: map[string]*config.Remote{
+		"gpu": {URL: "http://10.0.0.2:1313", Timeout: 30},
+	},
+	Commands: map[string]config.SandboxCommands{
+		"http": map[string]*config.Command{
+			"run": {Engine: "http"},
+		},
+		"python": map[string]*config.Command{
+			"run": {
+				Engine: "docker",
+				Entry:  "main.py",
+				Steps: []*config.Step{
+					{Box: "python", Timeout: 5, NOutput: 4096},
+				},
+			},
+			"test": {
+				Engine: "docker",
+				Entry:  "test_main.py",
+				Steps: []*config.Step{
+					{Box: "python", Timeout: 5, NOutput: 4096},
+					{Box: "pytest", Timeout: 10, NOutput: 8192},
+				},
+			},
+			"lint": {
+				Engine: "docker",
+				Before: &config.Step{Box: "python", Timeout: 1, NOutput: 1024},
+				Steps: []*config.Step{
+					{Box: "ruff", Version: "0.5", Timeout: 3, NOutput: 4096},
+				},
+				After: &config.Step{Box: "python", Timeout: 1, NOutput: 1024},
+			},
+			"train": {Engine: "remote", Remote: "gpu"},
+		},
+	},
+}
+
+func TestSandboxes(t *testing.T) {
+	_ = ApplyConfig(catalogCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	infos := Sandboxes()
+	be.Equal(t, len(infos), 2)
+	be.Equal(t, infos[0], SandboxInfo{
+		Name:     "http",
+		Versions: []string{},
+		Commands: []CommandInfo{{Name: "run", Engine: "http"}},
+	})
+	be.Equal(t, infos[1], SandboxInfo{
+		Name:     "python",
+		Versions: []string{"3.11", "3.12", "dev"},
+		Commands: []CommandInfo{
+			{
+				Name: "lint", Engine: "docker", Timeout: 5, NOutput: 4096,
+				Steps: []StepInfo{
+					{Timeout: 1, NOutput: 1024},
+					{Timeout: 3, NOutput: 4096},
+					{Timeout: 1, NOutput: 1024},
+				},
+			},
+			{
+				Name: "run", Engine: "docker", Entry: "main.py", Timeout: 5, NOutput: 4096,
+				Steps: []StepInfo{{Timeout: 5, NOutput: 4096}},
+			},
+			{
+				Name: "test", Engine: "docker", Entry: "test_main.py", Timeout: 15, NOutput: 8192,
+				Steps: []StepInfo{
+					{Timeout: 5, NOutput: 4096},
+					{Timeout: 10, NOutput: 8192},
+				},
+			},
+			{Name: "train", Engine: "remote", Timeout: 30},
+		},
+	})
+}
+
+func TestGetSandbox(t *testing.T) {
+	_ = ApplyConfig(catalogCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	t.Run("found", func(t *testing.T) {
+		info, err := GetSandbox("python")
+		be.Err(t, err, nil)
+		be.Equal(t, info.Name, "python")
+		be.Equal(t, len(info.Commands), 4)
+	})
+	t.Run("not found", func(t *testing.T) {
+		_, err := GetSandbox("rust")
+		be.Err(t, err, ErrUnknownSandbox)
+	})
+}
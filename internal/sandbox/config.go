@@ -3,6 +3,7 @@ package sandbox
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/nalgeon/codapi/internal/config"
 	"github.com/nalgeon/codapi/internal/engine"
@@ -14,11 +15,39 @@ import (
 // (the calling goroutines are workers).
 var semaphore *Semaphore
 
+// Optional per-sandbox and per-command concurrency limits,
+// enforced in addition to the global one.
+// sandbox : semaphore
+var sandboxSems = map[string]*Semaphore{}
+
+// sandbox : command : semaphore
+var commandSems = map[string]map[string]*Semaphore{}
+
+// key name : semaphore
+var keySems = map[string]*Semaphore{}
+
 var engineConstr = map[string]func(*config.Config, string, string) engine.Engine{
-	"docker": engine.NewDocker,
-	"http":   engine.NewHTTP,
+	"docker":     engine.NewDocker,
+	"docker-api": engine.NewDockerAPI,
+	"http":       engine.NewHTTP,
+	"plugin":     engine.NewPlugin,
+	"podman":     engine.NewPodman,
+	"process":    engine.NewProcess,
+	"remote":     engine.NewRemote,
 }
 
+// commands is the registry of command configurations.
+// sandbox : command : config
+var commands = map[string]config.SandboxCommands{}
+
+// boxes is the registry of configured boxes.
+// name : box
+var boxes = map[string]*config.Box{}
+
+// remotes is the registry of remote codapi servers.
+// name : remote
+var remotes = map[string]*config.Remote{}
+
 // engines is the registry of command executors.
 // Each engine executes a specific command in a specific sandbox.
 // sandbox : command : engine
@@ -28,10 +57,50 @@ var engines = map[string]map[string]engine.Engine{}
 
 // ApplyConfig fills engine registry according to the configuration.
 func ApplyConfig(cfg *config.Config) error {
-	semaphore = NewSemaphore(cfg.PoolSize)
+	queueTimeout := time.Duration(cfg.QueueTimeout) * time.Second
+	semaphore = NewSemaphore(cfg.PoolSize).WithQueue(cfg.QueueSize, queueTimeout)
+	jobs = NewJobQueue(cfg.Jobs)
+	sessions = NewSessionStore(cfg.Sessions)
+	imageChecks = &imageCache{}
+	sandboxSems = map[string]*Semaphore{}
+	commandSems = map[string]map[string]*Semaphore{}
+	keySems = map[string]*Semaphore{}
+	for _, key := range cfg.Keys {
+		if key.PoolSize > 0 {
+			keySems[key.Name] = NewSemaphore(key.PoolSize).WithQueue(cfg.QueueSize, queueTimeout)
+		}
+	}
+	err := applyAudit(cfg.Audit)
+	if err != nil {
+		return err
+	}
+	commandLimits = map[string]map[string]config.Limits{}
+	maxBody = mergeLimits(nil, cfg.Limits).NBody
+	commands = cfg.Commands
+	boxes = cfg.Boxes
+	remotes = cfg.Remotes
 	for sandName, sandCmds := range cfg.Commands {
+		sand := cfg.Sandboxes[sandName]
+		if sand == nil {
+			sand = &config.Sandbox{}
+		}
+		if sand.PoolSize > 0 {
+			sandboxSems[sandName] = NewSemaphore(sand.PoolSize).WithQueue(cfg.QueueSize, queueTimeout)
+		}
 		engines[sandName] = make(map[string]engine.Engine)
+		commandSems[sandName] = make(map[string]*Semaphore)
+		commandLimits[sandName] = make(map[string]config.Limits)
 		for cmdName, cmd := range sandCmds {
+			lim := mergeLimits(cmd.Limits, cfg.Limits)
+			commandLimits[sandName][cmdName] = lim
+			maxBody = max(maxBody, lim.NBody)
+			poolSize := cmd.PoolSize
+			if poolSize == 0 {
+				poolSize = sand.CommandPoolSize
+			}
+			if poolSize > 0 {
+				commandSems[sandName][cmdName] = NewSemaphore(poolSize).WithQueue(cfg.QueueSize, queueTimeout)
+			}
 			constructor, ok := engineConstr[cmd.Engine]
 			if !ok {
 				return fmt.Errorf("unknown engine: %s", cmd.Engine)
@@ -13,18 +13,47 @@ var cfg = &config.Config{
 	HTTP: &config.HTTP{
 		Hosts: map[string]string{"localhost": "localhost"},
 	},
+	Sandboxes: map[string]*config.Sandbox{
+		"python": {PoolSize: 4},
+	},
 	Boxes: map[string]*config.Box{
+		"alpine": {},
 		"http":   {},
 		"python": {},
 	},
 	Commands: map[string]config.SandboxCommands{
+		"alpine": map[string]*config.Command{
+			"echo": {
+				Engine: "docker",
+				Before: &config.Step{
+					Box: "alpine", User: "sandbox", Action: "run", Detach: true,
+					Command: []string{"echo", "before"},
+				},
+				Steps: []*config.Step{
+					{
+						Box: ":name", User: "sandbox", Action: "exec",
+						Command: []string{"sh", "main.sh"},
+						NOutput: 4096,
+					},
+				},
+				After: &config.Step{
+					Box: ":name", User: "sandbox", Action: "stop",
+				},
+			},
+		},
 		"http": map[string]*config.Command{
 			"run": {Engine: "http"},
 		},
 		"python": map[string]*config.Command{
 			"run": {
-				Engine: "docker",
-				Entry:  "main.py",
+				Engine:   "docker",
+				Entry:    "main.py",
+				PoolSize: 2,
+				Input: &config.Input{
+					Stdin: true, NStdin: 10,
+					Args: true, NArgs: 10,
+					Env: []string{"DEBUG"},
+				},
 				Steps: []*config.Step{
 					{Box: "python", Action: "run", NOutput: 4096},
 				},
@@ -38,11 +67,31 @@ func TestApplyConfig(t *testing.T) {
 	err := ApplyConfig(cfg)
 	be.Err(t, err, nil)
 	be.Equal(t, semaphore.Size(), cfg.PoolSize)
-	be.Equal(t, len(engines), 2)
+	be.Equal(t, len(engines), 3)
 	be.Equal(t, len(engines["http"]), 1)
 	_, ok := engines["http"]["run"].(*engine.HTTP)
 	be.True(t, ok)
 	be.Equal(t, len(engines["python"]), 2)
 	_, ok = engines["python"]["run"].(*engine.Docker)
 	be.True(t, ok)
+	be.Equal(t, len(sandboxSems), 1)
+	be.Equal(t, sandboxSems["python"].Size(), 4)
+	be.Equal(t, len(commandSems["python"]), 1)
+	be.Equal(t, commandSems["python"]["run"].Size(), 2)
+}
+
+func TestApplyConfig_CommandPoolSize(t *testing.T) {
+	defCfg := *cfg
+	defCfg.Sandboxes = map[string]*config.Sandbox{
+		"python": {CommandPoolSize: 3},
+	}
+	err := ApplyConfig(&defCfg)
+	be.Err(t, err, nil)
+	defer func() { _ = ApplyConfig(cfg) }()
+	be.Equal(t, len(sandboxSems), 0)
+	be.Equal(t, len(commandSems["python"]), 2)
+	// the command's own pool size takes precedence
+	be.Equal(t, commandSems["python"]["run"].Size(), 2)
+	be.Equal(t, commandSems["python"]["test"].Size(), 3)
+	be.Equal(t, len(commandSems["http"]), 0)
 }
@@ -0,0 +1,211 @@
+// Sandbox readiness checks.
+package sandbox
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+var errImageNotFound = errors.New("image not found")
+
+// readyTTL is how long the image check results are reused,
+// so that frequent readiness probes do not query the engines every time.
+const readyTTL = 5 * time.Second
+
+// imageChecks caches the latest image check results.
+var imageChecks = &imageCache{}
+
+// An imageCache holds the results of the latest image check.
+type imageCache struct {
+	mu     sync.Mutex
+	at     time.Time
+	docker Check
+	boxes  map[string]BoxCheck
+}
+
+// A Readiness describes whether the sandboxes are ready
+// to execute code, with a breakdown per check.
+type Readiness struct {
+	OK      bool                `json:"ok"`
+	Docker  Check               `json:"docker"`
+	Workers WorkersCheck        `json:"workers"`
+	Boxes   map[string]BoxCheck `json:"boxes"`
+}
+
+// A Check is a result of a readiness check.
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// A WorkersCheck reports whether there are free workers.
+type WorkersCheck struct {
+	OK   bool `json:"ok"`
+	Busy int  `json:"busy"`
+	Max  int  `json:"max"`
+}
+
+// A BoxCheck reports whether the box image is present locally.
+type BoxCheck struct {
+	Image string `json:"image"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Ready checks if the container engines (docker, podman or the Docker API)
+// respond, if the images of the boxes they use are present locally,
+// and if there are free workers to execute code. Boxes that are not used
+// by any container engine (e.g. by the process engine) are not checked.
+// The image check results are reused for readyTTL.
+func Ready() Readiness {
+	r := Readiness{
+		Workers: WorkersCheck{
+			Busy: semaphore.busy(),
+			Max:  semaphore.Cap(),
+		},
+	}
+	r.Workers.OK = r.Workers.Busy < r.Workers.Max
+	r.Docker, r.Boxes = imageChecks.get()
+
+	boxesOK := true
+	for _, check := range r.Boxes {
+		boxesOK = boxesOK && check.OK
+	}
+	r.OK = r.Docker.OK && r.Workers.OK && boxesOK
+	return r
+}
+
+// get returns the cached image check results,
+// or checks the images again if the results are stale.
+func (c *imageCache) get() (Check, map[string]BoxCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.boxes == nil || time.Since(c.at) >= readyTTL {
+		c.docker, c.boxes = checkImages()
+		c.at = time.Now()
+	}
+	return c.docker, maps.Clone(c.boxes)
+}
+
+// checkImages checks if the container engines respond
+// and the images of the boxes they use are present locally.
+func checkImages() (Check, map[string]BoxCheck) {
+	docker := Check{OK: true}
+	boxChecks := map[string]BoxCheck{}
+
+	// engine name : lister
+	listers := map[string]engine.ImageLister{}
+	// engine name : box names
+	used := map[string]map[string]bool{}
+	for sandName, sandCmds := range commands {
+		for cmdName, cmd := range sandCmds {
+			lister, ok := engines[sandName][cmdName].(engine.ImageLister)
+			if !ok {
+				continue
+			}
+			listers[cmd.Engine] = lister
+			if used[cmd.Engine] == nil {
+				used[cmd.Engine] = map[string]bool{}
+			}
+			for _, name := range commandBoxes(cmd) {
+				used[cmd.Engine][name] = true
+			}
+		}
+	}
+
+	var errs []string
+	for _, engName := range sortedKeys(listers) {
+		refs, err := listers[engName].Images()
+		if err != nil {
+			errs = append(errs, engName+": "+err.Error())
+		}
+		images := map[string]bool{}
+		for _, ref := range refs {
+			images[imageRef(ref)] = true
+		}
+		for name := range used[engName] {
+			box := boxes[name]
+			check := BoxCheck{Image: box.Image, OK: true}
+			if err != nil {
+				check.OK = false
+				check.Error = engName + " is not available"
+			} else if !images[imageRef(box.Image)] {
+				check.OK = false
+				check.Error = errImageNotFound.Error()
+			}
+			if prev, ok := boxChecks[name]; ok && !prev.OK {
+				// the box is used by several engines,
+				// and is not available in one of them
+				check = prev
+			}
+			boxChecks[name] = check
+		}
+	}
+	if len(errs) > 0 {
+		docker = Check{OK: false, Error: strings.Join(errs, "; ")}
+	}
+	return docker, boxChecks
+}
+
+// commandBoxes returns the names of the configured boxes
+// the command steps can run in, including the versioned ones
+// the request can select (e.g. python:3.12 for python).
+func commandBoxes(cmd *config.Command) []string {
+	var names []string
+	for _, step := range commandSteps(cmd) {
+		if step.Box == "" {
+			continue
+		}
+		if step.Version != "" {
+			name := step.Box
+			if step.Version != "latest" {
+				name += ":" + step.Version
+			}
+			names = append(names, name)
+			continue
+		}
+		names = append(names, step.Box)
+		for _, version := range boxVersions(step.Box) {
+			names = append(names, step.Box+":"+version)
+		}
+	}
+	// skip unknown boxes, the engine reports them on execution
+	return slices.DeleteFunc(names, func(name string) bool {
+		return boxes[name] == nil
+	})
+}
+
+// imageRef returns the normalized image reference, so that the same
+// image matches however it is written. Removes the default registry
+// and namespace (docker.io/library/python -> python) and the podman's
+// localhost/ prefix. Keeps only the digest if there is one, otherwise
+// adds the "latest" tag if the tag is not specified.
+func imageRef(image string) string {
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "localhost/"} {
+		if after, ok := strings.CutPrefix(image, prefix); ok {
+			image = strings.TrimPrefix(after, "library/")
+			break
+		}
+	}
+	image, digest, hasDigest := strings.Cut(image, "@")
+	slash := strings.LastIndex(image, "/")
+	colon := strings.LastIndex(image, ":")
+	hasTag := colon > slash
+	if hasDigest {
+		if hasTag {
+			image = image[:colon]
+		}
+		return image + "@" + digest
+	}
+	if hasTag {
+		return image
+	}
+	return image + ":latest"
+}
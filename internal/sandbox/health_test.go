@@ -0,0 +1,194 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/execy"
+)
+
+func TestReady(t *testing.T) {
+	healthCfg := &config.Config{
+		PoolSize: 2,
+		Boxes: map[string]*config.Box{
+			"python":     {Image: "codapi/python"},
+			"python:3.9": {Image: "codapi/python:3.9"},
+			"go":         {Image: "codapi/go:1.22"},
+			"ash":        {Image: "codapi/ash"},
+			"rust":       {Image: "codapi/rust"},
+		},
+		Commands: map[string]config.SandboxCommands{
+			"python": map[string]*config.Command{
+				"run": {Engine: "docker", Steps: []*config.Step{{Box: "python", Action: "run"}}},
+			},
+			"go": map[string]*config.Command{
+				"run": {Engine: "podman", Steps: []*config.Step{{Box: "go", Action: "run"}}},
+			},
+			"ash": map[string]*config.Command{
+				"run": {Engine: "process", Steps: []*config.Step{{Box: "ash", Action: "run"}}},
+			},
+		},
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		_ = ApplyConfig(healthCfg)
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "codapi/python:latest\ncodapi/python:3.9\nalpine:3.20\n"},
+			"podman image": {Stdout: "localhost/codapi/go:1.22\n"},
+		})
+		r := Ready()
+		be.True(t, r.OK)
+		be.Equal(t, r.Docker, Check{OK: true})
+		be.Equal(t, r.Workers, WorkersCheck{OK: true, Busy: 0, Max: 2})
+		be.Equal(t, len(r.Boxes), 3)
+		be.Equal(t, r.Boxes["python"], BoxCheck{Image: "codapi/python", OK: true})
+		be.Equal(t, r.Boxes["python:3.9"], BoxCheck{Image: "codapi/python:3.9", OK: true})
+		be.Equal(t, r.Boxes["go"], BoxCheck{Image: "codapi/go:1.22", OK: true})
+		mem.MustHave(t, "docker image ls")
+		mem.MustHave(t, "podman image ls")
+	})
+	t.Run("missing image", func(t *testing.T) {
+		_ = ApplyConfig(healthCfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "codapi/python:latest\ncodapi/python:3.9\n"},
+			"podman image": {Stdout: "codapi/go:1.21\n"},
+		})
+		r := Ready()
+		be.Equal(t, r.OK, false)
+		be.True(t, r.Docker.OK)
+		be.True(t, r.Boxes["python"].OK)
+		be.Equal(t, r.Boxes["go"], BoxCheck{Image: "codapi/go:1.22", OK: false, Error: "image not found"})
+	})
+	t.Run("engine unavailable", func(t *testing.T) {
+		_ = ApplyConfig(healthCfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "codapi/python:latest\ncodapi/python:3.9\n"},
+			"podman image": {Stderr: "Cannot connect to Podman", Err: errors.New("exit status 125")},
+		})
+		r := Ready()
+		be.Equal(t, r.OK, false)
+		be.Equal(t, r.Docker.OK, false)
+		be.Equal(t, r.Docker.Error, "podman: Cannot connect to Podman (exit status 125)")
+		be.True(t, r.Boxes["python"].OK)
+		be.Equal(t, r.Boxes["go"], BoxCheck{Image: "codapi/go:1.22", OK: false, Error: "podman is not available"})
+	})
+	t.Run("no containers", func(t *testing.T) {
+		cfg := &config.Config{
+			PoolSize: 2,
+			Boxes:    healthCfg.Boxes,
+			Commands: map[string]config.SandboxCommands{"ash": healthCfg.Commands["ash"]},
+		}
+		_ = ApplyConfig(cfg)
+		mem := execy.Mock(nil)
+		r := Ready()
+		be.True(t, r.OK)
+		be.Equal(t, len(r.Boxes), 0)
+		be.Equal(t, len(mem.Lines), 0)
+	})
+	t.Run("normalized", func(t *testing.T) {
+		cfg := &config.Config{
+			PoolSize: 2,
+			Boxes: map[string]*config.Box{
+				"alpine": {Image: "docker.io/library/alpine:3.20"},
+				"python": {Image: "python:3.12@sha256:abc"},
+				"go":     {Image: "localhost/codapi/go"},
+			},
+			Commands: map[string]config.SandboxCommands{
+				"sh": map[string]*config.Command{
+					"run": {Engine: "docker", Steps: []*config.Step{
+						{Box: "alpine", Action: "run"},
+						{Box: "python", Action: "run"},
+						{Box: "go", Action: "run"},
+					}},
+				},
+			},
+		}
+		_ = ApplyConfig(cfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "alpine:3.20 alpine@<none>\n" +
+				"python:<none> python@sha256:abc\n" +
+				"codapi/go:latest codapi/go@<none>\n"},
+		})
+		r := Ready()
+		be.True(t, r.OK)
+		be.True(t, r.Boxes["alpine"].OK)
+		be.True(t, r.Boxes["python"].OK)
+		be.True(t, r.Boxes["go"].OK)
+	})
+	t.Run("cached", func(t *testing.T) {
+		_ = ApplyConfig(healthCfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "codapi/python:latest\ncodapi/python:3.9\n"},
+			"podman image": {Stdout: "codapi/go:1.22\n"},
+		})
+		r := Ready()
+		be.True(t, r.OK)
+
+		mem := execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Err: errors.New("exit status 1")},
+			"podman image": {Err: errors.New("exit status 1")},
+		})
+		r = Ready()
+		be.True(t, r.OK)
+		be.Equal(t, len(r.Boxes), 3)
+		be.Equal(t, len(mem.Lines), 0)
+	})
+	t.Run("saturated", func(t *testing.T) {
+		_ = ApplyConfig(healthCfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: "codapi/python:latest\ncodapi/python:3.9\n"},
+			"podman image": {Stdout: "codapi/go:1.22\n"},
+		})
+		_ = semaphore.Acquire()
+		_ = semaphore.Acquire()
+		r := Ready()
+		be.Equal(t, r.OK, false)
+		be.Equal(t, r.Workers, WorkersCheck{OK: false, Busy: 2, Max: 2})
+	})
+	_ = ApplyConfig(cfg)
+}
+
+func Test_commandBoxes(t *testing.T) {
+	_ = ApplyConfig(&config.Config{
+		Boxes: map[string]*config.Box{
+			"python":      {Image: "codapi/python"},
+			"python:3.9":  {Image: "codapi/python:3.9"},
+			"python:3.12": {Image: "codapi/python:3.12"},
+			"postgres":    {Image: "codapi/postgres"},
+		},
+	})
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	cmd := &config.Command{
+		Before: &config.Step{Box: "postgres", Version: "latest"},
+		Steps: []*config.Step{
+			{Box: "python"},
+			{Box: "python", Version: "3.9"},
+			{Box: "unknown"},
+			{Action: "exec"},
+		},
+	}
+	be.Equal(t, commandBoxes(cmd), []string{"postgres", "python", "python:3.12", "python:3.9", "python:3.9"})
+}
+
+func Test_imageRef(t *testing.T) {
+	tests := map[string]string{
+		"codapi/python":                            "codapi/python:latest",
+		"codapi/python:3.12":                       "codapi/python:3.12",
+		"localhost:5000/codapi/go":                 "localhost:5000/codapi/go:latest",
+		"localhost:5000/go:1.22":                   "localhost:5000/go:1.22",
+		"python":                                   "python:latest",
+		"library/python:3.12":                      "library/python:3.12",
+		"docker.io/library/python":                 "python:latest",
+		"docker.io/codapi/python":                  "codapi/python:latest",
+		"localhost/codapi/python":                  "codapi/python:latest",
+		"python@sha256:abc":                        "python@sha256:abc",
+		"python:3.12@sha256:abc":                   "python@sha256:abc",
+		"docker.io/library/python:3.12@sha256:abc": "python@sha256:abc",
+	}
+	for image, want := range tests {
+		be.Equal(t, imageRef(image), want)
+	}
+}
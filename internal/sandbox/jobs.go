@@ -0,0 +1,215 @@
+// Asynchronous code execution jobs.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+// Job statuses.
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobCanceled = "canceled"
+)
+
+// Default job settings.
+const (
+	defaultJobTTL          = 5 * time.Minute
+	defaultJobMaxCount     = 1000
+	defaultJobMaxPending   = 100
+	defaultJobQueueTimeout = 5 * time.Minute
+)
+
+var ErrUnknownJob = errors.New("unknown job")
+var ErrTooManyJobs = errors.New("too many jobs: try again later")
+
+// jobs is the registry of asynchronous jobs.
+var jobs *JobQueue
+
+// A Job is an asynchronous code execution.
+type Job struct {
+	ID     string            `json:"id"`
+	Status string            `json:"status"`
+	Result *engine.Execution `json:"result,omitempty"`
+	// Name of the API key that submitted the job (if any).
+	Key string `json:"-"`
+}
+
+// A jobEntry is a job with its internal state.
+type jobEntry struct {
+	job      Job
+	owner    string
+	cancel   context.CancelFunc
+	finished time.Time
+}
+
+// A JobQueue runs asynchronous jobs and keeps their results
+// for a limited time. Jobs wait for a free worker outside
+// of the request queue, for no longer than the queue timeout.
+// Each client can have a limited number of pending jobs.
+type JobQueue struct {
+	mu           sync.Mutex
+	jobs         map[string]*jobEntry
+	ttl          time.Duration
+	maxCount     int
+	maxPending   int
+	queueTimeout time.Duration
+}
+
+// NewJobQueue creates a new job queue according to the configuration.
+func NewJobQueue(cfg *config.Jobs) *JobQueue {
+	q := &JobQueue{
+		jobs:         map[string]*jobEntry{},
+		ttl:          defaultJobTTL,
+		maxCount:     defaultJobMaxCount,
+		maxPending:   defaultJobMaxPending,
+		queueTimeout: defaultJobQueueTimeout,
+	}
+	if cfg != nil && cfg.TTL > 0 {
+		q.ttl = time.Duration(cfg.TTL) * time.Second
+	}
+	if cfg != nil && cfg.MaxCount > 0 {
+		q.maxCount = cfg.MaxCount
+	}
+	if cfg != nil && cfg.MaxPending > 0 {
+		q.maxPending = cfg.MaxPending
+	}
+	if cfg != nil && cfg.QueueTimeout > 0 {
+		q.queueTimeout = time.Duration(cfg.QueueTimeout) * time.Second
+	}
+	return q
+}
+
+// Submit queues the request for execution and returns the job.
+// The request must already be validated by Validate().
+func (q *JobQueue) Submit(in engine.Request) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.purge()
+	if len(q.jobs) >= q.maxCount {
+		return Job{}, ErrTooManyJobs
+	}
+	owner := jobOwner(in)
+	if q.pending(owner) >= q.maxPending {
+		return Job{}, ErrTooManyJobs
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	entry := &jobEntry{
+		job:    Job{ID: in.ID, Status: JobQueued, Key: in.Key},
+		owner:  owner,
+		cancel: cancel,
+	}
+	q.jobs[in.ID] = entry
+	go q.run(ctx, entry, in)
+	return entry.job, nil
+}
+
+// Get returns the job with the given ID.
+func (q *JobQueue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.purge()
+	entry, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrUnknownJob
+	}
+	return entry.job, nil
+}
+
+// Cancel stops the job if it is queued or running,
+// and returns it. Does nothing with finished jobs.
+func (q *JobQueue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrUnknownJob
+	}
+	if entry.job.Status == JobQueued || entry.job.Status == JobRunning {
+		entry.job.Status = JobCanceled
+		entry.cancel()
+	}
+	return entry.job, nil
+}
+
+// run waits for a free worker and executes the job.
+// The job waits outside of the request queue, since the client
+// does not wait for it, but for no longer than the queue timeout.
+func (q *JobQueue) run(ctx context.Context, entry *jobEntry, in engine.Request) {
+	out := execWith(ctx, in, q.queueTimeout, func(ctx context.Context) engine.Execution {
+		q.mu.Lock()
+		if entry.job.Status == JobQueued {
+			entry.job.Status = JobRunning
+		}
+		q.mu.Unlock()
+		return engines[in.Sandbox][in.Command].ExecStream(ctx, in, nil)
+	})
+	q.finish(entry, out)
+}
+
+// finish stores the job result.
+func (q *JobQueue) finish(entry *jobEntry, out engine.Execution) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if entry.job.Status != JobCanceled {
+		entry.job.Status = JobDone
+	}
+	entry.job.Result = &out
+	entry.finished = time.Now()
+	entry.cancel()
+}
+
+// pending returns the number of the owner's queued or running jobs.
+// The caller must hold the lock.
+func (q *JobQueue) pending(owner string) int {
+	n := 0
+	for _, entry := range q.jobs {
+		if entry.owner == owner && entry.finished.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// purge removes the finished jobs that have expired.
+// The caller must hold the lock.
+func (q *JobQueue) purge() {
+	now := time.Now()
+	for id, entry := range q.jobs {
+		if !entry.finished.IsZero() && now.Sub(entry.finished) > q.ttl {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// jobOwner returns the client that submitted the job:
+// the API key (if any) or the client IP address.
+func jobOwner(in engine.Request) string {
+	if in.Key != "" {
+		return "key:" + in.Key
+	}
+	return "ip:" + in.Client
+}
+
+// SubmitJob queues the request for asynchronous execution.
+// The request must already be validated by Validate().
+func SubmitJob(in engine.Request) (Job, error) {
+	return jobs.Submit(in)
+}
+
+// GetJob returns the asynchronous job with the given ID.
+func GetJob(id string) (Job, error) {
+	return jobs.Get(id)
+}
+
+// CancelJob stops the asynchronous job with the given ID.
+func CancelJob(id string) (Job, error) {
+	return jobs.Cancel(id)
+}
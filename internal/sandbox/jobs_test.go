@@ -0,0 +1,162 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+)
+
+func TestJobQueue(t *testing.T) {
+	_ = ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	newReq := func() engine.Request {
+		req := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		req.GenerateID()
+		return req
+	}
+
+	t.Run("submit", func(t *testing.T) {
+		q := NewJobQueue(nil)
+		req := newReq()
+		req.Key = "alice"
+		job, err := q.Submit(req)
+		be.Err(t, err, nil)
+		be.Equal(t, job.ID, req.ID)
+		be.Equal(t, job.Status, JobQueued)
+		be.Equal(t, job.Key, "alice")
+
+		job = waitJob(t, q, job.ID)
+		be.Equal(t, job.Status, JobDone)
+		be.True(t, job.Result.OK)
+		be.Equal(t, job.Result.Stdout, "hello")
+	})
+	t.Run("unknown", func(t *testing.T) {
+		q := NewJobQueue(nil)
+		_, err := q.Get("python_run_42")
+		be.Err(t, err, ErrUnknownJob)
+		_, err = q.Cancel("python_run_42")
+		be.Err(t, err, ErrUnknownJob)
+	})
+	t.Run("cancel queued", func(t *testing.T) {
+		// occupy all workers, so the job stays in the queue
+		_ = ApplyConfig(cfg)
+		for i := 0; i < cfg.PoolSize; i++ {
+			_ = semaphore.Acquire()
+		}
+		defer func() { _ = ApplyConfig(cfg) }()
+
+		q := NewJobQueue(nil)
+		job, _ := q.Submit(newReq())
+		job, err := q.Cancel(job.ID)
+		be.Err(t, err, nil)
+		be.Equal(t, job.Status, JobCanceled)
+
+		job = waitJob(t, q, job.ID)
+		be.Equal(t, job.Status, JobCanceled)
+		be.Equal(t, job.Result.OK, false)
+		be.Equal(t, job.Result.Stderr, engine.ErrCanceled.Error())
+	})
+	t.Run("cancel finished", func(t *testing.T) {
+		q := NewJobQueue(nil)
+		job, _ := q.Submit(newReq())
+		waitJob(t, q, job.ID)
+		job, err := q.Cancel(job.ID)
+		be.Err(t, err, nil)
+		be.Equal(t, job.Status, JobDone)
+	})
+	t.Run("expire", func(t *testing.T) {
+		q := NewJobQueue(&config.Jobs{TTL: 1})
+		job, _ := q.Submit(newReq())
+		waitJob(t, q, job.ID)
+		q.ttl = time.Millisecond
+		time.Sleep(5 * time.Millisecond)
+		_, err := q.Get(job.ID)
+		be.Err(t, err, ErrUnknownJob)
+	})
+	t.Run("too many", func(t *testing.T) {
+		q := NewJobQueue(&config.Jobs{MaxCount: 1})
+		job, err := q.Submit(newReq())
+		be.Err(t, err, nil)
+		_, err = q.Submit(newReq())
+		be.Err(t, err, ErrTooManyJobs)
+		waitJob(t, q, job.ID)
+	})
+	t.Run("too many pending", func(t *testing.T) {
+		// occupy all workers, so the jobs stay in the queue
+		_ = ApplyConfig(cfg)
+		for i := 0; i < cfg.PoolSize; i++ {
+			_ = semaphore.Acquire()
+		}
+		defer func() { _ = ApplyConfig(cfg) }()
+
+		q := NewJobQueue(&config.Jobs{MaxPending: 1})
+		alice := newReq()
+		alice.Key = "alice"
+		job, err := q.Submit(alice)
+		be.Err(t, err, nil)
+		alice = newReq()
+		alice.Key = "alice"
+		_, err = q.Submit(alice)
+		be.Err(t, err, ErrTooManyJobs)
+
+		// other clients are not affected
+		bob := newReq()
+		bob.Key = "bob"
+		bobJob, err := q.Submit(bob)
+		be.Err(t, err, nil)
+
+		// finished jobs do not count
+		_, _ = q.Cancel(job.ID)
+		waitJob(t, q, job.ID)
+		job, err = q.Submit(alice)
+		be.Err(t, err, nil)
+
+		for _, id := range []string{job.ID, bobJob.ID} {
+			_, _ = q.Cancel(id)
+			waitJob(t, q, id)
+		}
+	})
+	t.Run("queue timeout", func(t *testing.T) {
+		// occupy all workers, so the job stays in the queue
+		_ = ApplyConfig(cfg)
+		for i := 0; i < cfg.PoolSize; i++ {
+			_ = semaphore.Acquire()
+		}
+		defer func() { _ = ApplyConfig(cfg) }()
+
+		q := NewJobQueue(nil)
+		q.queueTimeout = 10 * time.Millisecond
+		job, _ := q.Submit(newReq())
+		job = waitJob(t, q, job.ID)
+		be.Equal(t, job.Status, JobDone)
+		be.Equal(t, job.Result.OK, false)
+		be.Equal(t, job.Result.Reason, engine.ReasonBusy)
+	})
+}
+
+// waitJob waits for the job to finish and returns it.
+func waitJob(t *testing.T, q *JobQueue, id string) Job {
+	t.Helper()
+	for range 100 {
+		job, err := q.Get(id)
+		be.Err(t, err, nil)
+		if job.Result != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
@@ -0,0 +1,142 @@
+// Request size limits.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/fileio"
+)
+
+var ErrTooLarge = errors.New("request too large")
+
+// Default request size limits.
+const (
+	defaultNBody  = 4 * 1024 * 1024
+	defaultNFiles = 100
+	defaultNFile  = 1024 * 1024
+	defaultNTotal = 2 * 1024 * 1024
+	defaultNName  = 255
+	defaultNDepth = 8
+)
+
+// sandbox : command : limits
+var commandLimits = map[string]map[string]config.Limits{}
+
+// maxBody is the maximum request body size allowed by any command.
+var maxBody = defaultNBody
+
+// A LimitError describes a request that exceeds a configured limit.
+// Size limits (in bytes) match ErrTooLarge.
+type LimitError struct {
+	Limit string
+	Max   int
+	Unit  string
+}
+
+func (err LimitError) Error() string {
+	if err.Unit == "" {
+		return fmt.Sprintf("exceeds %s limit of %d", err.Limit, err.Max)
+	}
+	return fmt.Sprintf("exceeds %s limit of %d %s", err.Limit, err.Max, err.Unit)
+}
+
+func (err LimitError) Is(target error) bool {
+	return target == ErrTooLarge && err.Unit == "bytes"
+}
+
+// MaxBody returns the maximum request body size allowed by any command.
+func MaxBody() int {
+	return maxBody
+}
+
+// ValidateBody checks if the request body size
+// is within the limit of the request command.
+func ValidateBody(in engine.Request, size int) error {
+	lim := getLimits(in.Sandbox, in.Command)
+	if size > lim.NBody {
+		return engine.NewArgumentError("body", LimitError{"nbody", lim.NBody, "bytes"})
+	}
+	return nil
+}
+
+// validateFiles checks if the request files are within the command limits.
+func validateFiles(in engine.Request) error {
+	lim := getLimits(in.Sandbox, in.Command)
+	if len(in.Files) > lim.NFiles {
+		return engine.NewArgumentError("files", LimitError{"nfiles", lim.NFiles, ""})
+	}
+	names := make([]string, 0, len(in.Files))
+	for name := range in.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	total := 0
+	for _, name := range names {
+		arg := fmt.Sprintf("files[%s]", name)
+		if len(name) > lim.NName {
+			return engine.NewArgumentError(arg, LimitError{"nname", lim.NName, ""})
+		}
+		if strings.Count(name, "/") > lim.NDepth {
+			return engine.NewArgumentError(arg, LimitError{"ndepth", lim.NDepth, ""})
+		}
+		size := fileio.DecodedSize(in.Files[name])
+		if size > lim.NFile {
+			return engine.NewArgumentError(arg, LimitError{"nfile", lim.NFile, "bytes"})
+		}
+		total += size
+	}
+	if total > lim.NTotal {
+		return engine.NewArgumentError("files", LimitError{"ntotal", lim.NTotal, "bytes"})
+	}
+	return nil
+}
+
+// getLimits returns the limits for the sandbox command.
+func getLimits(sandbox, command string) config.Limits {
+	if lim, ok := commandLimits[sandbox][command]; ok {
+		return lim
+	}
+	return mergeLimits(nil, nil)
+}
+
+// mergeLimits returns the command limits, falling back
+// to the global limits, and then to the default ones.
+func mergeLimits(cmd, global *config.Limits) config.Limits {
+	lim := config.Limits{
+		NBody:  defaultNBody,
+		NFiles: defaultNFiles,
+		NFile:  defaultNFile,
+		NTotal: defaultNTotal,
+		NName:  defaultNName,
+		NDepth: defaultNDepth,
+	}
+	for _, src := range []*config.Limits{global, cmd} {
+		if src == nil {
+			continue
+		}
+		if src.NBody > 0 {
+			lim.NBody = src.NBody
+		}
+		if src.NFiles > 0 {
+			lim.NFiles = src.NFiles
+		}
+		if src.NFile > 0 {
+			lim.NFile = src.NFile
+		}
+		if src.NTotal > 0 {
+			lim.NTotal = src.NTotal
+		}
+		if src.NName > 0 {
+			lim.NName = src.NName
+		}
+		if src.NDepth > 0 {
+			lim.NDepth = src.NDepth
+		}
+	}
+	return lim
+}
@@ -0,0 +1,97 @@
+package sandbox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+func TestValidateFiles(t *testing.T) {
+	limCfg := *cfg
+	limCfg.Limits = &config.Limits{NFiles: 3, NFile: 10, NTotal: 15, NName: 12, NDepth: 1}
+	_ = ApplyConfig(&limCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	req := func(files map[string]string) engine.Request {
+		return engine.Request{Sandbox: "python", Command: "run", Files: files}
+	}
+	t.Run("valid", func(t *testing.T) {
+		err := Validate(req(map[string]string{"": "print(42)", "lib/util.py": "x = 1"}))
+		be.Err(t, err, nil)
+	})
+	t.Run("nfiles", func(t *testing.T) {
+		err := Validate(req(map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}))
+		be.Equal(t, err.Error(), "files: exceeds nfiles limit of 3")
+		be.True(t, !errors.Is(err, ErrTooLarge))
+	})
+	t.Run("nname", func(t *testing.T) {
+		err := Validate(req(map[string]string{"very_long_name.py": "1"}))
+		be.Equal(t, err.Error(), "files[very_long_name.py]: exceeds nname limit of 12")
+	})
+	t.Run("ndepth", func(t *testing.T) {
+		err := Validate(req(map[string]string{"a/b/c.py": "1"}))
+		be.Equal(t, err.Error(), "files[a/b/c.py]: exceeds ndepth limit of 1")
+	})
+	t.Run("nfile", func(t *testing.T) {
+		err := Validate(req(map[string]string{"": strings.Repeat("x", 11)}))
+		be.Equal(t, err.Error(), "files[]: exceeds nfile limit of 10 bytes")
+		be.True(t, errors.Is(err, ErrTooLarge))
+	})
+	t.Run("nfile data url", func(t *testing.T) {
+		// 12 base64 characters decode to 9 bytes
+		err := Validate(req(map[string]string{"": "data:application/octet-stream;base64,MTIzNDU2Nzg5"}))
+		be.Err(t, err, nil)
+	})
+	t.Run("ntotal", func(t *testing.T) {
+		err := Validate(req(map[string]string{"": "print(42)", "b.py": "x = 4242"}))
+		be.Equal(t, err.Error(), "files: exceeds ntotal limit of 15 bytes")
+		be.True(t, errors.Is(err, ErrTooLarge))
+	})
+}
+
+func TestValidateBody(t *testing.T) {
+	limCfg := *cfg
+	limCfg.Limits = &config.Limits{NBody: 100}
+	limCfg.Commands = map[string]config.SandboxCommands{
+		"python": {
+			"run":  {Engine: "docker", Limits: &config.Limits{NBody: 200}},
+			"test": {Engine: "docker"},
+		},
+	}
+	_ = ApplyConfig(&limCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	be.Equal(t, MaxBody(), 200)
+	run := engine.Request{Sandbox: "python", Command: "run"}
+	be.Err(t, ValidateBody(run, 200), nil)
+	err := ValidateBody(run, 201)
+	be.Equal(t, err.Error(), "body: exceeds nbody limit of 200 bytes")
+	be.True(t, errors.Is(err, ErrTooLarge))
+
+	test := engine.Request{Sandbox: "python", Command: "test"}
+	be.Err(t, ValidateBody(test, 100), nil)
+	be.Err(t, ValidateBody(test, 101), ErrTooLarge)
+}
+
+func Test_mergeLimits(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		lim := mergeLimits(nil, nil)
+		be.Equal(t, lim, config.Limits{
+			NBody: defaultNBody, NFiles: defaultNFiles, NFile: defaultNFile,
+			NTotal: defaultNTotal, NName: defaultNName, NDepth: defaultNDepth,
+		})
+	})
+	t.Run("override", func(t *testing.T) {
+		global := &config.Limits{NBody: 100, NFiles: 5}
+		cmd := &config.Limits{NFiles: 3, NDepth: 2}
+		lim := mergeLimits(cmd, global)
+		be.Equal(t, lim.NBody, 100)
+		be.Equal(t, lim.NFiles, 3)
+		be.Equal(t, lim.NDepth, 2)
+		be.Equal(t, lim.NFile, defaultNFile)
+	})
+}
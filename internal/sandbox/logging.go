@@ -0,0 +1,77 @@
+// Execution log and audit records.
+package sandbox
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/nalgeon/codapi/internal/audit"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+// auditLog records the executions (if enabled).
+var auditLog *audit.Log
+
+// logExecution logs a structured execution record.
+// Only applies to the JSON log format, since the text log
+// has its own execution lines written by the server.
+func logExecution(in engine.Request, out engine.Execution) {
+	if !logx.JSON() {
+		return
+	}
+	outcome := Outcome(out)
+	level := slog.LevelInfo
+	if outcome == outcomeInternal {
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("id", out.ID),
+		slog.String("sandbox", in.Sandbox),
+		slog.String("command", in.Command),
+		slog.String("version", in.Version),
+		slog.String("client", in.Client),
+		slog.String("key", in.Key),
+		slog.Int("duration", out.Duration),
+		slog.Int("queued", out.Queued),
+		slog.String("outcome", outcome),
+		slog.Int("exit_code", out.ExitCode),
+		slog.String("reason", out.Reason),
+		slog.Int64("stdout_bytes", out.StdoutBytes),
+		slog.Int64("stderr_bytes", out.StderrBytes),
+	}
+	if out.Err != nil {
+		attrs = append(attrs, slog.String("error", out.Err.Error()))
+	}
+	logx.LogAttrs(level, "execution", attrs...)
+}
+
+// writeAudit writes the execution to the audit log (if enabled).
+func writeAudit(in engine.Request, out engine.Execution) {
+	if auditLog == nil {
+		return
+	}
+	err := auditLog.Write(in, out)
+	if err != nil {
+		logx.Warn("%s: write audit log: %v", in.ID, err)
+	}
+}
+
+// applyAudit opens the audit log according to the configuration
+// (closing the previous one, if any).
+func applyAudit(cfg *config.Audit) error {
+	if auditLog != nil {
+		_ = auditLog.Close()
+		auditLog = nil
+	}
+	if cfg == nil || cfg.Path == "" {
+		return nil
+	}
+	log, err := audit.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	auditLog = log
+	return nil
+}
@@ -0,0 +1,100 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/audit"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+func TestLogExecution(t *testing.T) {
+	_ = ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	mem := logx.Mock()
+	defer func() { _ = logx.SetFormat(logx.FormatText) }()
+
+	t.Run("text", func(t *testing.T) {
+		req := engine.Request{ID: "http_42", Sandbox: "python", Command: "run"}
+		logExecution(req, engine.Execution{ID: "http_42", OK: true})
+		mem.MustNotHave(t, "execution")
+	})
+	t.Run("json", func(t *testing.T) {
+		_ = logx.SetFormat(logx.FormatJSON)
+		mem.Clear()
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files:   map[string]string{"": "print('hello')"},
+			Client:  "1.2.3.4",
+			Key:     "alice",
+		}
+		out := Exec(req)
+		be.True(t, out.OK)
+
+		var rec map[string]any
+		for _, line := range mem.Lines {
+			_ = json.Unmarshal([]byte(line), &rec)
+			if rec["msg"] == "execution" {
+				break
+			}
+		}
+		be.Equal(t, rec["level"], "INFO")
+		be.Equal(t, rec["id"], "http_42")
+		be.Equal(t, rec["sandbox"], "python")
+		be.Equal(t, rec["command"], "run")
+		be.Equal(t, rec["client"], "1.2.3.4")
+		be.Equal(t, rec["key"], "alice")
+		be.Equal(t, rec["outcome"], "ok")
+		be.Equal(t, rec["exit_code"], 0.0)
+		be.Equal(t, rec["stdout_bytes"], 5.0)
+		be.Equal(t, rec["error"], nil)
+	})
+	t.Run("error", func(t *testing.T) {
+		_ = logx.SetFormat(logx.FormatJSON)
+		mem.Clear()
+		req := engine.Request{ID: "http_42", Sandbox: "python", Command: "run"}
+		err := engine.NewExecutionError("failed", errors.New("boom"))
+		logExecution(req, engine.Fail(req.ID, err))
+		mem.MustHave(t, `"level":"ERROR"`, `"msg":"execution"`, `"outcome":"internal"`, `"error":"failed: boom"`)
+	})
+}
+
+func TestWriteAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditCfg := *cfg
+	auditCfg.Audit = &config.Audit{Path: path}
+	err := ApplyConfig(&auditCfg)
+	be.Err(t, err, nil)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	req := engine.Request{
+		ID:      "http_42",
+		Sandbox: "python",
+		Command: "run",
+		Files:   map[string]string{"": "print('hello')"},
+	}
+	out := Exec(req)
+	be.True(t, out.OK)
+
+	data, err := os.ReadFile(path)
+	be.Err(t, err, nil)
+	var entry audit.Entry
+	err = json.Unmarshal(data, &entry)
+	be.Err(t, err, nil)
+	be.Equal(t, entry.Request.ID, "http_42")
+	be.Equal(t, entry.Result.Stdout, "hello")
+}
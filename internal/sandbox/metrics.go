@@ -0,0 +1,154 @@
+// Execution metrics.
+package sandbox
+
+import (
+	"sort"
+
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/metrics"
+)
+
+// Execution outcomes.
+const (
+	outcomeOK        = "ok"
+	outcomeCodeError = "code_error"
+	outcomeTimeout   = "timeout"
+	outcomeBusy      = "busy"
+	outcomeCanceled  = "canceled"
+	outcomeInternal  = "internal"
+)
+
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+var queueBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	executionsTotal = metrics.NewCounter(
+		"codapi_executions_total",
+		"Code executions by outcome.",
+		"sandbox", "command", "outcome",
+	)
+	executionSeconds = metrics.NewHistogram(
+		"codapi_execution_duration_seconds",
+		"Code execution duration (excluding the queue wait).",
+		durationBuckets, "sandbox", "command",
+	)
+	queueSeconds = metrics.NewHistogram(
+		"codapi_queue_wait_seconds",
+		"Time spent waiting for a free worker.",
+		queueBuckets, "sandbox", "command",
+	)
+	outputBytes = metrics.NewCounter(
+		"codapi_output_bytes_total",
+		"Bytes printed by the code, including the truncated ones.",
+		"sandbox", "command", "stream",
+	)
+	_ = metrics.NewGaugeFunc(
+		"codapi_workers_busy",
+		"Busy workers by concurrency limit (global, sandbox or command).",
+		func() []metrics.Sample { return workerSamples((*Semaphore).busy) },
+		"sandbox", "command",
+	)
+	_ = metrics.NewGaugeFunc(
+		"codapi_workers_max",
+		"Maximum workers by concurrency limit (global, sandbox or command).",
+		func() []metrics.Sample { return workerSamples((*Semaphore).Cap) },
+		"sandbox", "command",
+	)
+	_ = metrics.NewGaugeFunc(
+		"codapi_key_workers_busy",
+		"Busy workers by API key limit.",
+		func() []metrics.Sample { return keyWorkerSamples((*Semaphore).busy) },
+		"key",
+	)
+	_ = metrics.NewGaugeFunc(
+		"codapi_key_workers_max",
+		"Maximum workers by API key limit.",
+		func() []metrics.Sample { return keyWorkerSamples((*Semaphore).Cap) },
+		"key",
+	)
+)
+
+// observe records the execution metrics, logs the execution record
+// and writes it to the audit log. If the code was not executed
+// (e.g. the workers were busy), records only the outcome
+// and the queue wait.
+func observe(in engine.Request, out engine.Execution, executed bool) {
+	logExecution(in, out)
+	writeAudit(in, out)
+	executionsTotal.Inc(in.Sandbox, in.Command, Outcome(out))
+	queueSeconds.Observe(float64(out.Queued)/1000, in.Sandbox, in.Command)
+	if !executed {
+		return
+	}
+	executionSeconds.Observe(float64(out.Duration)/1000, in.Sandbox, in.Command)
+	outputBytes.Add(float64(out.StdoutBytes), in.Sandbox, in.Command, "stdout")
+	outputBytes.Add(float64(out.StderrBytes), in.Sandbox, in.Command, "stderr")
+}
+
+// Outcome returns the execution outcome
+// (ok, code_error, timeout, busy, canceled or internal).
+func Outcome(out engine.Execution) string {
+	switch out.Reason {
+	case engine.ReasonTimeout:
+		return outcomeTimeout
+	case engine.ReasonBusy:
+		return outcomeBusy
+	case engine.ReasonCanceled:
+		return outcomeCanceled
+	case engine.ReasonInternal:
+		return outcomeInternal
+	}
+	if out.OK {
+		return outcomeOK
+	}
+	return outcomeCodeError
+}
+
+// workerSamples returns the value for each concurrency limit.
+// The global limit has empty sandbox and command labels,
+// the sandbox limit has an empty command label.
+func workerSamples(value func(*Semaphore) int) []metrics.Sample {
+	if semaphore == nil {
+		return nil
+	}
+	samples := []metrics.Sample{
+		{Values: []string{"", ""}, Value: float64(value(semaphore))},
+	}
+	for _, sandName := range sortedKeys(sandboxSems) {
+		sem := sandboxSems[sandName]
+		samples = append(samples, metrics.Sample{
+			Values: []string{sandName, ""}, Value: float64(value(sem)),
+		})
+	}
+	for _, sandName := range sortedKeys(commandSems) {
+		for _, cmdName := range sortedKeys(commandSems[sandName]) {
+			sem := commandSems[sandName][cmdName]
+			samples = append(samples, metrics.Sample{
+				Values: []string{sandName, cmdName}, Value: float64(value(sem)),
+			})
+		}
+	}
+	return samples
+}
+
+// keyWorkerSamples returns the value for each API key limit.
+func keyWorkerSamples(value func(*Semaphore) int) []metrics.Sample {
+	samples := make([]metrics.Sample, 0, len(keySems))
+	for _, keyName := range sortedKeys(keySems) {
+		sem := keySems[keyName]
+		samples = append(samples, metrics.Sample{
+			Values: []string{keyName}, Value: float64(value(sem)),
+		})
+	}
+	return samples
+}
+
+// sortedKeys returns the map keys in sorted order.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+)
+
+func TestObserve(t *testing.T) {
+	_ = ApplyConfig(cfg)
+	t.Run("exec", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Stdout: "hello"},
+		})
+		okBefore := executionsTotal.Value("python", "run", outcomeOK)
+		countBefore := executionSeconds.Count("python", "run")
+		bytesBefore := outputBytes.Value("python", "run", "stdout")
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		out := Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, executionsTotal.Value("python", "run", outcomeOK), okBefore+1)
+		be.Equal(t, executionSeconds.Count("python", "run"), countBefore+1)
+		be.Equal(t, outputBytes.Value("python", "run", "stdout"), bytesBefore+5)
+	})
+	t.Run("busy", func(t *testing.T) {
+		_ = ApplyConfig(cfg)
+		for i := 0; i < cfg.Commands["python"]["run"].PoolSize; i++ {
+			_ = commandSems["python"]["run"].Acquire()
+		}
+		busyBefore := executionsTotal.Value("python", "run", outcomeBusy)
+		countBefore := executionSeconds.Count("python", "run")
+		queueBefore := queueSeconds.Count("python", "run")
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		out := Exec(req)
+		be.Equal(t, out.Reason, engine.ReasonBusy)
+		be.Equal(t, executionsTotal.Value("python", "run", outcomeBusy), busyBefore+1)
+		be.Equal(t, executionSeconds.Count("python", "run"), countBefore)
+		be.Equal(t, queueSeconds.Count("python", "run"), queueBefore+1)
+		_ = ApplyConfig(cfg)
+	})
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		out  engine.Execution
+		want string
+	}{
+		{engine.Execution{OK: true, Reason: engine.ReasonExit}, outcomeOK},
+		{engine.Execution{OK: true, Reason: engine.ReasonOutputTruncated}, outcomeOK},
+		{engine.Execution{OK: false, Reason: engine.ReasonExit}, outcomeCodeError},
+		{engine.Execution{OK: false, Reason: engine.ReasonOOMKilled}, outcomeCodeError},
+		{engine.Execution{OK: false, Reason: engine.ReasonInvalid}, outcomeCodeError},
+		{engine.Execution{OK: false, Reason: engine.ReasonTimeout}, outcomeTimeout},
+		{engine.Execution{OK: false, Reason: engine.ReasonBusy}, outcomeBusy},
+		{engine.Execution{OK: false, Reason: engine.ReasonCanceled}, outcomeCanceled},
+		{engine.Execution{OK: false, Reason: engine.ReasonInternal}, outcomeInternal},
+	}
+	for _, test := range tests {
+		be.Equal(t, Outcome(test.out), test.want)
+	}
+}
+
+func Test_workerSamples(t *testing.T) {
+	_ = ApplyConfig(cfg)
+	_ = semaphore.Acquire()
+	defer semaphore.Release()
+
+	busy := workerSamples((*Semaphore).busy)
+	be.Equal(t, len(busy), 3)
+	be.Equal(t, busy[0].Values, []string{"", ""})
+	be.Equal(t, busy[0].Value, 1.0)
+	be.Equal(t, busy[1].Values, []string{"python", ""})
+	be.Equal(t, busy[1].Value, 0.0)
+	be.Equal(t, busy[2].Values, []string{"python", "run"})
+
+	max := workerSamples((*Semaphore).Cap)
+	be.Equal(t, max[0].Value, float64(cfg.PoolSize))
+	be.Equal(t, max[1].Value, 4.0)
+	be.Equal(t, max[2].Value, 2.0)
+}
+
+func Test_keyWorkerSamples(t *testing.T) {
+	keyCfg := *cfg
+	keyCfg.Keys = []*config.APIKey{
+		{Name: "bob", Key: "bob-secret", PoolSize: 2},
+		{Name: "alice", Key: "alice-secret", PoolSize: 1},
+		{Name: "carol", Key: "carol-secret"},
+	}
+	_ = ApplyConfig(&keyCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+	_ = keySems["bob"].Acquire()
+
+	busy := keyWorkerSamples((*Semaphore).busy)
+	be.Equal(t, len(busy), 2)
+	be.Equal(t, busy[0].Values, []string{"alice"})
+	be.Equal(t, busy[0].Value, 0.0)
+	be.Equal(t, busy[1].Values, []string{"bob"})
+	be.Equal(t, busy[1].Value, 1.0)
+
+	max := keyWorkerSamples((*Semaphore).Cap)
+	be.Equal(t, max[0].Value, 1.0)
+	be.Equal(t, max[1].Value, 2.0)
+}
@@ -3,10 +3,14 @@
 package sandbox
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/nalgeon/codapi/internal/config"
 	"github.com/nalgeon/codapi/internal/engine"
 )
 
@@ -14,8 +18,32 @@ var ErrUnknownSandbox = errors.New("unknown sandbox")
 var ErrUnknownCommand = errors.New("unknown command")
 var ErrEmptyRequest = errors.New("empty request")
 
+// Default request input limits.
+const (
+	defaultNStdin = 64 * 1024
+	defaultNArgs  = 1024
+)
+
 // Validate checks if the code execution request is valid.
 func Validate(in engine.Request) error {
+	err := ValidateCommand(in)
+	if err != nil {
+		return err
+	}
+	if len(in.Files) < 2 && strings.TrimSpace(in.Files.First()) == "" {
+		return ErrEmptyRequest
+	}
+	err = validateFiles(in)
+	if err != nil {
+		return err
+	}
+	return validateInput(in, commands[in.Sandbox][in.Command])
+}
+
+// ValidateCommand checks if the request sandbox and command exist.
+// Unlike Validate, does not require any code to execute
+// (e.g. for opening a session).
+func ValidateCommand(in engine.Request) error {
 	box, ok := engines[in.Sandbox]
 	if !ok {
 		return ErrUnknownSandbox
@@ -24,24 +52,167 @@ func Validate(in engine.Request) error {
 	if !ok {
 		return ErrUnknownCommand
 	}
-	if len(in.Files) < 2 && strings.TrimSpace(in.Files.First()) == "" {
-		return ErrEmptyRequest
+	return nil
+}
+
+// validateInput checks if the request stdin, arguments and environment
+// variables are allowed by the command.
+func validateInput(in engine.Request, cmd *config.Command) error {
+	input := &config.Input{}
+	if cmd != nil && cmd.Input != nil {
+		input = cmd.Input
+	}
+
+	if in.Stdin != "" {
+		if !input.Stdin {
+			return engine.NewArgumentError("stdin", errors.New("not allowed"))
+		}
+		nStdin := input.NStdin
+		if nStdin == 0 {
+			nStdin = defaultNStdin
+		}
+		if len(in.Stdin) > nStdin {
+			return engine.NewArgumentError("stdin", fmt.Errorf("exceeds %d bytes", nStdin))
+		}
+	}
+
+	if len(in.Args) > 0 {
+		if !input.Args {
+			return engine.NewArgumentError("args", errors.New("not allowed"))
+		}
+		nArgs := input.NArgs
+		if nArgs == 0 {
+			nArgs = defaultNArgs
+		}
+		size := 0
+		for i, arg := range in.Args {
+			if strings.ContainsRune(arg, 0) {
+				return engine.NewArgumentError(fmt.Sprintf("args[%d]", i), errors.New("invalid value"))
+			}
+			size += len(arg)
+		}
+		if size > nArgs {
+			return engine.NewArgumentError("args", fmt.Errorf("exceeds %d bytes", nArgs))
+		}
+	}
+
+	for name, value := range in.Env {
+		if !slices.Contains(input.Env, name) {
+			return engine.NewArgumentError(fmt.Sprintf("env[%s]", name), errors.New("not allowed"))
+		}
+		if strings.ContainsRune(value, 0) {
+			return engine.NewArgumentError(fmt.Sprintf("env[%s]", name), errors.New("invalid value"))
+		}
 	}
 	return nil
 }
 
 // Exec executes the code using the appropriate sandbox.
-// Allows no more than pool.Size() concurrent workers at any given time.
+// Allows no more than pool.Size() concurrent workers at any given time,
+// and respects the sandbox and command limits (if configured).
+// If all workers are busy, waits in the queue (if configured) for a free one.
 // The request must already be validated by Validate().
 func Exec(in engine.Request) engine.Execution {
-	err := semaphore.Acquire()
-	defer semaphore.Release()
-	if err == ErrBusy {
-		return engine.Fail(in.ID, engine.ErrBusy)
-	}
+	return ExecStream(context.Background(), in, nil)
+}
+
+// ExecStream executes the code using the appropriate sandbox,
+// writing the output to the stream as it is produced.
+// Stops the execution if the context is canceled.
+// Has the same concurrency limits as Exec.
+// The request must already be validated by Validate().
+func ExecStream(ctx context.Context, in engine.Request, stream *engine.Stream) engine.Execution {
+	return execWith(ctx, in, 0, func(ctx context.Context) engine.Execution {
+		return engines[in.Sandbox][in.Command].ExecStream(ctx, in, stream)
+	})
+}
+
+// execWith acquires the workers for the request, and executes
+// the function using them. If wait is zero, waits in the queue
+// (if configured), otherwise waits outside of the queue for no longer
+// than wait (see acquire). Measures the time spent waiting and
+// the execution duration, and records the execution metrics.
+func execWith(ctx context.Context, in engine.Request, wait time.Duration, fn func(context.Context) engine.Execution) engine.Execution {
 	start := time.Now()
-	engine := engines[in.Sandbox][in.Command]
-	out := engine.Exec(in)
+	release, err := acquire(ctx, in, wait)
+	queued := int(time.Since(start).Milliseconds())
+	if errors.Is(err, engine.ErrBusy) {
+		out := engine.Fail(in.ID, err)
+		out.Queued = queued
+		observe(in, out, false)
+		return out
+	}
+	if err != nil {
+		out := engine.Fail(in.ID, engine.ErrCanceled)
+		out.Queued = queued
+		observe(in, out, false)
+		return out
+	}
+	defer release()
+	start = time.Now()
+	out := fn(ctx)
 	out.Duration = int(time.Since(start).Milliseconds())
+	out.Queued = queued
+	observe(in, out, true)
 	return out
 }
+
+// A limit is a named concurrency limit.
+type limit struct {
+	name string
+	sem  *Semaphore
+}
+
+// limits returns the concurrency limits that apply
+// to the request, from the narrowest to the widest.
+func limits(in engine.Request) []limit {
+	var lims []limit
+	if sem := keySems[in.Key]; sem != nil {
+		lims = append(lims, limit{"key " + in.Key, sem})
+	}
+	if sem := commandSems[in.Sandbox][in.Command]; sem != nil {
+		lims = append(lims, limit{"command " + in.Sandbox + "." + in.Command, sem})
+	}
+	if sem := sandboxSems[in.Sandbox]; sem != nil {
+		lims = append(lims, limit{"sandbox " + in.Sandbox, sem})
+	}
+	lims = append(lims, limit{"global", semaphore})
+	return lims
+}
+
+// acquire acquires a token from each limit that applies to the request.
+// If wait is zero, waits in the limit queues (if configured) for no longer
+// than the queue timeout in total, otherwise waits for the tokens
+// outside of the queues for no longer than wait in total.
+// Returns a function that releases the acquired tokens.
+// If any of the limits is reached, returns an engine.BusyError
+// naming the limit.
+func acquire(ctx context.Context, in engine.Request, wait time.Duration) (release func(), err error) {
+	lims := limits(in)
+	start := time.Now()
+	for i, lim := range lims {
+		// a single deadline for all the limits,
+		// so that the request does not wait for each one
+		// for the whole timeout
+		if wait == 0 {
+			err = lim.sem.EnqueueUntil(ctx, start.Add(lim.sem.timeout))
+		} else {
+			err = lim.sem.WaitUntil(ctx, start.Add(wait))
+		}
+		if err != nil {
+			releaseAll(lims[:i])
+			if err == ErrBusy {
+				err = engine.NewBusyError(lim.name)
+			}
+			return nil, err
+		}
+	}
+	return func() { releaseAll(lims) }, nil
+}
+
+// releaseAll releases a token to each limit.
+func releaseAll(lims []limit) {
+	for _, lim := range lims {
+		lim.sem.Release()
+	}
+}
@@ -1,9 +1,14 @@
 package sandbox
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
 	"github.com/nalgeon/codapi/internal/engine"
 	"github.com/nalgeon/codapi/internal/execy"
 )
@@ -52,6 +57,71 @@ func TestValidate(t *testing.T) {
 		err := Validate(req)
 		be.Err(t, err, ErrEmptyRequest)
 	})
+	t.Run("input", func(t *testing.T) {
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files:   map[string]string{"": "print(input())"},
+			Stdin:   "alice",
+			Args:    []string{"--n", "42"},
+			Env:     map[string]string{"DEBUG": "1"},
+		}
+		err := Validate(req)
+		be.Err(t, err, nil)
+	})
+	t.Run("input not allowed", func(t *testing.T) {
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "test",
+			Files:   map[string]string{"": "print(input())"},
+		}
+		for _, in := range []engine.Request{
+			{Stdin: "alice"},
+			{Args: []string{"42"}},
+			{Env: map[string]string{"DEBUG": "1"}},
+		} {
+			req.Stdin, req.Args, req.Env = in.Stdin, in.Args, in.Env
+			err := Validate(req)
+			be.Err(t, err, "not allowed")
+			var argErr engine.ArgumentError
+			be.True(t, errors.As(err, &argErr))
+		}
+	})
+	t.Run("input invalid", func(t *testing.T) {
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files:   map[string]string{"": "print(input())"},
+		}
+		tests := []struct {
+			in  engine.Request
+			err string
+		}{
+			{engine.Request{Stdin: "alice and bob"}, "stdin: exceeds 10 bytes"},
+			{engine.Request{Args: []string{"hello", "world!"}}, "args: exceeds 10 bytes"},
+			{engine.Request{Args: []string{"a\x00b"}}, "args[0]: invalid value"},
+			{engine.Request{Env: map[string]string{"PATH": "/tmp"}}, "env[PATH]: not allowed"},
+			{engine.Request{Env: map[string]string{"DEBUG": "\x00"}}, "env[DEBUG]: invalid value"},
+		}
+		for _, test := range tests {
+			req.Stdin, req.Args, req.Env = test.in.Stdin, test.in.Args, test.in.Env
+			err := Validate(req)
+			be.Err(t, err, test.err)
+		}
+	})
+}
+
+func TestValidateCommand(t *testing.T) {
+	_ = ApplyConfig(cfg)
+	err := ValidateCommand(engine.Request{Sandbox: "python", Command: "run"})
+	be.Err(t, err, nil)
+	err = ValidateCommand(engine.Request{Sandbox: "rust", Command: "run"})
+	be.Err(t, err, ErrUnknownSandbox)
+	err = ValidateCommand(engine.Request{Sandbox: "python", Command: "deploy"})
+	be.Err(t, err, ErrUnknownCommand)
 }
 
 func TestExec(t *testing.T) {
@@ -75,6 +145,25 @@ func TestExec(t *testing.T) {
 		be.Equal(t, out.Stderr, "")
 		be.Equal(t, out.Err, nil)
 	})
+	t.Run("stream", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Stdout: "hello"},
+		})
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		var stdout, stderr strings.Builder
+		out := ExecStream(context.Background(), req, &engine.Stream{Stdout: &stdout, Stderr: &stderr})
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello")
+		be.Equal(t, stdout.String(), "hello")
+		be.Equal(t, stderr.String(), "")
+	})
 	t.Run("busy", func(t *testing.T) {
 		for i := 0; i < cfg.PoolSize; i++ {
 			_ = semaphore.Acquire()
@@ -90,4 +179,119 @@ func TestExec(t *testing.T) {
 		out := Exec(req)
 		be.Err(t, out.Err, engine.ErrBusy)
 	})
+	t.Run("sandbox limit", func(t *testing.T) {
+		_ = ApplyConfig(cfg)
+		defer func() { _ = ApplyConfig(cfg) }()
+		for i := 0; i < cfg.Sandboxes["python"].PoolSize; i++ {
+			_ = sandboxSems["python"].Acquire()
+		}
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		out := Exec(req)
+		be.Err(t, out.Err, engine.ErrBusy)
+		be.Equal(t, out.Stderr, "busy (sandbox python limit reached): try again later")
+		// the command token is released
+		be.Err(t, commandSems["python"]["run"].Acquire(), nil)
+		be.Err(t, commandSems["python"]["run"].Acquire(), nil)
+	})
+	t.Run("command limit", func(t *testing.T) {
+		_ = ApplyConfig(cfg)
+		defer func() { _ = ApplyConfig(cfg) }()
+		for i := 0; i < cfg.Commands["python"]["run"].PoolSize; i++ {
+			_ = commandSems["python"]["run"].Acquire()
+		}
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		out := Exec(req)
+		be.Err(t, out.Err, engine.ErrBusy)
+		be.Equal(t, out.Stderr, "busy (command python.run limit reached): try again later")
+	})
+	t.Run("key limit", func(t *testing.T) {
+		keyCfg := *cfg
+		keyCfg.Keys = []*config.APIKey{{Name: "alice", Key: "secret", PoolSize: 1}}
+		_ = ApplyConfig(&keyCfg)
+		defer func() { _ = ApplyConfig(cfg) }()
+		_ = keySems["alice"].Acquire()
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+			Key: "alice",
+		}
+		out := Exec(req)
+		be.Err(t, out.Err, engine.ErrBusy)
+		be.Equal(t, out.Stderr, "busy (key alice limit reached): try again later")
+	})
+	t.Run("queued", func(t *testing.T) {
+		execy.Mock(map[string]execy.CmdOut{
+			"docker run": {Stdout: "hello"},
+		})
+		sem := NewSemaphore(1).WithQueue(1, time.Second)
+		semaphore = sem
+		defer func() { _ = ApplyConfig(cfg) }()
+		_ = sem.Acquire()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			sem.Release()
+		}()
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		out := Exec(req)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello")
+		be.True(t, out.Queued >= 50)
+	})
+	t.Run("queue deadline", func(t *testing.T) {
+		queueCfg := *cfg
+		queueCfg.QueueSize = 1
+		queueCfg.QueueTimeout = 1
+		_ = ApplyConfig(&queueCfg)
+		defer func() { _ = ApplyConfig(cfg) }()
+		// the command limit frees up after most of the timeout,
+		// while the global one stays busy
+		cmdSem := commandSems["python"]["run"]
+		for cmdSem.Acquire() == nil {
+		}
+		for semaphore.Acquire() == nil {
+		}
+		go func() {
+			time.Sleep(800 * time.Millisecond)
+			cmdSem.Release()
+		}()
+		req := engine.Request{
+			ID:      "http_42",
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		out := Exec(req)
+		be.Err(t, out.Err, engine.ErrBusy)
+		be.Equal(t, out.Stderr, "busy (global limit reached): try again later")
+		// waits for the queue timeout in total, not for each limit
+		be.True(t, out.Queued >= 1000)
+		be.True(t, out.Queued < 1500)
+	})
 }
@@ -1,13 +1,21 @@
 package sandbox
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 var ErrBusy = errors.New("busy")
 
 // A Semaphore manages a limited number of tokens
 // that can be acquired or released.
+// Optionally, it has a bounded wait queue for those
+// who want to wait for a token to become available.
 type Semaphore struct {
-	tokens chan struct{}
+	tokens  chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
 }
 
 // NewSemaphore creates a new semaphore of the specified size.
@@ -16,7 +24,15 @@ func NewSemaphore(size int) *Semaphore {
 	for i := 0; i < size; i++ {
 		tokens <- struct{}{}
 	}
-	return &Semaphore{tokens}
+	return &Semaphore{tokens: tokens, queue: make(chan struct{})}
+}
+
+// WithQueue sets the wait queue size and the maximum time
+// to wait in the queue for a token. A zero size disables the queue.
+func (q *Semaphore) WithQueue(size int, timeout time.Duration) *Semaphore {
+	q.queue = make(chan struct{}, size)
+	q.timeout = timeout
+	return q
 }
 
 // Acquire acquires a token. Returns ErrBusy if no tokens are available.
@@ -29,6 +45,72 @@ func (q *Semaphore) Acquire() error {
 	}
 }
 
+// Enqueue acquires a token, waiting in the queue if none are available.
+// Waiters are served in FIFO order. Returns ErrBusy if the queue is full
+// or if the token is not acquired within the queue timeout.
+// Returns the context error if the context is done before that.
+func (q *Semaphore) Enqueue(ctx context.Context) error {
+	return q.EnqueueUntil(ctx, time.Now().Add(q.timeout))
+}
+
+// EnqueueUntil works like Enqueue, but waits in the queue
+// until the deadline instead of the queue timeout.
+func (q *Semaphore) EnqueueUntil(ctx context.Context, deadline time.Time) error {
+	// try to acquire a token without waiting
+	select {
+	case <-q.tokens:
+		return nil
+	default:
+	}
+
+	// take a place in the queue
+	select {
+	case q.queue <- struct{}{}:
+		defer func() { <-q.queue }()
+	default:
+		return ErrBusy
+	}
+
+	// wait for a token
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-q.tokens:
+		return nil
+	case <-timer.C:
+		return ErrBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait acquires a token, waiting for it to become available.
+// Does not use the queue, so it is not limited by its size or timeout.
+// Returns the context error if the context is done before that.
+func (q *Semaphore) Wait(ctx context.Context) error {
+	select {
+	case <-q.tokens:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitUntil works like Wait, but returns ErrBusy
+// if the token is not acquired before the deadline.
+func (q *Semaphore) WaitUntil(ctx context.Context, deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-q.tokens:
+		return nil
+	case <-timer.C:
+		return ErrBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Release releases a token.
 func (q *Semaphore) Release() {
 	select {
@@ -43,3 +125,14 @@ func (q *Semaphore) Release() {
 func (q *Semaphore) Size() int {
 	return len(q.tokens)
 }
+
+// Cap returns the total number of tokens,
+// both available and acquired.
+func (q *Semaphore) Cap() int {
+	return cap(q.tokens)
+}
+
+// busy returns the number of acquired tokens.
+func (q *Semaphore) busy() int {
+	return cap(q.tokens) - len(q.tokens)
+}
@@ -1,7 +1,9 @@
 package sandbox
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/nalgeon/be"
 )
@@ -30,6 +32,83 @@ func TestSemaphore(t *testing.T) {
 		err := sem.Acquire()
 		be.Err(t, err, nil)
 	})
+	t.Run("wait", func(t *testing.T) {
+		sem := NewSemaphore(1)
+		_ = sem.Acquire()
+		go sem.Release()
+		err := sem.Wait(context.Background())
+		be.Err(t, err, nil)
+	})
+	t.Run("wait canceled", func(t *testing.T) {
+		sem := NewSemaphore(1)
+		_ = sem.Acquire()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := sem.Wait(ctx)
+		be.Err(t, err, context.Canceled)
+	})
+	t.Run("wait until", func(t *testing.T) {
+		sem := NewSemaphore(1)
+		_ = sem.Acquire()
+		err := sem.WaitUntil(context.Background(), time.Now().Add(10*time.Millisecond))
+		be.Err(t, err, ErrBusy)
+		go sem.Release()
+		err = sem.WaitUntil(context.Background(), time.Now().Add(time.Second))
+		be.Err(t, err, nil)
+	})
+	t.Run("enqueue", func(t *testing.T) {
+		sem := NewSemaphore(1).WithQueue(1, time.Second)
+		err := sem.Enqueue(context.Background())
+		be.Err(t, err, nil)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			sem.Release()
+		}()
+		err = sem.Enqueue(context.Background())
+		be.Err(t, err, nil)
+	})
+	t.Run("enqueue no queue", func(t *testing.T) {
+		sem := NewSemaphore(1)
+		_ = sem.Acquire()
+		err := sem.Enqueue(context.Background())
+		be.Err(t, err, ErrBusy)
+	})
+	t.Run("enqueue queue full", func(t *testing.T) {
+		sem := NewSemaphore(1).WithQueue(1, time.Second)
+		_ = sem.Acquire()
+		done := make(chan error)
+		go func() { done <- sem.Enqueue(context.Background()) }()
+		for len(sem.queue) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		err := sem.Enqueue(context.Background())
+		be.Err(t, err, ErrBusy)
+		sem.Release()
+		be.Err(t, <-done, nil)
+	})
+	t.Run("enqueue timeout", func(t *testing.T) {
+		sem := NewSemaphore(1).WithQueue(1, 10*time.Millisecond)
+		_ = sem.Acquire()
+		err := sem.Enqueue(context.Background())
+		be.Err(t, err, ErrBusy)
+		be.Equal(t, len(sem.queue), 0)
+	})
+	t.Run("enqueue until", func(t *testing.T) {
+		sem := NewSemaphore(1).WithQueue(1, time.Second)
+		_ = sem.Acquire()
+		start := time.Now()
+		err := sem.EnqueueUntil(context.Background(), start.Add(10*time.Millisecond))
+		be.Err(t, err, ErrBusy)
+		be.True(t, time.Since(start) < time.Second)
+	})
+	t.Run("enqueue canceled", func(t *testing.T) {
+		sem := NewSemaphore(1).WithQueue(1, time.Second)
+		_ = sem.Acquire()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := sem.Enqueue(ctx)
+		be.Err(t, err, context.Canceled)
+	})
 	t.Run("release free", func(t *testing.T) {
 		sem := NewSemaphore(2)
 		sem.Release()
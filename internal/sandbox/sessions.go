@@ -0,0 +1,366 @@
+// Stateful sessions that keep the execution environment alive.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/stringx"
+)
+
+// Default session settings.
+const (
+	defaultSessionIdleTimeout = 5 * time.Minute
+	defaultSessionMaxLifetime = time.Hour
+	defaultSessionMaxCount    = 16
+	// how often expired sessions are closed
+	sessionReapInterval = 10 * time.Second
+)
+
+var ErrUnknownSession = errors.New("unknown session")
+var ErrTooManySessions = errors.New("too many sessions: try again later")
+
+// sessions is the registry of open sessions.
+var sessions *SessionStore
+
+// A Session is an execution environment (e.g. a container)
+// that is kept alive across multiple requests.
+type Session struct {
+	ID        string    `json:"id"`
+	Sandbox   string    `json:"sandbox"`
+	Version   string    `json:"version,omitempty"`
+	Command   string    `json:"command"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Name of the API key that opened the session (if any).
+	Key string `json:"-"`
+}
+
+// A sessionEntry is a session with its internal state.
+type sessionEntry struct {
+	session  Session
+	lastUsed time.Time
+	// held while the session executes a request
+	busy sync.Mutex
+	// canceled when the session is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// A SessionStore opens, tracks and closes sessions.
+// Closes sessions that are idle for too long or exceed
+// the maximum lifetime.
+type SessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*sessionEntry
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	maxCount    int
+	interval    time.Duration
+	reaping     bool
+}
+
+// NewSessionStore creates a new session store according to the configuration.
+func NewSessionStore(cfg *config.Sessions) *SessionStore {
+	s := &SessionStore{
+		sessions:    map[string]*sessionEntry{},
+		idleTimeout: defaultSessionIdleTimeout,
+		maxLifetime: defaultSessionMaxLifetime,
+		maxCount:    defaultSessionMaxCount,
+		interval:    sessionReapInterval,
+	}
+	if cfg != nil && cfg.IdleTimeout > 0 {
+		s.idleTimeout = time.Duration(cfg.IdleTimeout) * time.Second
+	}
+	if cfg != nil && cfg.MaxLifetime > 0 {
+		s.maxLifetime = time.Duration(cfg.MaxLifetime) * time.Second
+	}
+	if cfg != nil && cfg.MaxCount > 0 {
+		s.maxCount = cfg.MaxCount
+	}
+	return s
+}
+
+// Open starts a new session for the sandbox command.
+// Only the sandbox, version and command of the request are used.
+func (s *SessionStore) Open(ctx context.Context, in engine.Request) (Session, error) {
+	eng, err := sessionEngine(in)
+	if err != nil {
+		return Session{}, err
+	}
+	if !eng.SupportsSessions() {
+		return Session{}, engine.ErrNoSessions
+	}
+
+	// reserve a place for the session
+	now := time.Now()
+	id := fmt.Sprintf("%s_session_%s", in.Sandbox, stringx.RandString(8))
+	entry := &sessionEntry{
+		session: Session{
+			ID:        id,
+			Sandbox:   in.Sandbox,
+			Version:   in.Version,
+			Command:   in.Command,
+			CreatedAt: now,
+			Key:       in.Key,
+		},
+		lastUsed: now,
+	}
+	entry.ctx, entry.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	if len(s.sessions) >= s.maxCount {
+		s.mu.Unlock()
+		return Session{}, ErrTooManySessions
+	}
+	s.sessions[id] = entry
+	entry.busy.Lock()
+	s.mu.Unlock()
+	defer entry.busy.Unlock()
+
+	// start the session environment
+	req := in
+	req.ID = id
+	req.Session = id
+	out := execWith(ctx, req, 0, func(ctx context.Context) engine.Execution {
+		return eng.OpenSession(ctx, req)
+	})
+	if !out.OK {
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.mu.Unlock()
+		entry.cancel()
+		if out.Err != nil {
+			return Session{}, out.Err
+		}
+		return Session{}, engine.NewExecutionError("open session", errors.New(out.Stderr))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.lastUsed = time.Now()
+	if !s.reaping {
+		s.reaping = true
+		go s.reap()
+	}
+	return s.info(entry), nil
+}
+
+// Exec executes the command in the session environment.
+// The request sandbox and version are taken from the session.
+// Returns a busy error if the session is executing another request.
+func (s *SessionStore) Exec(ctx context.Context, id string, in engine.Request, stream *engine.Stream) engine.Execution {
+	s.mu.Lock()
+	entry, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		return engine.Fail(in.ID, ErrUnknownSession)
+	}
+	if !entry.busy.TryLock() {
+		return engine.Fail(in.ID, engine.NewBusyError("session "+id))
+	}
+	defer entry.busy.Unlock()
+
+	s.mu.Lock()
+	entry.lastUsed = time.Now()
+	s.mu.Unlock()
+
+	in.Sandbox = entry.session.Sandbox
+	in.Version = entry.session.Version
+	in.Session = id
+	eng, err := sessionEngine(in)
+	if err != nil {
+		return engine.Fail(in.ID, err)
+	}
+
+	// stop the execution if the session is closed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(entry.ctx, cancel)
+	defer stop()
+
+	out := execWith(ctx, in, 0, func(ctx context.Context) engine.Execution {
+		return eng.ExecSession(ctx, id, in, stream)
+	})
+
+	s.mu.Lock()
+	entry.lastUsed = time.Now()
+	s.mu.Unlock()
+	return out
+}
+
+// Get returns the session with the given ID.
+func (s *SessionStore) Get(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.sessions[id]
+	if !ok {
+		return Session{}, ErrUnknownSession
+	}
+	return s.info(entry), nil
+}
+
+// Close stops the session environment and removes the session.
+// Stops the request currently executing in the session (if any).
+// The session is removed even if stopping the environment fails.
+func (s *SessionStore) Close(id string) (Session, error) {
+	s.mu.Lock()
+	entry, ok := s.sessions[id]
+	if ok {
+		delete(s.sessions, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return Session{}, ErrUnknownSession
+	}
+	return entry.session, s.close(entry)
+}
+
+// close stops the session environment.
+func (s *SessionStore) close(entry *sessionEntry) error {
+	entry.cancel()
+	req := engine.Request{
+		ID:      entry.session.ID,
+		Sandbox: entry.session.Sandbox,
+		Version: entry.session.Version,
+		Command: entry.session.Command,
+	}
+	eng, err := sessionEngine(req)
+	if err != nil {
+		return err
+	}
+	out := eng.CloseSession(req)
+	if out.Err != nil {
+		return out.Err
+	}
+	if !out.OK {
+		return engine.NewExecutionError("close session", errors.New(out.Stderr))
+	}
+	return nil
+}
+
+// CloseAll closes all sessions.
+func (s *SessionStore) CloseAll() {
+	s.mu.Lock()
+	entries := s.sessions
+	s.sessions = map[string]*sessionEntry{}
+	s.mu.Unlock()
+	for _, entry := range entries {
+		err := s.close(entry)
+		if err != nil {
+			logx.Log("✗ %s: %s", entry.session.ID, err)
+		}
+	}
+}
+
+// reap periodically closes expired sessions.
+// Stops when there are no sessions left.
+func (s *SessionStore) reap() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var expired []*sessionEntry
+		now := time.Now()
+		s.mu.Lock()
+		for id, entry := range s.sessions {
+			if !now.After(s.expiresAt(entry)) {
+				continue
+			}
+			// do not close the session while it is executing
+			// a request, it will be closed after that
+			if !entry.busy.TryLock() {
+				continue
+			}
+			entry.busy.Unlock()
+			delete(s.sessions, id)
+			expired = append(expired, entry)
+		}
+		s.mu.Unlock()
+
+		for _, entry := range expired {
+			logx.Log("✗ %s: session expired", entry.session.ID)
+			err := s.close(entry)
+			if err != nil {
+				logx.Log("✗ %s: %s", entry.session.ID, err)
+			}
+		}
+
+		s.mu.Lock()
+		done := len(s.sessions) == 0
+		if done {
+			s.reaping = false
+		}
+		s.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// info returns the session with an up-to-date expiration time.
+// The caller must hold the lock.
+func (s *SessionStore) info(entry *sessionEntry) Session {
+	session := entry.session
+	session.ExpiresAt = s.expiresAt(entry)
+	return session
+}
+
+// expiresAt returns the time when the session expires,
+// whichever comes first: idle timeout or maximum lifetime.
+// The caller must hold the lock.
+func (s *SessionStore) expiresAt(entry *sessionEntry) time.Time {
+	idle := entry.lastUsed.Add(s.idleTimeout)
+	life := entry.session.CreatedAt.Add(s.maxLifetime)
+	if idle.Before(life) {
+		return idle
+	}
+	return life
+}
+
+// sessionEngine returns the session engine for the sandbox command.
+func sessionEngine(in engine.Request) (engine.SessionEngine, error) {
+	box, ok := engines[in.Sandbox]
+	if !ok {
+		return nil, ErrUnknownSandbox
+	}
+	eng, ok := box[in.Command]
+	if !ok {
+		return nil, ErrUnknownCommand
+	}
+	sessEng, ok := eng.(engine.SessionEngine)
+	if !ok {
+		return nil, engine.ErrNoSessions
+	}
+	return sessEng, nil
+}
+
+// OpenSession starts a new session for the sandbox command.
+func OpenSession(ctx context.Context, in engine.Request) (Session, error) {
+	return sessions.Open(ctx, in)
+}
+
+// ExecSession executes the command in the session with the given ID.
+// The request command must already be validated by Validate().
+func ExecSession(ctx context.Context, id string, in engine.Request, stream *engine.Stream) engine.Execution {
+	return sessions.Exec(ctx, id, in, stream)
+}
+
+// GetSession returns the session with the given ID.
+func GetSession(id string) (Session, error) {
+	return sessions.Get(id)
+}
+
+// CloseSession closes the session with the given ID.
+func CloseSession(id string) (Session, error) {
+	return sessions.Close(id)
+}
+
+// CloseSessions closes all open sessions.
+func CloseSessions() {
+	sessions.CloseAll()
+}
@@ -0,0 +1,142 @@
+package sandbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/logx"
+)
+
+func TestSessionStore(t *testing.T) {
+	logx.Mock()
+	_ = ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run":  {Stdout: "c958ff2"},
+		"docker exec": {Stdout: "hello"},
+	})
+	open := engine.Request{Sandbox: "alpine", Command: "echo"}
+	req := engine.Request{
+		ID:      "alpine_42",
+		Sandbox: "alpine",
+		Command: "echo",
+		Files: map[string]string{
+			"": "echo hello",
+		},
+	}
+
+	t.Run("lifecycle", func(t *testing.T) {
+		mem := execy.Mock(nil)
+		s := NewSessionStore(&config.Sessions{})
+		sess, err := s.Open(context.Background(), open)
+		be.Err(t, err, nil)
+		be.True(t, sess.ID != "")
+		be.Equal(t, sess.Sandbox, "alpine")
+		be.Equal(t, sess.Command, "echo")
+		be.True(t, sess.ExpiresAt.After(sess.CreatedAt))
+		mem.MustHave(t, "docker run --rm --name "+sess.ID)
+
+		got, err := s.Get(sess.ID)
+		be.Err(t, err, nil)
+		be.Equal(t, got.ID, sess.ID)
+
+		out := s.Exec(context.Background(), sess.ID, req, nil)
+		be.True(t, out.OK)
+		be.Equal(t, out.ID, req.ID)
+		be.Equal(t, out.Stdout, "hello")
+		mem.MustHave(t, "docker exec --interactive --user sandbox "+sess.ID+" sh main.sh")
+
+		closed, err := s.Close(sess.ID)
+		be.Err(t, err, nil)
+		be.Equal(t, closed.ID, sess.ID)
+		mem.MustHave(t, "docker stop "+sess.ID)
+
+		_, err = s.Get(sess.ID)
+		be.Err(t, err, ErrUnknownSession)
+		out = s.Exec(context.Background(), sess.ID, req, nil)
+		be.Equal(t, out.Stderr, ErrUnknownSession.Error())
+	})
+	t.Run("unknown command", func(t *testing.T) {
+		s := NewSessionStore(&config.Sessions{})
+		_, err := s.Open(context.Background(), engine.Request{Sandbox: "alpine", Command: "missing"})
+		be.Err(t, err, ErrUnknownCommand)
+	})
+	t.Run("not supported", func(t *testing.T) {
+		s := NewSessionStore(&config.Sessions{})
+		_, err := s.Open(context.Background(), engine.Request{Sandbox: "python", Command: "run"})
+		be.Err(t, err, engine.ErrNoSessions)
+		_, err = s.Open(context.Background(), engine.Request{Sandbox: "http", Command: "run"})
+		be.Err(t, err, engine.ErrNoSessions)
+	})
+	t.Run("too many", func(t *testing.T) {
+		s := NewSessionStore(&config.Sessions{MaxCount: 1})
+		defer s.CloseAll()
+		_, err := s.Open(context.Background(), open)
+		be.Err(t, err, nil)
+		_, err = s.Open(context.Background(), open)
+		be.Err(t, err, ErrTooManySessions)
+	})
+	t.Run("busy", func(t *testing.T) {
+		s := NewSessionStore(&config.Sessions{})
+		defer s.CloseAll()
+		sess, _ := s.Open(context.Background(), open)
+		entry := s.sessions[sess.ID]
+		entry.busy.Lock()
+		out := s.Exec(context.Background(), sess.ID, req, nil)
+		entry.busy.Unlock()
+		be.Err(t, out.Err, engine.ErrBusy)
+	})
+	t.Run("expire", func(t *testing.T) {
+		mem := execy.Mock(nil)
+		s := NewSessionStore(&config.Sessions{})
+		s.idleTimeout = 10 * time.Millisecond
+		s.interval = time.Millisecond
+		sess, err := s.Open(context.Background(), open)
+		be.Err(t, err, nil)
+		for range 100 {
+			if _, err = s.Get(sess.ID); err != nil {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		be.Err(t, err, ErrUnknownSession)
+		// wait for the reaper to stop
+		for range 100 {
+			s.mu.Lock()
+			reaping := s.reaping
+			s.mu.Unlock()
+			if !reaping {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		mem.MustHave(t, "docker stop "+sess.ID)
+	})
+	t.Run("expire busy", func(t *testing.T) {
+		s := NewSessionStore(&config.Sessions{})
+		defer s.CloseAll()
+		s.idleTimeout = 10 * time.Millisecond
+		s.interval = time.Millisecond
+		sess, err := s.Open(context.Background(), open)
+		be.Err(t, err, nil)
+		// a long-running request keeps the session open
+		s.mu.Lock()
+		entry := s.sessions[sess.ID]
+		s.mu.Unlock()
+		entry.busy.Lock()
+		time.Sleep(50 * time.Millisecond)
+		_, err = s.Get(sess.ID)
+		entry.busy.Unlock()
+		be.Err(t, err, nil)
+	})
+	t.Run("max lifetime", func(t *testing.T) {
+		s := NewSessionStore(&config.Sessions{MaxLifetime: 60})
+		defer s.CloseAll()
+		sess, _ := s.Open(context.Background(), open)
+		be.Equal(t, sess.ExpiresAt, sess.CreatedAt.Add(time.Minute))
+	})
+}
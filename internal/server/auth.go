@@ -0,0 +1,127 @@
+// API key authentication.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+var (
+	ErrUnauthorized = errors.New("missing or invalid API key")
+	ErrForbidden    = errors.New("API key is not allowed to use")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+)
+
+// keyCtx is the request context key for the API key.
+type keyCtx struct{}
+
+// authorize requires a valid API key for a given handler
+// (if there are any keys configured). The key is sent
+// in the Authorization header as a bearer token.
+func authorize(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return authorizeWith(bearerToken, handler)
+}
+
+// authorizeSocket is like authorize, but also accepts the key
+// in the "key" query parameter, since browsers can't set
+// the Authorization header on WebSocket handshakes.
+func authorizeSocket(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return authorizeWith(socketToken, handler)
+}
+
+// authorizeWith requires a valid API key for a given handler
+// (if there are any keys configured), taking the key
+// from the request with the token function.
+func authorizeWith(token func(*http.Request) string, handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) == 0 {
+			handler(w, r)
+			return
+		}
+		key := findKey(token(r))
+		if key == nil {
+			w.Header().Set("www-authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, engine.Fail("-", ErrUnauthorized))
+			return
+		}
+		ctx := context.WithValue(r.Context(), keyCtx{}, key)
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// checkAccess checks if the request's API key is allowed to use
+// the sandbox command, and records the client address
+// and the key name in the request.
+func checkAccess(r *http.Request, in *engine.Request) error {
+	in.Client = clientIP(r)
+	key, ok := r.Context().Value(keyCtx{}).(*apiKey)
+	if !ok {
+		return nil
+	}
+	in.Key = key.Name
+	if !key.allows(in.Sandbox, in.Command) {
+		return fmt.Errorf("%w %s.%s", ErrForbidden, in.Sandbox, in.Command)
+	}
+	return nil
+}
+
+// requestKey returns the name of the request's API key,
+// or an empty string if the request has no key.
+func requestKey(r *http.Request) string {
+	key, ok := r.Context().Value(keyCtx{}).(*apiKey)
+	if !ok {
+		return ""
+	}
+	return key.Name
+}
+
+// allows returns true if the key is allowed to use the sandbox command.
+func (k *apiKey) allows(sandbox, command string) bool {
+	if len(k.Sandboxes) == 0 {
+		return true
+	}
+	return slices.Contains(k.Sandboxes, sandbox) ||
+		slices.Contains(k.Sandboxes, sandbox+"."+command)
+}
+
+// findKey returns the API key with the given value,
+// or nil if there is no such key.
+func findKey(value string) *apiKey {
+	if value == "" {
+		return nil
+	}
+	var found *apiKey
+	for _, key := range apiKeys {
+		// compare all keys in constant time
+		// to avoid leaking timing information
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(value)) == 1 {
+			found = key
+		}
+	}
+	return found
+}
+
+// bearerToken returns the bearer token from the Authorization header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// socketToken returns the bearer token from the Authorization header,
+// or the "key" query parameter if there is no header.
+func socketToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("key")
+}
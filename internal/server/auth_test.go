@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/sandbox"
+	"github.com/nalgeon/codapi/internal/websocket"
+)
+
+func TestAuthorize(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	keyCfg := *cfg
+	keyCfg.Keys = []*config.APIKey{
+		{Name: "alice", Key: "alice-secret"},
+		{Name: "bob", Key: "bob-secret", Sandboxes: []string{"alpine", "python.test"}},
+		{Name: "carol", Key: "carol-secret", RateLimit: &config.RateLimit{Rate: 1}},
+		{Name: "dave", Key: "dave-secret", RateLimit: &config.RateLimit{Rate: 1}},
+	}
+	_ = ApplyConfig(&keyCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	srv := newServer()
+	defer srv.close()
+
+	post := func(key string, in engine.Request) *http.Response {
+		body, _ := json.Marshal(in)
+		req, _ := http.NewRequest("POST", srv.srv.URL+"/v1/exec", bytes.NewReader(body))
+		req.Header.Set("content-type", "application/json")
+		if key != "" {
+			req.Header.Set("authorization", "Bearer "+key)
+		}
+		resp, err := srv.cli.Do(req)
+		be.Err(t, err, nil)
+		return resp
+	}
+	in := engine.Request{
+		Sandbox: "python",
+		Command: "run",
+		Files:   map[string]string{"": "print('hello')"},
+	}
+
+	t.Run("valid key", func(t *testing.T) {
+		resp := post("alice-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		out := decodeResp[engine.Execution](t, resp)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello")
+	})
+	t.Run("missing key", func(t *testing.T) {
+		resp := post("", in)
+		be.Equal(t, resp.StatusCode, http.StatusUnauthorized)
+		be.Equal(t, resp.Header.Get("www-authenticate"), "Bearer")
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Stderr, "missing or invalid API key")
+	})
+	t.Run("invalid key", func(t *testing.T) {
+		resp := post("alice", in)
+		be.Equal(t, resp.StatusCode, http.StatusUnauthorized)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "missing or invalid API key")
+	})
+	t.Run("sandbox not allowed", func(t *testing.T) {
+		resp := post("bob-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusForbidden)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Stderr, "API key is not allowed to use python.run")
+	})
+	t.Run("command allowed", func(t *testing.T) {
+		in := engine.Request{Sandbox: "python", Command: "test"}
+		resp := post("bob-secret", in)
+		// passes the key check, fails the validation
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "empty request")
+	})
+	t.Run("rate limited", func(t *testing.T) {
+		resp := post("carol-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_ = resp.Body.Close()
+		resp = post("carol-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusTooManyRequests)
+		be.Equal(t, resp.Header.Get("retry-after"), "60")
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "rate limit exceeded")
+	})
+	t.Run("rate limit polling", func(t *testing.T) {
+		// only the code executions count against the key rate limit
+		for range 2 {
+			req, _ := http.NewRequest("GET", srv.srv.URL+"/v1/sandboxes", nil)
+			req.Header.Set("authorization", "Bearer dave-secret")
+			resp, err := srv.cli.Do(req)
+			be.Err(t, err, nil)
+			_ = resp.Body.Close()
+			be.Equal(t, resp.StatusCode, http.StatusOK)
+		}
+		resp := post("dave-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_ = resp.Body.Close()
+	})
+	t.Run("socket", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(srv.srv.URL, "http") + "/v1/exec/socket"
+		_, err := websocket.Dial(url)
+		be.Err(t, err, "handshake failed: 401 Unauthorized")
+		conn, err := websocket.Dial(url + "?key=alice-secret")
+		be.Err(t, err, nil)
+		_ = conn.Close()
+	})
+	t.Run("preflight", func(t *testing.T) {
+		req, _ := http.NewRequest("OPTIONS", srv.srv.URL+"/v1/exec", nil)
+		resp, err := srv.cli.Do(req)
+		be.Err(t, err, nil)
+		_ = resp.Body.Close()
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+	})
+	t.Run("no keys", func(t *testing.T) {
+		_ = ApplyConfig(cfg)
+		defer func() { _ = ApplyConfig(&keyCfg) }()
+		resp := post("", in)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_ = resp.Body.Close()
+	})
+}
+
+func TestAuthorize_owner(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run":  {Stdout: "c958ff2"},
+		"docker exec": {Stdout: "hello"},
+	})
+	keyCfg := *cfg
+	keyCfg.Keys = []*config.APIKey{
+		{Name: "alice", Key: "alice-secret"},
+		{Name: "bob", Key: "bob-secret", Sandboxes: []string{"alpine", "python.test"}},
+	}
+	_ = ApplyConfig(&keyCfg)
+	defer func() { _ = ApplyConfig(cfg) }()
+
+	srv := newServer()
+	defer srv.close()
+	defer sandbox.CloseSessions()
+
+	do := func(method, uri, key string, in any) *http.Response {
+		var body bytes.Buffer
+		if in != nil {
+			_ = json.NewEncoder(&body).Encode(in)
+		}
+		req, _ := http.NewRequest(method, srv.srv.URL+uri, &body)
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("authorization", "Bearer "+key)
+		resp, err := srv.cli.Do(req)
+		be.Err(t, err, nil)
+		return resp
+	}
+
+	t.Run("job", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "alpine",
+			Command: "echo",
+			Files:   map[string]string{"": "echo hello"},
+		}
+		resp := do(http.MethodPost, "/v1/jobs", "bob-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusAccepted)
+		job := decodeResp[sandbox.Job](t, resp)
+
+		resp = do(http.MethodGet, "/v1/jobs/"+job.ID, "alice-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "unknown job")
+
+		resp = do(http.MethodDelete, "/v1/jobs/"+job.ID, "alice-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		_ = resp.Body.Close()
+
+		resp = do(http.MethodGet, "/v1/jobs/"+job.ID, "bob-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		got := decodeResp[sandbox.Job](t, resp)
+		be.Equal(t, got.ID, job.ID)
+		be.True(t, got.Status != sandbox.JobCanceled)
+	})
+	t.Run("session", func(t *testing.T) {
+		in := engine.Request{Sandbox: "alpine", Command: "echo"}
+		resp := do(http.MethodPost, "/v1/sessions", "bob-secret", in)
+		be.Equal(t, resp.StatusCode, http.StatusCreated)
+		sess := decodeResp[sandbox.Session](t, resp)
+
+		resp = do(http.MethodGet, "/v1/sessions/"+sess.ID, "alice-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "unknown session")
+
+		exec := engine.Request{Files: map[string]string{"": "echo hello"}}
+		resp = do(http.MethodPost, "/v1/sessions/"+sess.ID+"/exec", "alice-secret", exec)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		_ = resp.Body.Close()
+
+		resp = do(http.MethodDelete, "/v1/sessions/"+sess.ID, "alice-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		_ = resp.Body.Close()
+
+		resp = do(http.MethodPost, "/v1/sessions/"+sess.ID+"/exec", "bob-secret", exec)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		res := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, res.Stdout, "hello")
+
+		resp = do(http.MethodDelete, "/v1/sessions/"+sess.ID, "bob-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_ = resp.Body.Close()
+	})
+	t.Run("sandboxes", func(t *testing.T) {
+		resp := do(http.MethodGet, "/v1/sandboxes", "alice-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		all := decodeResp[[]sandbox.SandboxInfo](t, resp)
+		be.Equal(t, len(all), len(cfg.Commands))
+
+		resp = do(http.MethodGet, "/v1/sandboxes", "bob-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		infos := decodeResp[[]sandbox.SandboxInfo](t, resp)
+		be.Equal(t, len(infos), 2)
+		be.Equal(t, infos[0].Name, "alpine")
+		be.Equal(t, infos[1].Name, "python")
+		be.Equal(t, len(infos[1].Commands), 1)
+		be.Equal(t, infos[1].Commands[0].Name, "test")
+
+		resp = do(http.MethodGet, "/v1/sandboxes/python", "bob-secret", nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		info := decodeResp[sandbox.SandboxInfo](t, resp)
+		be.Equal(t, len(info.Commands), 1)
+	})
+}
+
+func Test_bearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"Bearer secret", "secret"},
+		{"bearer  secret ", "secret"},
+		{"Basic secret", ""},
+		{"secret", ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("authorization", test.header)
+		be.Equal(t, bearerToken(req), test.want)
+	}
+}
+
+func Test_socketToken(t *testing.T) {
+	tests := []struct {
+		header string
+		uri    string
+		want   string
+	}{
+		{"", "/v1/exec/socket", ""},
+		{"", "/v1/exec/socket?key=secret", "secret"},
+		{"Bearer secret", "/v1/exec/socket", "secret"},
+		{"Bearer header", "/v1/exec/socket?key=query", "header"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.uri, nil)
+		req.Header.Set("authorization", test.header)
+		be.Equal(t, socketToken(req), test.want)
+	}
+}
+
+func Test_checkAccess(t *testing.T) {
+	key := &apiKey{APIKey: &config.APIKey{Name: "bob", Sandboxes: []string{"python.run"}}}
+	req, _ := http.NewRequest("POST", "/v1/exec", nil)
+	req.RemoteAddr = "1.2.3.4:1234"
+
+	t.Run("no key", func(t *testing.T) {
+		in := engine.Request{Sandbox: "python", Command: "test"}
+		err := checkAccess(req, &in)
+		be.Err(t, err, nil)
+		be.Equal(t, in.Client, "1.2.3.4")
+		be.Equal(t, in.Key, "")
+	})
+	t.Run("allowed", func(t *testing.T) {
+		r := req.WithContext(context.WithValue(req.Context(), keyCtx{}, key))
+		in := engine.Request{Sandbox: "python", Command: "run"}
+		err := checkAccess(r, &in)
+		be.Err(t, err, nil)
+		be.Equal(t, in.Client, "1.2.3.4")
+		be.Equal(t, in.Key, "bob")
+	})
+	t.Run("forbidden", func(t *testing.T) {
+		r := req.WithContext(context.WithValue(req.Context(), keyCtx{}, key))
+		in := engine.Request{Sandbox: "python", Command: "test"}
+		err := checkAccess(r, &in)
+		be.Err(t, err, ErrForbidden)
+	})
+}
@@ -0,0 +1,83 @@
+// Server configuration.
+package server
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/nalgeon/codapi/internal/config"
+)
+
+// An apiKey is a client API key with its request rate limiter.
+type apiKey struct {
+	*config.APIKey
+	bucket *tokenBucket
+}
+
+// apiKeys are the configured client API keys.
+// The API is open if there are none.
+var apiKeys []*apiKey
+
+// clientLimiter limits the request rate per client (if set).
+var clientLimiter *rateLimiter
+
+// sandbox : per-client rate limiter
+var sandboxLimiters = map[string]*rateLimiter{}
+
+// trustedProxies are the proxy networks
+// allowed to set the X-Forwarded-For header.
+var trustedProxies []netip.Prefix
+
+// ApplyConfig applies the configuration to the server.
+func ApplyConfig(cfg *config.Config) error {
+	proxies := make([]netip.Prefix, 0, len(cfg.TrustedProxies))
+	for _, proxy := range cfg.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return fmt.Errorf("trusted proxy %q: %w", proxy, err)
+		}
+		proxies = append(proxies, prefix)
+	}
+	trustedProxies = proxies
+
+	policy, err := newCORSPolicy(cfg.CORS)
+	if err != nil {
+		return err
+	}
+	cors = policy
+
+	keys := make([]*apiKey, 0, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		k := &apiKey{APIKey: key}
+		if key.RateLimit != nil && key.RateLimit.Rate > 0 {
+			k.bucket = newTokenBucket(*key.RateLimit)
+		}
+		keys = append(keys, k)
+	}
+	apiKeys = keys
+
+	clientLimiter = nil
+	if cfg.RateLimit != nil && cfg.RateLimit.Rate > 0 {
+		clientLimiter = newRateLimiter(*cfg.RateLimit)
+	}
+	sandboxLimiters = map[string]*rateLimiter{}
+	for name, sand := range cfg.Sandboxes {
+		if sand != nil && sand.RateLimit != nil && sand.RateLimit.Rate > 0 {
+			sandboxLimiters[name] = newRateLimiter(*sand.RateLimit)
+		}
+	}
+	return nil
+}
+
+// parsePrefix parses an IP address or a network in CIDR notation.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+)
+
+func TestApplyConfig(t *testing.T) {
+	defer func() { _ = ApplyConfig(cfg) }()
+	t.Run("apply", func(t *testing.T) {
+		err := ApplyConfig(&config.Config{
+			Keys: []*config.APIKey{
+				{Name: "alice", Key: "a"},
+				{Name: "bob", Key: "b", RateLimit: &config.RateLimit{Rate: 60}},
+			},
+			RateLimit:      &config.RateLimit{Rate: 60},
+			TrustedProxies: []string{"10.0.0.1", "10.1.0.0/16"},
+			CORS:           &config.CORS{Origins: []string{"https://example.com"}},
+			Sandboxes: map[string]*config.Sandbox{
+				"python": {RateLimit: &config.RateLimit{Rate: 10}},
+				"go":     {PoolSize: 2},
+			},
+		})
+		be.Err(t, err, nil)
+		be.Equal(t, len(cors.origins), 1)
+		be.Equal(t, len(apiKeys), 2)
+		be.True(t, apiKeys[0].bucket == nil)
+		be.True(t, apiKeys[1].bucket != nil)
+		be.True(t, clientLimiter != nil)
+		be.Equal(t, len(sandboxLimiters), 1)
+		be.True(t, sandboxLimiters["python"] != nil)
+		be.Equal(t, trustedProxies, []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.1/32"),
+			netip.MustParsePrefix("10.1.0.0/16"),
+		})
+	})
+	t.Run("invalid proxy", func(t *testing.T) {
+		err := ApplyConfig(&config.Config{TrustedProxies: []string{"localhost"}})
+		be.True(t, err != nil)
+	})
+	t.Run("invalid cors origin", func(t *testing.T) {
+		err := ApplyConfig(&config.Config{CORS: &config.CORS{Origins: []string{"example.com"}}})
+		be.Err(t, err, "invalid cors origin")
+	})
+}
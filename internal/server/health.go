@@ -0,0 +1,37 @@
+// Health and readiness checks.
+package server
+
+import (
+	"net/http"
+
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+// healthz reports that the server is alive.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	_ = writeJson(w, map[string]bool{"ok": true})
+}
+
+// readyz reports whether the server is ready to execute code.
+// Responds with 503 if not ready. Only reports the overall status,
+// the breakdown per check is served by readyzDetails.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ready := sandbox.Ready()
+	status := map[string]bool{"ok": ready.OK}
+	if !ready.OK {
+		writeJsonStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	_ = writeJson(w, status)
+}
+
+// readyzDetails reports whether the server is ready to execute code,
+// with a breakdown per check. Responds with 503 if not ready.
+func readyzDetails(w http.ResponseWriter, r *http.Request) {
+	ready := sandbox.Ready()
+	if !ready.OK {
+		writeJsonStatus(w, http.StatusServiceUnavailable, ready)
+		return
+	}
+	_ = writeJson(w, ready)
+}
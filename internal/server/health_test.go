@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+func Test_healthz(t *testing.T) {
+	srv := newServer()
+	defer srv.close()
+
+	resp, err := srv.cli.Get(srv.srv.URL + "/healthz")
+	be.Err(t, err, nil)
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	out := decodeResp[map[string]bool](t, resp)
+	be.Equal(t, out["ok"], true)
+
+	resp, err = srv.post("/healthz", nil)
+	be.Err(t, err, nil)
+	be.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+}
+
+func Test_readyz(t *testing.T) {
+	srv := newServer()
+	defer srv.close()
+
+	t.Run("ready", func(t *testing.T) {
+		_ = sandbox.ApplyConfig(cfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: ":latest\n"},
+		})
+		resp, err := srv.cli.Get(srv.srv.URL + "/readyz")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		out := decodeResp[map[string]any](t, resp)
+		be.Equal(t, out, map[string]any{"ok": true})
+	})
+	t.Run("not ready", func(t *testing.T) {
+		_ = sandbox.ApplyConfig(cfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Err: errors.New("exit status 1")},
+		})
+		resp, err := srv.cli.Get(srv.srv.URL + "/readyz")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusServiceUnavailable)
+		out := decodeResp[map[string]any](t, resp)
+		be.Equal(t, out, map[string]any{"ok": false})
+	})
+}
+
+func Test_readyzDetails(t *testing.T) {
+	srv := httptest.NewServer(NewMetrics())
+	defer srv.Close()
+
+	t.Run("ready", func(t *testing.T) {
+		_ = sandbox.ApplyConfig(cfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Stdout: ":latest\n"},
+		})
+		resp, err := srv.Client().Get(srv.URL + "/readyz")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		out := decodeResp[sandbox.Readiness](t, resp)
+		be.True(t, out.OK)
+		be.True(t, out.Docker.OK)
+		be.True(t, out.Workers.OK)
+		be.Equal(t, out.Workers.Max, cfg.PoolSize)
+		be.Equal(t, len(out.Boxes), len(cfg.Boxes))
+	})
+	t.Run("not ready", func(t *testing.T) {
+		_ = sandbox.ApplyConfig(cfg)
+		execy.Mock(map[string]execy.CmdOut{
+			"docker image": {Err: errors.New("exit status 1")},
+		})
+		resp, err := srv.Client().Get(srv.URL + "/readyz")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusServiceUnavailable)
+		out := decodeResp[sandbox.Readiness](t, resp)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Docker.OK, false)
+		be.Equal(t, out.Boxes["python"].OK, false)
+	})
+}
@@ -4,25 +4,44 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"sync"
+
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/sandbox"
 )
 
 // readJson decodes the request body from JSON.
+// Fails if the body is larger than any command allows.
 func readJson[T any](r *http.Request) (T, error) {
+	obj, _, err := readJsonSize[T](r)
+	return obj, err
+}
+
+// readJsonSize decodes the request body from JSON
+// and returns the body size in bytes.
+// Fails if the body is larger than any command allows.
+func readJsonSize[T any](r *http.Request) (T, int, error) {
 	var obj T
 	if r.Header.Get("content-type") != "application/json" {
-		return obj, errors.New(http.StatusText(http.StatusUnsupportedMediaType))
+		return obj, 0, errors.New(http.StatusText(http.StatusUnsupportedMediaType))
 	}
-	data, err := io.ReadAll(r.Body)
+	maxBody := sandbox.MaxBody()
+	data, err := io.ReadAll(io.LimitReader(r.Body, int64(maxBody)+1))
 	if err != nil {
-		return obj, err
+		return obj, len(data), err
+	}
+	if len(data) > maxBody {
+		err = sandbox.LimitError{Limit: "nbody", Max: maxBody, Unit: "bytes"}
+		return obj, len(data), engine.NewArgumentError("body", err)
 	}
 	err = json.Unmarshal(data, &obj)
 	if err != nil {
-		return obj, err
+		return obj, len(data), err
 	}
-	return obj, err
+	return obj, len(data), err
 }
 
 // writeJson encodes an object into JSON and writes it to the response.
@@ -40,10 +59,84 @@ func writeJson(w http.ResponseWriter, obj any) error {
 	return nil
 }
 
-// writeError encodes an error object into JSON and writes it to the response.
-func writeError(w http.ResponseWriter, code int, obj any) {
+// writeJsonStatus encodes an object into JSON and writes it
+// to the response with the given status code.
+func writeJsonStatus(w http.ResponseWriter, code int, obj any) {
 	data, _ := json.Marshal(obj)
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(code)
 	w.Write(data) //nolint:errcheck
 }
+
+// writeError encodes an error object into JSON and writes it to the response.
+func writeError(w http.ResponseWriter, code int, obj any) {
+	writeJsonStatus(w, code, obj)
+}
+
+// An eventWriter writes server-sent events to the response.
+// The response header is written with the first event,
+// so the handler can still write an error response instead
+// if there were no events yet.
+type eventWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+// newEventWriter creates a new server-sent event writer.
+func newEventWriter(w http.ResponseWriter) *eventWriter {
+	return &eventWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+// Started returns true if any events have been written.
+func (e *eventWriter) Started() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.started
+}
+
+// Send writes an event with JSON-encoded data and flushes the response.
+func (e *eventWriter) Send(event string, obj any) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.started {
+		e.w.Header().Set("content-type", "text/event-stream")
+		e.w.Header().Set("cache-control", "no-cache")
+		e.w.WriteHeader(http.StatusOK)
+		e.started = true
+	}
+	_, err = fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+	return e.rc.Flush()
+}
+
+// Writer returns a writer that sends each chunk
+// written to it as a separate event of the given type.
+func (e *eventWriter) Writer(event string) io.Writer {
+	return eventChunkWriter{e, event}
+}
+
+// An eventChunkWriter sends each chunk as a string event.
+type eventChunkWriter struct {
+	e     *eventWriter
+	event string
+}
+
+// Write implements the io.Writer interface.
+func (w eventChunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err := w.e.Send(w.event, string(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
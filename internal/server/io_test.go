@@ -65,3 +65,25 @@ func Test_writeError(t *testing.T) {
 	be.Equal(t, w.Code, http.StatusForbidden)
 	be.Equal(t, w.Body.String(), `"2020-10-15T00:00:00Z"`)
 }
+
+func Test_eventWriter(t *testing.T) {
+	t.Run("send", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		events := newEventWriter(w)
+		be.Equal(t, events.Started(), false)
+		err := events.Send("done", map[string]bool{"ok": true})
+		be.Err(t, err, nil)
+		be.Equal(t, events.Started(), true)
+		be.Equal(t, w.Code, http.StatusOK)
+		be.Equal(t, w.Header().Get("content-type"), "text/event-stream")
+		be.Equal(t, w.Body.String(), "event: done\ndata: {\"ok\":true}\n\n")
+	})
+	t.Run("writer", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		events := newEventWriter(w)
+		n, err := events.Writer("stdout").Write([]byte("hello\n"))
+		be.Err(t, err, nil)
+		be.Equal(t, n, 6)
+		be.Equal(t, w.Body.String(), "event: stdout\ndata: \"hello\\n\"\n\n")
+	})
+}
@@ -0,0 +1,61 @@
+// Asynchronous code execution jobs.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+// submitJob queues a sandbox command for asynchronous execution
+// and responds with the job ID without waiting for the result.
+func submitJob(w http.ResponseWriter, r *http.Request) {
+	in, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+	job, err := sandbox.SubmitJob(in)
+	if errors.Is(err, sandbox.ErrTooManyJobs) {
+		writeError(w, http.StatusTooManyRequests, engine.Fail(in.ID, err))
+		return
+	}
+	if err != nil {
+		err = engine.NewExecutionError("submit job", err)
+		writeError(w, http.StatusInternalServerError, engine.Fail(in.ID, err))
+		return
+	}
+	logx.Log("→ %s: queued", job.ID)
+	w.Header().Set("location", "/v1/jobs/"+job.ID)
+	writeJsonStatus(w, http.StatusAccepted, job)
+}
+
+// job returns (GET) or cancels (DELETE) an asynchronous job.
+// Only the API key that submitted the job can access it.
+func job(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, engine.Fail(id, err))
+		return
+	}
+	job, err := sandbox.GetJob(id)
+	if err == nil && job.Key != requestKey(r) {
+		// do not reveal that the job exists
+		err = sandbox.ErrUnknownJob
+	}
+	if err == nil && r.Method == http.MethodDelete {
+		job, err = sandbox.CancelJob(id)
+		if err == nil && job.Status == sandbox.JobCanceled {
+			logx.Log("✗ %s: canceled", job.ID)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, engine.Fail(id, err))
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, job)
+}
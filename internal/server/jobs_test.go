@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+func Test_jobs(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	srv := newServer()
+	defer srv.close()
+
+	t.Run("submit and get", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		resp, err := srv.post("/v1/jobs", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusAccepted)
+		job := decodeResp[sandbox.Job](t, resp)
+		be.True(t, job.ID != "")
+		be.Equal(t, job.Status, sandbox.JobQueued)
+		be.Equal(t, resp.Header.Get("location"), "/v1/jobs/"+job.ID)
+
+		for range 100 {
+			resp, err = srv.cli.Get(srv.srv.URL + "/v1/jobs/" + job.ID)
+			be.Err(t, err, nil)
+			be.Equal(t, resp.StatusCode, http.StatusOK)
+			job = decodeResp[sandbox.Job](t, resp)
+			if job.Status == sandbox.JobDone {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		be.Equal(t, job.Status, sandbox.JobDone)
+		be.True(t, job.Result.OK)
+		be.Equal(t, job.Result.Stdout, "hello")
+
+		req, _ := http.NewRequest(http.MethodDelete, srv.srv.URL+"/v1/jobs/"+job.ID, nil)
+		resp, err = srv.cli.Do(req)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		job = decodeResp[sandbox.Job](t, resp)
+		be.Equal(t, job.Status, sandbox.JobDone)
+	})
+	t.Run("error bad request", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files:   nil,
+		}
+		resp, err := srv.post("/v1/jobs", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "empty request")
+	})
+	t.Run("error not found", func(t *testing.T) {
+		resp, err := srv.cli.Get(srv.srv.URL + "/v1/jobs/python_run_42")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "unknown job")
+	})
+	t.Run("error method", func(t *testing.T) {
+		resp, err := srv.post("/v1/jobs/python_run_42", nil)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+	})
+}
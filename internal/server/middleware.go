@@ -1,15 +1,119 @@
 // HTTP middlewares.
 package server
 
-import "net/http"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 
-// enableCORS allows cross-site requests for a given handler.
-func enableCORS(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+var ErrOriginNotAllowed = errors.New("origin not allowed")
+
+// Default CORS settings.
+const (
+	defaultCORSHeaders = "authorization, content-type"
+	defaultCORSMaxAge  = 3600
+)
+
+// A corsPolicy describes which cross-origin requests are allowed.
+type corsPolicy struct {
+	origins     []string // empty = any origin
+	headers     string
+	credentials bool
+	maxAge      string
+}
+
+// cors is the cross-origin request policy.
+var cors, _ = newCORSPolicy(nil)
+
+// newCORSPolicy creates a policy according to the configuration.
+// Allows any origin if the configuration is nil.
+func newCORSPolicy(cfg *config.CORS) (*corsPolicy, error) {
+	p := &corsPolicy{
+		headers: defaultCORSHeaders,
+		maxAge:  strconv.Itoa(defaultCORSMaxAge),
+	}
+	if cfg == nil {
+		return p, nil
+	}
+	for _, origin := range cfg.Origins {
+		origin = strings.ToLower(origin)
+		if err := validateOrigin(origin); err != nil {
+			return nil, err
+		}
+		p.origins = append(p.origins, origin)
+	}
+	if len(cfg.Headers) > 0 {
+		p.headers = strings.ToLower(strings.Join(cfg.Headers, ", "))
+	}
+	if cfg.Credentials && len(p.origins) == 0 {
+		// otherwise any website could make credentialed requests
+		return nil, errors.New("cors: credentials require explicit origins")
+	}
+	p.credentials = cfg.Credentials
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(cfg.MaxAge)
+	}
+	return p, nil
+}
+
+// allowOrigin returns the access-control-allow-origin value
+// for the request origin, or an empty string if it is not allowed.
+func (p *corsPolicy) allowOrigin(origin string) string {
+	if len(p.origins) == 0 {
+		return "*"
+	}
+	if origin == "" {
+		return ""
+	}
+	lower := strings.ToLower(origin)
+	for _, pattern := range p.origins {
+		if matchOrigin(pattern, lower) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// allowed returns true if the request origin (if any) is allowed.
+func (p *corsPolicy) allowed(r *http.Request) bool {
+	origin := r.Header.Get("origin")
+	return origin == "" || p.allowOrigin(origin) != ""
+}
+
+// enableCORS allows cross-site requests for a given handler
+// according to the CORS policy. Rejects requests from
+// disallowed origins. Allows POST requests unless
+// other methods are specified.
+func enableCORS(handler func(w http.ResponseWriter, r *http.Request), methods ...string) func(w http.ResponseWriter, r *http.Request) {
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost}
+	}
+	allowMethods := "options, " + strings.ToLower(strings.Join(methods, ", "))
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("access-control-allow-origin", "*")
-		w.Header().Set("access-control-allow-methods", "options, post")
-		w.Header().Set("access-control-allow-headers", "authorization, content-type")
-		w.Header().Set("access-control-max-age", "3600")
+		policy := cors
+		if len(policy.origins) > 0 {
+			// the response depends on the request origin
+			w.Header().Add("vary", "origin")
+		}
+		if !policy.allowed(r) {
+			writeError(w, http.StatusForbidden, engine.Fail("-", ErrOriginNotAllowed))
+			return
+		}
+		if allowOrigin := policy.allowOrigin(r.Header.Get("origin")); allowOrigin != "" {
+			w.Header().Set("access-control-allow-origin", allowOrigin)
+			w.Header().Set("access-control-allow-methods", allowMethods)
+			w.Header().Set("access-control-allow-headers", policy.headers)
+			w.Header().Set("access-control-max-age", policy.maxAge)
+			if policy.credentials {
+				w.Header().Set("access-control-allow-credentials", "true")
+			}
+		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -17,3 +121,45 @@ func enableCORS(handler func(w http.ResponseWriter, r *http.Request)) func(w htt
 		handler(w, r)
 	}
 }
+
+// checkOrigin rejects requests from disallowed origins for a given handler.
+// Used for WebSocket connections, which are not subject to CORS.
+func checkOrigin(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cors.allowed(r) {
+			writeError(w, http.StatusForbidden, engine.Fail("-", ErrOriginNotAllowed))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// validateOrigin checks if the origin is either exact
+// ("https://example.com") or has a wildcard subdomain
+// ("https://*.example.com").
+func validateOrigin(origin string) error {
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || scheme == "" || host == "" || strings.Contains(host, "/") {
+		return fmt.Errorf("invalid cors origin: %s", origin)
+	}
+	if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+		return fmt.Errorf("invalid cors origin: %s", origin)
+	}
+	return nil
+}
+
+// matchOrigin returns true if the origin matches the pattern.
+// Both must be lowercase.
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	// the wildcard matches one or more subdomains
+	sub, ok := strings.CutPrefix(origin, prefix)
+	if !ok {
+		return false
+	}
+	sub, ok = strings.CutSuffix(sub, suffix)
+	return ok && sub != "" && !strings.ContainsAny(sub, "/:")
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
 )
 
 func Test_enableCORS(t *testing.T) {
@@ -32,4 +34,130 @@ func Test_enableCORS(t *testing.T) {
 		be.Equal(t, w.Header().Get("access-control-max-age"), "3600")
 	})
 
+	t.Run("methods", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/v1/jobs/42", nil)
+		handler := func(w http.ResponseWriter, r *http.Request) {}
+		fn := enableCORS(handler, http.MethodGet, http.MethodDelete)
+		fn(w, r)
+		be.Equal(t, w.Header().Get("access-control-allow-methods"), "options, get, delete")
+	})
+}
+
+func Test_enableCORS_policy(t *testing.T) {
+	policy, err := newCORSPolicy(&config.CORS{
+		Origins:     []string{"https://example.com", "https://*.docs.example.com"},
+		Headers:     []string{"Content-Type", "X-Request-ID"},
+		Credentials: true,
+		MaxAge:      600,
+	})
+	be.Err(t, err, nil)
+	cors = policy
+	defer func() { cors, _ = newCORSPolicy(nil) }()
+
+	var called bool
+	handler := func(w http.ResponseWriter, r *http.Request) { called = true }
+	fn := enableCORS(handler)
+	serve := func(method, origin string) *httptest.ResponseRecorder {
+		called = false
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "/v1/exec", nil)
+		if origin != "" {
+			r.Header.Set("origin", origin)
+		}
+		fn(w, r)
+		return w
+	}
+
+	t.Run("exact origin", func(t *testing.T) {
+		w := serve("OPTIONS", "https://example.com")
+		be.Equal(t, w.Code, 200)
+		be.Equal(t, w.Header().Get("access-control-allow-origin"), "https://example.com")
+		be.Equal(t, w.Header().Get("access-control-allow-headers"), "content-type, x-request-id")
+		be.Equal(t, w.Header().Get("access-control-allow-credentials"), "true")
+		be.Equal(t, w.Header().Get("access-control-max-age"), "600")
+		be.Equal(t, w.Header().Get("vary"), "origin")
+		be.Equal(t, called, false)
+	})
+	t.Run("wildcard origin", func(t *testing.T) {
+		w := serve("POST", "https://go.docs.example.com")
+		be.Equal(t, w.Code, 200)
+		be.Equal(t, w.Header().Get("access-control-allow-origin"), "https://go.docs.example.com")
+		be.True(t, called)
+	})
+	t.Run("disallowed preflight", func(t *testing.T) {
+		w := serve("OPTIONS", "https://docs.example.com")
+		be.Equal(t, w.Code, 403)
+		be.Equal(t, w.Header().Get("access-control-allow-origin"), "")
+		be.True(t, strings.Contains(w.Body.String(), "origin not allowed"))
+	})
+	t.Run("disallowed request", func(t *testing.T) {
+		w := serve("POST", "https://evil.com")
+		be.Equal(t, w.Code, 403)
+		be.Equal(t, called, false)
+	})
+	t.Run("no origin", func(t *testing.T) {
+		w := serve("POST", "")
+		be.Equal(t, w.Code, 200)
+		be.Equal(t, w.Header().Get("access-control-allow-origin"), "")
+		be.True(t, called)
+	})
+}
+
+func Test_checkOrigin(t *testing.T) {
+	cors, _ = newCORSPolicy(&config.CORS{Origins: []string{"https://example.com"}})
+	defer func() { cors, _ = newCORSPolicy(nil) }()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	fn := checkOrigin(handler)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/exec/socket", nil)
+	r.Header.Set("origin", "https://example.com")
+	fn(w, r)
+	be.Equal(t, w.Code, 200)
+
+	w = httptest.NewRecorder()
+	r.Header.Set("origin", "https://evil.com")
+	fn(w, r)
+	be.Equal(t, w.Code, 403)
+}
+
+func Test_newCORSPolicy(t *testing.T) {
+	t.Run("credentials with any origin", func(t *testing.T) {
+		_, err := newCORSPolicy(&config.CORS{Credentials: true})
+		be.Err(t, err)
+	})
+	t.Run("credentials with origins", func(t *testing.T) {
+		p, err := newCORSPolicy(&config.CORS{Origins: []string{"https://example.com"}, Credentials: true})
+		be.Err(t, err, nil)
+		be.Equal(t, p.allowOrigin("https://example.com"), "https://example.com")
+		be.Equal(t, p.allowOrigin("https://evil.com"), "")
+	})
+	t.Run("invalid origin", func(t *testing.T) {
+		for _, origin := range []string{"example.com", "https://", "https://example.com/path", "https://docs.*.com", "https://*.*.com"} {
+			_, err := newCORSPolicy(&config.CORS{Origins: []string{origin}})
+			be.True(t, err != nil)
+		}
+	})
+}
+
+func Test_matchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8080", false},
+		{"https://*.example.com", "https://docs.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://docs.example.com.evil.com", false},
+		{"http://*.localhost:3000", "http://app.localhost:3000", true},
+	}
+	for _, test := range tests {
+		be.Equal(t, matchOrigin(test.pattern, test.origin), test.want)
+	}
 }
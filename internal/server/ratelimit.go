@@ -0,0 +1,243 @@
+// Request rate limiting.
+package server
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+)
+
+// A tokenBucket limits the request rate. Each request takes a token
+// from the bucket, and the bucket refills at a constant rate
+// up to the maximum (burst) number of tokens.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket according to the rate limit.
+func newTokenBucket(limit config.RateLimit) *tokenBucket {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+	return &tokenBucket{
+		rate:   float64(limit.Rate) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// take takes a token from the bucket. If the bucket is empty,
+// returns false and the time until the next token is available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() && now.After(b.last) {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// full returns true if the bucket is full at the given time,
+// so it makes no difference whether to keep it or not.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	elapsed := now.Sub(b.last).Seconds()
+	return b.tokens+elapsed*b.rate >= b.burst
+}
+
+// maxClients is the maximum number of clients
+// a rate limiter keeps track of.
+const maxClients = 10000
+
+// A clientBucket is a client's token bucket
+// in the rate limiter's recency list.
+type clientBucket struct {
+	client string
+	bucket *tokenBucket
+}
+
+// A rateLimiter limits the request rate for each client separately.
+// Clients with full buckets are considered idle and removed.
+// If there are too many active clients, the least recent ones are removed.
+type rateLimiter struct {
+	mu         sync.Mutex
+	limit      config.RateLimit
+	buckets    map[string]*list.Element // client : element of recent
+	recent     *list.List               // clientBucket values, most recent first
+	maxClients int
+	ttl        time.Duration // time to fully refill a bucket
+	purged     time.Time
+}
+
+// newRateLimiter creates a new rate limiter according to the rate limit.
+func newRateLimiter(limit config.RateLimit) *rateLimiter {
+	b := newTokenBucket(limit)
+	return &rateLimiter{
+		limit:      limit,
+		buckets:    map[string]*list.Element{},
+		recent:     list.New(),
+		maxClients: maxClients,
+		ttl:        time.Duration(b.burst / b.rate * float64(time.Second)),
+	}
+}
+
+// take takes a token from the client's bucket. If the bucket is empty,
+// returns false and the time until the next token is available.
+func (l *rateLimiter) take(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	elem, ok := l.buckets[client]
+	if ok {
+		l.recent.MoveToFront(elem)
+	} else {
+		if now.Sub(l.purged) > l.ttl {
+			l.purge(now)
+		}
+		// remove the least recent clients to make room for a new one
+		for len(l.buckets) >= l.maxClients {
+			l.remove(l.recent.Back())
+		}
+		elem = l.recent.PushFront(&clientBucket{client, newTokenBucket(l.limit)})
+		l.buckets[client] = elem
+	}
+	b := elem.Value.(*clientBucket).bucket
+	l.mu.Unlock()
+	return b.take(now)
+}
+
+// purge removes the idle clients. The caller must hold the lock.
+func (l *rateLimiter) purge(now time.Time) {
+	for elem := l.recent.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*clientBucket).bucket.full(now) {
+			l.remove(elem)
+		}
+		elem = next
+	}
+	l.purged = now
+}
+
+// remove removes the client from the limiter. The caller must hold the lock.
+func (l *rateLimiter) remove(elem *list.Element) {
+	cb := l.recent.Remove(elem).(*clientBucket)
+	delete(l.buckets, cb.client)
+}
+
+// limitRate limits the request rate per API key and per client
+// for a given handler. Only used for the handlers that execute code,
+// so that polling the results does not count against the limits.
+func limitRate(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := r.Context().Value(keyCtx{}).(*apiKey); ok && key.bucket != nil {
+			if ok, wait := key.bucket.take(time.Now()); !ok {
+				writeRateLimited(w, "-", wait)
+				return
+			}
+		}
+		if clientLimiter != nil {
+			if ok, wait := clientLimiter.take(clientID(r), time.Now()); !ok {
+				writeRateLimited(w, "-", wait)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// takeSandbox checks the per-client rate limit of the sandbox.
+// If the limit is exceeded, returns false and the time to wait.
+func takeSandbox(r *http.Request, sandbox string) (bool, time.Duration) {
+	limiter := sandboxLimiters[sandbox]
+	if limiter == nil {
+		return true, 0
+	}
+	return limiter.take(clientID(r), time.Now())
+}
+
+// clientID returns the client identifier for rate limiting:
+// the API key name (if any) or the client IP address.
+func clientID(r *http.Request) string {
+	if key, ok := r.Context().Value(keyCtx{}).(*apiKey); ok {
+		return "key:" + key.Name
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the client IP address. If the request comes from
+// a trusted proxy, takes the address from the X-Forwarded-For header,
+// skipping the trusted proxies from right to left.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !isTrustedProxy(addr) {
+		return addr.String()
+	}
+	var forwarded []string
+	for _, header := range r.Header.Values("x-forwarded-for") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = ip.Unmap()
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// isTrustedProxy returns true if the address belongs to a trusted proxy.
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRateLimited writes a rate limit error response.
+func writeRateLimited(w http.ResponseWriter, id string, wait time.Duration) {
+	w.Header().Set("retry-after", retryAfter(wait))
+	writeError(w, http.StatusTooManyRequests, engine.Fail(id, ErrRateLimited))
+}
+
+// retryAfter returns the Retry-After header value
+// (in whole seconds, rounded up) for the wait duration.
+func retryAfter(wait time.Duration) string {
+	secs := int(math.Ceil(wait.Seconds()))
+	return strconv.Itoa(max(secs, 1))
+}
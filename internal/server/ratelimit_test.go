@@ -0,0 +1,200 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Run("burst", func(t *testing.T) {
+		b := newTokenBucket(config.RateLimit{Rate: 60, Burst: 3})
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			ok, _ := b.take(now)
+			be.True(t, ok)
+		}
+		ok, wait := b.take(now)
+		be.Equal(t, ok, false)
+		be.Equal(t, wait, time.Second)
+	})
+	t.Run("refill", func(t *testing.T) {
+		b := newTokenBucket(config.RateLimit{Rate: 60, Burst: 1})
+		now := time.Now()
+		ok, _ := b.take(now)
+		be.True(t, ok)
+		ok, _ = b.take(now.Add(500 * time.Millisecond))
+		be.Equal(t, ok, false)
+		ok, _ = b.take(now.Add(time.Second))
+		be.True(t, ok)
+		// does not exceed the burst
+		ok, _ = b.take(now.Add(time.Hour))
+		be.True(t, ok)
+		ok, _ = b.take(now.Add(time.Hour))
+		be.Equal(t, ok, false)
+	})
+	t.Run("default burst", func(t *testing.T) {
+		b := newTokenBucket(config.RateLimit{Rate: 2})
+		now := time.Now()
+		for i := 0; i < 2; i++ {
+			ok, _ := b.take(now)
+			be.True(t, ok)
+		}
+		ok, wait := b.take(now)
+		be.Equal(t, ok, false)
+		be.Equal(t, wait, 30*time.Second)
+	})
+}
+
+func Test_retryAfter(t *testing.T) {
+	be.Equal(t, retryAfter(0), "1")
+	be.Equal(t, retryAfter(100*time.Millisecond), "1")
+	be.Equal(t, retryAfter(time.Second), "1")
+	be.Equal(t, retryAfter(1500*time.Millisecond), "2")
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("per client", func(t *testing.T) {
+		l := newRateLimiter(config.RateLimit{Rate: 60, Burst: 1})
+		now := time.Now()
+		ok, _ := l.take("alice", now)
+		be.True(t, ok)
+		ok, wait := l.take("alice", now)
+		be.Equal(t, ok, false)
+		be.Equal(t, wait, time.Second)
+		ok, _ = l.take("bob", now)
+		be.True(t, ok)
+	})
+	t.Run("expire idle", func(t *testing.T) {
+		l := newRateLimiter(config.RateLimit{Rate: 60, Burst: 1})
+		now := time.Now()
+		l.take("alice", now)
+		l.take("bob", now.Add(500*time.Millisecond))
+		// alice is idle (her bucket is full), bob is not
+		l.take("carol", now.Add(1200*time.Millisecond))
+		be.Equal(t, len(l.buckets), 2)
+		be.True(t, l.buckets["alice"] == nil)
+		be.True(t, l.buckets["bob"] != nil)
+	})
+	t.Run("max clients", func(t *testing.T) {
+		l := newRateLimiter(config.RateLimit{Rate: 1})
+		l.maxClients = 2
+		now := time.Now()
+		l.take("alice", now)
+		l.take("bob", now.Add(time.Second))
+		l.take("carol", now.Add(2*time.Second))
+		be.Equal(t, len(l.buckets), 2)
+		be.True(t, l.buckets["alice"] == nil)
+		be.True(t, l.buckets["carol"] != nil)
+	})
+	t.Run("least recent", func(t *testing.T) {
+		l := newRateLimiter(config.RateLimit{Rate: 1, Burst: 5})
+		l.maxClients = 2
+		now := time.Now()
+		l.take("alice", now)
+		l.take("bob", now.Add(time.Second))
+		l.take("alice", now.Add(2*time.Second))
+		l.take("carol", now.Add(3*time.Second))
+		be.Equal(t, len(l.buckets), 2)
+		be.Equal(t, l.recent.Len(), 2)
+		be.True(t, l.buckets["alice"] != nil)
+		be.True(t, l.buckets["bob"] == nil)
+		be.True(t, l.buckets["carol"] != nil)
+	})
+}
+
+func Test_clientIP(t *testing.T) {
+	_ = ApplyConfig(&config.Config{TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"}})
+	defer func() { _ = ApplyConfig(cfg) }()
+	tests := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"1.2.3.4:1234", nil, "1.2.3.4"},
+		{"1.2.3.4:1234", []string{"5.6.7.8"}, "1.2.3.4"},
+		{"[::ffff:1.2.3.4]:1234", nil, "1.2.3.4"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"10.0.0.1:1234", []string{"9.9.9.9, 5.6.7.8, 192.168.1.1"}, "5.6.7.8"},
+		{"10.0.0.1:1234", []string{"9.9.9.9", "5.6.7.8"}, "5.6.7.8"},
+		{"10.0.0.1:1234", []string{"192.168.1.1"}, "192.168.1.1"},
+		{"10.0.0.1:1234", []string{"5.6.7.8, garbage"}, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for _, val := range test.forwarded {
+			req.Header.Add("x-forwarded-for", val)
+		}
+		be.Equal(t, clientIP(req), test.want)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	in := engine.Request{
+		Sandbox: "python",
+		Command: "run",
+		Files:   map[string]string{"": "print('hello')"},
+	}
+
+	t.Run("global", func(t *testing.T) {
+		rateCfg := *cfg
+		rateCfg.RateLimit = &config.RateLimit{Rate: 1}
+		_ = ApplyConfig(&rateCfg)
+		defer func() { _ = ApplyConfig(cfg) }()
+		srv := newServer()
+		defer srv.close()
+
+		resp, err := srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_ = resp.Body.Close()
+
+		resp, err = srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusTooManyRequests)
+		be.Equal(t, resp.Header.Get("retry-after"), "60")
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Stderr, "rate limit exceeded")
+	})
+	t.Run("sandbox", func(t *testing.T) {
+		rateCfg := *cfg
+		rateCfg.Sandboxes = map[string]*config.Sandbox{
+			"python": {RateLimit: &config.RateLimit{Rate: 1}},
+		}
+		_ = ApplyConfig(&rateCfg)
+		defer func() { _ = ApplyConfig(cfg) }()
+		srv := newServer()
+		defer srv.close()
+
+		resp, err := srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		_ = resp.Body.Close()
+
+		resp, err = srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusTooManyRequests)
+		out := decodeResp[engine.Execution](t, resp)
+		be.True(t, out.ID != "-")
+		be.Equal(t, out.Stderr, "rate limit exceeded")
+
+		// other sandboxes are not limited
+		resp, err = srv.post("/v1/exec", engine.Request{Sandbox: "alpine", Command: "echo"})
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+		_ = resp.Body.Close()
+	})
+}
@@ -4,11 +4,15 @@ package server
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/http/pprof"
+	"strconv"
+	"strings"
 
 	"github.com/nalgeon/codapi/internal/engine"
 	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/metrics"
 	"github.com/nalgeon/codapi/internal/sandbox"
 	"github.com/nalgeon/codapi/internal/stringx"
 )
@@ -16,7 +20,18 @@ import (
 // NewRouter creates HTTP routes and handlers for them.
 func NewRouter() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/exec", enableCORS(exec))
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /readyz", readyz)
+	mux.HandleFunc("/v1/exec", enableCORS(authorize(limitRate(exec))))
+	mux.HandleFunc("/v1/sandboxes", enableCORS(authorize(sandboxes), http.MethodGet))
+	mux.HandleFunc("/v1/sandboxes/{name}", enableCORS(authorize(sandboxInfo), http.MethodGet))
+	mux.HandleFunc("/v1/exec/stream", enableCORS(authorize(limitRate(execStream))))
+	mux.HandleFunc("/v1/exec/socket", checkOrigin(authorizeSocket(limitRate(execSocket))))
+	mux.HandleFunc("/v1/jobs", enableCORS(authorize(limitRate(submitJob))))
+	mux.HandleFunc("/v1/jobs/{id}", enableCORS(authorize(job), http.MethodGet, http.MethodDelete))
+	mux.HandleFunc("/v1/sessions", enableCORS(authorize(limitRate(openSession))))
+	mux.HandleFunc("/v1/sessions/{id}", enableCORS(authorize(session), http.MethodGet, http.MethodDelete))
+	mux.HandleFunc("/v1/sessions/{id}/exec", enableCORS(authorize(limitRate(execSession))))
 	return mux
 }
 
@@ -31,61 +46,175 @@ func NewDebug() http.Handler {
 	return mux
 }
 
+// NewMetrics creates HTTP routes for metrics
+// and the detailed readiness check.
+func NewMetrics() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("GET /readyz", readyzDetails)
+	return mux
+}
+
 // exec runs a sandbox command on the supplied code.
 func exec(w http.ResponseWriter, r *http.Request) {
+	if acceptsEvents(r) {
+		execStream(w, r)
+		return
+	}
+	in, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+	// execute the code using the sandbox
+	out := sandbox.Exec(in)
+	logResult(in, out)
+	// fail on application error
+	if out.Err != nil {
+		writeExecError(w, out)
+		return
+	}
+	// write the response
+	err := writeJson(w, out)
+	if err != nil {
+		err = engine.NewExecutionError("write response", err)
+		writeError(w, http.StatusInternalServerError, engine.Fail(in.ID, err))
+		return
+	}
+}
+
+// execStream runs a sandbox command on the supplied code
+// and sends the output as server-sent events while it is produced.
+// Stops the execution if the client disconnects.
+// Sends "stdout" and "stderr" events with output chunks,
+// followed by a single "done" event with the execution result.
+func execStream(w http.ResponseWriter, r *http.Request) {
+	in, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+	// execute the code using the sandbox,
+	// sending the output as events
+	events := newEventWriter(w)
+	stream := &engine.Stream{
+		Stdout: events.Writer("stdout"),
+		Stderr: events.Writer("stderr"),
+	}
+	out := sandbox.ExecStream(r.Context(), in, stream)
+	logResult(in, out)
+	// fail on application error
+	if out.Err != nil && !events.Started() {
+		// nothing was sent yet, so we can still
+		// respond with a proper status code
+		writeExecError(w, out)
+		return
+	}
+	// write the final result
+	err := events.Send("done", out)
+	if err != nil {
+		logx.Debug("%s: send done event: %v", in.ID, err)
+	}
+}
+
+// acceptsEvents reports whether the client accepts server-sent events,
+// that is, the accept header lists text/event-stream.
+func acceptsEvents(r *http.Request) bool {
+	for _, header := range r.Header.Values("accept") {
+		for entry := range strings.SplitSeq(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(entry)
+			if err != nil || mediaType != "text/event-stream" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				// explicitly not acceptable
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// readRequest reads and validates the code execution request.
+// Writes an error response and returns false if the request is invalid.
+func readRequest(w http.ResponseWriter, r *http.Request) (engine.Request, bool) {
 	// only POST is allowed
 	if r.Method != http.MethodPost {
 		err := fmt.Errorf("unsupported method: %s", r.Method)
 		writeError(w, http.StatusMethodNotAllowed, engine.Fail("-", err))
-		return
+		return engine.Request{}, false
 	}
-
 	// read the input data - language, command, code
-	in, err := readJson[engine.Request](r)
+	in, size, err := readJsonSize[engine.Request](r)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, engine.Fail("-", err))
-		return
+		writeError(w, requestErrorStatus(err), engine.Fail("-", err))
+		return in, false
 	}
 	in.GenerateID()
-
+	// check if the API key allows the sandbox command
+	err = checkAccess(r, &in)
+	if err != nil {
+		writeError(w, http.StatusForbidden, engine.Fail(in.ID, err))
+		return in, false
+	}
 	// validate the input data
 	err = sandbox.Validate(in)
-	if errors.Is(err, sandbox.ErrUnknownSandbox) || errors.Is(err, sandbox.ErrUnknownCommand) {
-		writeError(w, http.StatusNotFound, engine.Fail(in.ID, err))
-		return
+	if err == nil {
+		err = sandbox.ValidateBody(in, size)
 	}
 	if err != nil {
-		writeError(w, http.StatusBadRequest, engine.Fail(in.ID, err))
-		return
+		writeError(w, requestErrorStatus(err), engine.Fail(in.ID, err))
+		return in, false
 	}
+	// check the sandbox rate limit
+	if ok, wait := takeSandbox(r, in.Sandbox); !ok {
+		writeRateLimited(w, in.ID, wait)
+		return in, false
+	}
+	return in, true
+}
 
-	// execute the code using the sandbox
-	out := sandbox.Exec(in)
-
-	// fail on application error
-	if out.Err != nil {
-		logx.Log("✗ %s: %s", out.ID, out.Err)
-		if errors.Is(out.Err, engine.ErrBusy) {
-			writeError(w, http.StatusTooManyRequests, out)
-		} else {
-			writeError(w, http.StatusInternalServerError, out)
-		}
-		return
+// requestErrorStatus returns the HTTP status code
+// for the request reading or validation error.
+func requestErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sandbox.ErrUnknownSandbox),
+		errors.Is(err, sandbox.ErrUnknownCommand):
+		return http.StatusNotFound
+	case errors.Is(err, sandbox.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
 	}
+}
 
-	// log results
-	if out.OK {
-		logx.Log("✓ %s: took %d ms", out.ID, out.Duration)
+// writeExecError writes an application error response
+// according to the execution error.
+func writeExecError(w http.ResponseWriter, out engine.Execution) {
+	if errors.Is(out.Err, engine.ErrBusy) {
+		writeError(w, http.StatusTooManyRequests, out)
 	} else {
-		msg := stringx.Compact(stringx.Shorten(out.Stderr, 80))
-		logx.Log("✗ %s: %s", out.ID, msg)
+		writeError(w, http.StatusInternalServerError, out)
 	}
+}
 
-	// write the response
-	err = writeJson(w, out)
-	if err != nil {
-		err = engine.NewExecutionError("write response", err)
-		writeError(w, http.StatusInternalServerError, engine.Fail(in.ID, err))
+// logResult logs the code execution results
+// along with the API key name (if any).
+// The JSON log has execution records instead.
+func logResult(in engine.Request, out engine.Execution) {
+	if logx.JSON() {
 		return
 	}
+	id := out.ID
+	if in.Key != "" {
+		id += " [" + in.Key + "]"
+	}
+	switch {
+	case out.Err != nil:
+		logx.Log("✗ %s: %s", id, out.Err)
+	case out.OK:
+		logx.Log("✓ %s: took %d ms", id, out.Duration)
+	default:
+		msg := stringx.Compact(stringx.Shorten(out.Stderr, 80))
+		logx.Log("✗ %s: %s", id, msg)
+	}
 }
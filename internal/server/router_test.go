@@ -3,8 +3,10 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/nalgeon/be"
@@ -17,9 +19,29 @@ import (
 var cfg = &config.Config{
 	PoolSize: 8,
 	Boxes: map[string]*config.Box{
+		"alpine": {},
 		"python": {},
 	},
 	Commands: map[string]config.SandboxCommands{
+		"alpine": map[string]*config.Command{
+			"echo": {
+				Engine: "docker",
+				Before: &config.Step{
+					Box: "alpine", User: "sandbox", Action: "run", Detach: true,
+					Command: []string{"echo", "before"},
+				},
+				Steps: []*config.Step{
+					{
+						Box: ":name", User: "sandbox", Action: "exec",
+						Command: []string{"sh", "main.sh"},
+						NOutput: 4096,
+					},
+				},
+				After: &config.Step{
+					Box: ":name", User: "sandbox", Action: "stop",
+				},
+			},
+		},
 		"python": map[string]*config.Command{
 			"run": {
 				Engine: "docker",
@@ -55,6 +77,17 @@ func (s *server) close() {
 	s.srv.Close()
 }
 
+func TestNewMetrics(t *testing.T) {
+	srv := httptest.NewServer(NewMetrics())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	be.Err(t, err, nil)
+	defer func() { _ = resp.Body.Close() }()
+	be.Equal(t, resp.StatusCode, http.StatusOK)
+	body, _ := io.ReadAll(resp.Body)
+	be.True(t, strings.Contains(string(body), "# TYPE codapi_executions_total counter"))
+}
+
 func Test_exec(t *testing.T) {
 	_ = sandbox.ApplyConfig(cfg)
 	execy.Mock(map[string]execy.CmdOut{
@@ -110,6 +143,139 @@ func Test_exec(t *testing.T) {
 		be.Equal(t, out.Stderr, "empty request")
 		be.Equal(t, out.Err, nil)
 	})
+	t.Run("error input not allowed", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+			Env: map[string]string{"PATH": "/tmp"},
+		}
+		resp, err := srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.OK, false)
+		be.Equal(t, out.Stderr, "env[PATH]: not allowed")
+	})
+	t.Run("error limits", func(t *testing.T) {
+		limCfg := *cfg
+		limCfg.Limits = &config.Limits{NBody: 200, NFiles: 1, NFile: 50}
+		_ = sandbox.ApplyConfig(&limCfg)
+		defer func() { _ = sandbox.ApplyConfig(cfg) }()
+
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files:   map[string]string{"": strings.Repeat("x", 300)},
+		}
+		resp, err := srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusRequestEntityTooLarge)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "body: exceeds nbody limit of 200 bytes")
+
+		in.Files = map[string]string{"": strings.Repeat("x", 60)}
+		resp, err = srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusRequestEntityTooLarge)
+		out = decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "files[]: exceeds nfile limit of 50 bytes")
+
+		in.Files = map[string]string{"a.py": "1", "b.py": "2"}
+		resp, err = srv.post("/v1/exec", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+		out = decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "files: exceeds nfiles limit of 1")
+	})
+}
+
+func Test_execStream(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	srv := newServer()
+	defer srv.close()
+
+	t.Run("success", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		resp, err := srv.post("/v1/exec/stream", in)
+		be.Err(t, err, nil)
+		defer func() { _ = resp.Body.Close() }()
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		be.Equal(t, resp.Header.Get("content-type"), "text/event-stream")
+		body, _ := io.ReadAll(resp.Body)
+		events := string(body)
+		be.True(t, strings.Contains(events, "event: stdout\ndata: \"hello\"\n\n"))
+		be.True(t, strings.Contains(events, "event: done\ndata: {"))
+		be.True(t, strings.Contains(events, `"ok":true`))
+	})
+	t.Run("accept header", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		body, _ := json.Marshal(in)
+		req, _ := http.NewRequest("POST", srv.srv.URL+"/v1/exec", bytes.NewReader(body))
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("accept", "text/event-stream")
+		resp, err := srv.cli.Do(req)
+		be.Err(t, err, nil)
+		defer func() { _ = resp.Body.Close() }()
+		be.Equal(t, resp.Header.Get("content-type"), "text/event-stream")
+	})
+	t.Run("accept multiple", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print('hello')",
+			},
+		}
+		tests := []struct {
+			accept string
+			want   string
+		}{
+			{"text/event-stream, */*", "text/event-stream"},
+			{"application/json;q=0.9, text/event-stream;charset=utf-8", "text/event-stream"},
+			{"text/event-stream;q=0, application/json", "application/json"},
+			{"application/json, */*", "application/json"},
+		}
+		for _, test := range tests {
+			body, _ := json.Marshal(in)
+			req, _ := http.NewRequest("POST", srv.srv.URL+"/v1/exec", bytes.NewReader(body))
+			req.Header.Set("content-type", "application/json")
+			req.Header.Set("accept", test.accept)
+			resp, err := srv.cli.Do(req)
+			be.Err(t, err, nil)
+			_ = resp.Body.Close()
+			be.Equal(t, resp.Header.Get("content-type"), test.want)
+		}
+	})
+	t.Run("error not found", func(t *testing.T) {
+		in := engine.Request{
+			Sandbox: "rust",
+			Command: "run",
+			Files:   nil,
+		}
+		resp, err := srv.post("/v1/exec/stream", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "unknown sandbox")
+	})
 }
 
 func decodeResp[T any](t *testing.T, resp *http.Response) T {
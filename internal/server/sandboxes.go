@@ -0,0 +1,67 @@
+// Sandbox discovery.
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+// sandboxes lists the available sandboxes with their commands.
+func sandboxes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, engine.Fail("-", err))
+		return
+	}
+	key, _ := r.Context().Value(keyCtx{}).(*apiKey)
+	infos := []sandbox.SandboxInfo{}
+	for _, info := range sandbox.Sandboxes() {
+		if info, ok := allowedSandbox(key, info); ok {
+			infos = append(infos, info)
+		}
+	}
+	writeJsonStatus(w, http.StatusOK, infos)
+}
+
+// sandboxInfo returns a single sandbox with its commands.
+func sandboxInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, engine.Fail("-", err))
+		return
+	}
+	name := r.PathValue("name")
+	info, err := sandbox.GetSandbox(name)
+	if err == nil {
+		key, _ := r.Context().Value(keyCtx{}).(*apiKey)
+		var ok bool
+		if info, ok = allowedSandbox(key, info); !ok {
+			// do not reveal that the sandbox exists
+			err = sandbox.ErrUnknownSandbox
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, engine.Fail("-", err))
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, info)
+}
+
+// allowedSandbox returns the sandbox with only the commands
+// the API key is allowed to use, and false if there are none.
+func allowedSandbox(key *apiKey, info sandbox.SandboxInfo) (sandbox.SandboxInfo, bool) {
+	if key == nil {
+		return info, true
+	}
+	cmds := make([]sandbox.CommandInfo, 0, len(info.Commands))
+	for _, cmd := range info.Commands {
+		if key.allows(info.Name, cmd.Name) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	info.Commands = cmds
+	return info, len(cmds) > 0
+}
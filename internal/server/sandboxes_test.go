@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+func Test_sandboxes(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	srv := newServer()
+	defer srv.close()
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := srv.cli.Get(srv.srv.URL + "/v1/sandboxes")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		be.Equal(t, resp.Header.Get("access-control-allow-methods"), "options, get")
+		out := decodeResp[[]sandbox.SandboxInfo](t, resp)
+		be.Equal(t, len(out), len(cfg.Commands))
+		be.Equal(t, out[0].Name, "alpine")
+	})
+	t.Run("get", func(t *testing.T) {
+		resp, err := srv.cli.Get(srv.srv.URL + "/v1/sandboxes/python")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		out := decodeResp[sandbox.SandboxInfo](t, resp)
+		be.Equal(t, out.Name, "python")
+		be.Equal(t, out.Commands[0].Name, "run")
+		be.Equal(t, out.Commands[0].Engine, "docker")
+	})
+	t.Run("not found", func(t *testing.T) {
+		resp, err := srv.cli.Get(srv.srv.URL + "/v1/sandboxes/rust")
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "unknown sandbox")
+	})
+	t.Run("unsupported method", func(t *testing.T) {
+		resp, err := srv.post("/v1/sandboxes", nil)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+	})
+}
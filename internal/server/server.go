@@ -39,7 +39,7 @@ func (s *Server) Start() {
 		defer s.wg.Done()
 		err := s.srv.ListenAndServe()
 		if err != http.ErrServerClosed {
-			logx.Log(err.Error())
+			logx.Error(err.Error())
 		}
 	}()
 }
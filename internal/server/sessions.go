@@ -0,0 +1,165 @@
+// Stateful sessions.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+// openSession starts a new session for a sandbox command.
+func openSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, engine.Fail("-", err))
+		return
+	}
+	in, size, err := readJsonSize[engine.Request](r)
+	if err != nil {
+		writeError(w, requestErrorStatus(err), engine.Fail("-", err))
+		return
+	}
+	in.GenerateID()
+	err = checkAccess(r, &in)
+	if err != nil {
+		writeError(w, http.StatusForbidden, engine.Fail(in.ID, err))
+		return
+	}
+	// only the sandbox, version and command are used,
+	// so there is no code to validate
+	err = sandbox.ValidateCommand(in)
+	if err == nil {
+		err = sandbox.ValidateBody(in, size)
+	}
+	if err != nil {
+		writeError(w, requestErrorStatus(err), engine.Fail(in.ID, err))
+		return
+	}
+	if ok, wait := takeSandbox(r, in.Sandbox); !ok {
+		writeRateLimited(w, in.ID, wait)
+		return
+	}
+	sess, err := sandbox.OpenSession(r.Context(), in)
+	if err != nil {
+		logx.Log("✗ %s: %s", in.ID, err)
+		writeError(w, sessionErrorStatus(err), engine.Fail(in.ID, err))
+		return
+	}
+	logx.Log("→ %s: opened", sess.ID)
+	w.Header().Set("location", "/v1/sessions/"+sess.ID)
+	writeJsonStatus(w, http.StatusCreated, sess)
+}
+
+// session returns (GET) or closes (DELETE) a session.
+// Only the API key that opened the session can access it.
+func session(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, engine.Fail(id, err))
+		return
+	}
+	sess, err := getSession(r, id)
+	if err == nil && r.Method == http.MethodDelete {
+		sess, err = sandbox.CloseSession(id)
+		if err == nil {
+			logx.Log("✗ %s: closed", id)
+		}
+	}
+	if err != nil {
+		writeError(w, sessionErrorStatus(err), engine.Fail(id, err))
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, sess)
+}
+
+// getSession returns the session with the given ID
+// if it was opened with the request's API key.
+func getSession(r *http.Request, id string) (sandbox.Session, error) {
+	sess, err := sandbox.GetSession(id)
+	if err != nil {
+		return sandbox.Session{}, err
+	}
+	if sess.Key != requestKey(r) {
+		// do not reveal that the session exists
+		return sandbox.Session{}, sandbox.ErrUnknownSession
+	}
+	return sess, nil
+}
+
+// execSession runs a sandbox command in a session.
+func execSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, engine.Fail(id, err))
+		return
+	}
+	sess, err := getSession(r, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, engine.Fail(id, err))
+		return
+	}
+	in, size, err := readJsonSize[engine.Request](r)
+	if err != nil {
+		writeError(w, requestErrorStatus(err), engine.Fail(id, err))
+		return
+	}
+	// the sandbox is defined by the session,
+	// the command defaults to the session one
+	in.Sandbox = sess.Sandbox
+	in.Version = sess.Version
+	if in.Command == "" {
+		in.Command = sess.Command
+	}
+	in.GenerateID()
+	err = checkAccess(r, &in)
+	if err != nil {
+		writeError(w, http.StatusForbidden, engine.Fail(in.ID, err))
+		return
+	}
+	err = sandbox.Validate(in)
+	if err == nil {
+		err = sandbox.ValidateBody(in, size)
+	}
+	if err != nil {
+		writeError(w, requestErrorStatus(err), engine.Fail(in.ID, err))
+		return
+	}
+	if ok, wait := takeSandbox(r, in.Sandbox); !ok {
+		writeRateLimited(w, in.ID, wait)
+		return
+	}
+
+	out := sandbox.ExecSession(r.Context(), id, in, nil)
+	logResult(in, out)
+	if out.Err != nil {
+		writeExecError(w, out)
+		return
+	}
+	err = writeJson(w, out)
+	if err != nil {
+		logx.Debug("%s: write response: %v", in.ID, err)
+	}
+}
+
+// sessionErrorStatus returns the HTTP status code for the session error.
+func sessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sandbox.ErrUnknownSandbox),
+		errors.Is(err, sandbox.ErrUnknownCommand),
+		errors.Is(err, sandbox.ErrUnknownSession):
+		return http.StatusNotFound
+	case errors.Is(err, engine.ErrNoSessions):
+		return http.StatusBadRequest
+	case errors.Is(err, sandbox.ErrTooManySessions),
+		errors.Is(err, engine.ErrBusy):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
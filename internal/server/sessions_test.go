@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/sandbox"
+)
+
+func Test_sessions(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run":  {Stdout: "c958ff2"},
+		"docker exec": {Stdout: "hello"},
+	})
+	srv := newServer()
+	defer srv.close()
+	defer sandbox.CloseSessions()
+
+	t.Run("lifecycle", func(t *testing.T) {
+		in := engine.Request{Sandbox: "alpine", Command: "echo"}
+		resp, err := srv.post("/v1/sessions", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusCreated)
+		sess := decodeResp[sandbox.Session](t, resp)
+		be.True(t, sess.ID != "")
+		be.Equal(t, resp.Header.Get("location"), "/v1/sessions/"+sess.ID)
+
+		resp, err = srv.cli.Get(srv.srv.URL + "/v1/sessions/" + sess.ID)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		got := decodeResp[sandbox.Session](t, resp)
+		be.Equal(t, got.ID, sess.ID)
+		be.Equal(t, got.Command, "echo")
+
+		req := engine.Request{
+			Files: map[string]string{
+				"": "echo hello",
+			},
+		}
+		resp, err = srv.post("/v1/sessions/"+sess.ID+"/exec", req)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+		out := decodeResp[engine.Execution](t, resp)
+		be.True(t, out.OK)
+		be.Equal(t, out.Stdout, "hello")
+
+		del, _ := http.NewRequest(http.MethodDelete, srv.srv.URL+"/v1/sessions/"+sess.ID, nil)
+		resp, err = srv.cli.Do(del)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusOK)
+
+		resp, err = srv.cli.Get(srv.srv.URL + "/v1/sessions/" + sess.ID)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+	})
+	t.Run("not supported", func(t *testing.T) {
+		in := engine.Request{Sandbox: "python", Command: "run"}
+		resp, err := srv.post("/v1/sessions", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	})
+	t.Run("unknown sandbox", func(t *testing.T) {
+		in := engine.Request{Sandbox: "rust", Command: "run"}
+		resp, err := srv.post("/v1/sessions", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.True(t, strings.HasPrefix(out.ID, "rust_run_"))
+		be.Equal(t, out.Stderr, "unknown sandbox")
+	})
+	t.Run("unknown command", func(t *testing.T) {
+		in := engine.Request{Sandbox: "alpine", Command: "missing"}
+		resp, err := srv.post("/v1/sessions", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+		out := decodeResp[engine.Execution](t, resp)
+		be.Equal(t, out.Stderr, "unknown command")
+	})
+	t.Run("too large", func(t *testing.T) {
+		limCfg := *cfg
+		limCfg.Limits = &config.Limits{NBody: 100}
+		_ = sandbox.ApplyConfig(&limCfg)
+		defer func() { _ = sandbox.ApplyConfig(cfg) }()
+
+		in := engine.Request{Sandbox: "alpine", Command: "echo", Stdin: strings.Repeat("x", 200)}
+		resp, err := srv.post("/v1/sessions", in)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusRequestEntityTooLarge)
+	})
+	t.Run("unknown session", func(t *testing.T) {
+		req := engine.Request{
+			Files: map[string]string{
+				"": "echo hello",
+			},
+		}
+		resp, err := srv.post("/v1/sessions/alpine_session_42/exec", req)
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusNotFound)
+	})
+	t.Run("empty request", func(t *testing.T) {
+		in := engine.Request{Sandbox: "alpine", Command: "echo"}
+		resp, _ := srv.post("/v1/sessions", in)
+		sess := decodeResp[sandbox.Session](t, resp)
+		resp, err := srv.post("/v1/sessions/"+sess.ID+"/exec", engine.Request{})
+		be.Err(t, err, nil)
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,147 @@
+// Interactive code execution over WebSocket.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/logx"
+	"github.com/nalgeon/codapi/internal/sandbox"
+	"github.com/nalgeon/codapi/internal/websocket"
+)
+
+// Socket message types.
+const (
+	msgStdin  = "stdin"
+	msgEOF    = "eof"
+	msgStdout = "stdout"
+	msgStderr = "stderr"
+	msgDone   = "done"
+)
+
+// A socketMessage is a message exchanged with the client
+// over the WebSocket connection.
+type socketMessage struct {
+	Type   string            `json:"type"`
+	Data   string            `json:"data,omitempty"`
+	Result *engine.Execution `json:"result,omitempty"`
+}
+
+// execSocket runs a sandbox command interactively over WebSocket.
+// The first client message is the execution request. After that, the client
+// sends "stdin" messages with the program input, and (optionally) an "eof"
+// message to close the input. The server sends "stdout" and "stderr" messages
+// with output chunks, followed by a single "done" message with the result.
+func execSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		logx.Debug("websocket upgrade: %v", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// the first message is the execution request,
+	// which can be as large as the request body
+	var in engine.Request
+	conn.SetMaxMessageSize(int64(sandbox.MaxBody()))
+	_, msg, err := conn.ReadMessage()
+	conn.SetMaxMessageSize(websocket.MaxMessageSize)
+	if err == nil {
+		err = json.Unmarshal(msg, &in)
+	}
+	if err != nil {
+		out := engine.Fail("-", err)
+		_ = conn.WriteJSON(socketMessage{Type: msgDone, Result: &out})
+		return
+	}
+	in.GenerateID()
+	err = checkAccess(r, &in)
+	if err == nil {
+		err = sandbox.Validate(in)
+	}
+	if err == nil {
+		err = sandbox.ValidateBody(in, len(msg))
+	}
+	if err == nil {
+		if ok, _ := takeSandbox(r, in.Sandbox); !ok {
+			err = ErrRateLimited
+		}
+	}
+	if err != nil {
+		out := engine.Fail(in.ID, err)
+		_ = conn.WriteJSON(socketMessage{Type: msgDone, Result: &out})
+		return
+	}
+
+	// relay the client input to the program,
+	// and stop the execution if the client disconnects
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stdin, stdinw := io.Pipe()
+	defer func() { _ = stdin.Close() }()
+	go func() {
+		err := relayStdin(conn, stdinw)
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	// execute the code using the sandbox
+	stream := &engine.Stream{
+		Stdin:  stdin,
+		Stdout: socketWriter{conn, msgStdout},
+		Stderr: socketWriter{conn, msgStderr},
+	}
+	out := sandbox.ExecStream(ctx, in, stream)
+	logResult(in, out)
+	err = conn.WriteJSON(socketMessage{Type: msgDone, Result: &out})
+	if err != nil {
+		logx.Debug("%s: send done message: %v", in.ID, err)
+	}
+}
+
+// relayStdin reads the client messages and writes the input
+// to the pipe until the program stops accepting input
+// (returns nil) or the connection is closed (returns an error).
+func relayStdin(conn *websocket.Conn, w *io.PipeWriter) error {
+	for {
+		var msg socketMessage
+		err := conn.ReadJSON(&msg)
+		if err != nil {
+			_ = w.Close()
+			return err
+		}
+		switch msg.Type {
+		case msgStdin:
+			_, err = io.WriteString(w, msg.Data)
+			if err != nil {
+				// the program no longer accepts input
+				return nil
+			}
+		case msgEOF:
+			_ = w.Close()
+		}
+	}
+}
+
+// A socketWriter sends each chunk written to it
+// as a message of the given type.
+type socketWriter struct {
+	conn    *websocket.Conn
+	msgType string
+}
+
+// Write implements the io.Writer interface.
+func (w socketWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err := w.conn.WriteJSON(socketMessage{Type: w.msgType, Data: string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"github.com/nalgeon/codapi/internal/config"
+	"github.com/nalgeon/codapi/internal/engine"
+	"github.com/nalgeon/codapi/internal/execy"
+	"github.com/nalgeon/codapi/internal/sandbox"
+	"github.com/nalgeon/codapi/internal/websocket"
+)
+
+func Test_execSocket(t *testing.T) {
+	_ = sandbox.ApplyConfig(cfg)
+	execy.Mock(map[string]execy.CmdOut{
+		"docker run": {Stdout: "hello"},
+	})
+	srv := newServer()
+	defer srv.close()
+	url := "ws" + strings.TrimPrefix(srv.srv.URL, "http") + "/v1/exec/socket"
+
+	t.Run("success", func(t *testing.T) {
+		conn, err := websocket.Dial(url)
+		be.Err(t, err, nil)
+		defer func() { _ = conn.Close() }()
+
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files: map[string]string{
+				"": "print(input())",
+			},
+		}
+		err = conn.WriteJSON(in)
+		be.Err(t, err, nil)
+		err = conn.WriteJSON(socketMessage{Type: msgStdin, Data: "hello\n"})
+		be.Err(t, err, nil)
+
+		var msg socketMessage
+		err = conn.ReadJSON(&msg)
+		be.Err(t, err, nil)
+		be.Equal(t, msg.Type, msgStdout)
+		be.Equal(t, msg.Data, "hello")
+
+		err = conn.ReadJSON(&msg)
+		be.Err(t, err, nil)
+		be.Equal(t, msg.Type, msgDone)
+		be.True(t, msg.Result.OK)
+		be.Equal(t, msg.Result.Stdout, "hello")
+	})
+	t.Run("error not found", func(t *testing.T) {
+		conn, err := websocket.Dial(url)
+		be.Err(t, err, nil)
+		defer func() { _ = conn.Close() }()
+
+		in := engine.Request{
+			Sandbox: "rust",
+			Command: "run",
+		}
+		err = conn.WriteJSON(in)
+		be.Err(t, err, nil)
+
+		var msg socketMessage
+		err = conn.ReadJSON(&msg)
+		be.Err(t, err, nil)
+		be.Equal(t, msg.Type, msgDone)
+		be.Equal(t, msg.Result.OK, false)
+		be.Equal(t, msg.Result.Stderr, "unknown sandbox")
+	})
+	t.Run("error nbody", func(t *testing.T) {
+		run := *cfg.Commands["python"]["run"]
+		run.Limits = &config.Limits{NBody: 200}
+		limCfg := *cfg
+		limCfg.Limits = &config.Limits{NBody: 10000}
+		limCfg.Commands = map[string]config.SandboxCommands{
+			"python": map[string]*config.Command{"run": &run},
+		}
+		_ = sandbox.ApplyConfig(&limCfg)
+		defer func() { _ = sandbox.ApplyConfig(cfg) }()
+
+		conn, err := websocket.Dial(url)
+		be.Err(t, err, nil)
+		defer func() { _ = conn.Close() }()
+
+		in := engine.Request{
+			Sandbox: "python",
+			Command: "run",
+			Files:   map[string]string{"": strings.Repeat("x", 300)},
+		}
+		err = conn.WriteJSON(in)
+		be.Err(t, err, nil)
+
+		var msg socketMessage
+		err = conn.ReadJSON(&msg)
+		be.Err(t, err, nil)
+		be.Equal(t, msg.Type, msgDone)
+		be.Equal(t, msg.Result.OK, false)
+		be.Equal(t, msg.Result.Stderr, "body: exceeds nbody limit of 200 bytes")
+	})
+}
+
+func Test_relayStdin(t *testing.T) {
+	stdin, stdinw := io.Pipe()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_ = relayStdin(conn, stdinw)
+	}))
+	defer srv.Close()
+
+	conn, err := websocket.Dial("ws" + strings.TrimPrefix(srv.URL, "http"))
+	be.Err(t, err, nil)
+	defer func() { _ = conn.Close() }()
+	_ = conn.WriteJSON(socketMessage{Type: msgStdin, Data: "hello "})
+	_ = conn.WriteJSON(socketMessage{Type: msgStdin, Data: "world"})
+	_ = conn.WriteJSON(socketMessage{Type: msgEOF})
+
+	data, err := io.ReadAll(stdin)
+	be.Err(t, err, nil)
+	be.Equal(t, string(data), "hello world")
+}
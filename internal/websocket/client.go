@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Dial opens a client WebSocket connection to the ws:// URL.
+// Only supports plain (non-TLS) connections,
+// which is enough for testing and local use.
+func Dial(rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+u.Host+u.RequestURI(), nil)
+	req.Header.Set("connection", "Upgrade")
+	req.Header.Set("upgrade", "websocket")
+	req.Header.Set("sec-websocket-version", "13")
+	req.Header.Set("sec-websocket-key", key)
+	err = req.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, fmt.Errorf("handshake failed: %s", resp.Status)
+	}
+	if resp.Header.Get("sec-websocket-accept") != acceptKey(key) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("handshake failed: invalid accept key")
+	}
+	return newConn(conn, br, true), nil
+}
@@ -0,0 +1,23 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestDial(t *testing.T) {
+	t.Run("unsupported scheme", func(t *testing.T) {
+		_, err := Dial("wss://localhost/ws")
+		be.Err(t, err, "unsupported scheme: wss")
+	})
+	t.Run("handshake failed", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		_, err := Dial("ws" + strings.TrimPrefix(srv.URL, "http"))
+		be.Err(t, err, "handshake failed: 404 Not Found")
+	})
+}
@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Upgrade upgrades the HTTP server connection to the WebSocket protocol.
+// On failure, writes an HTTP error response and returns the error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		err := fmt.Errorf("unsupported method: %s", r.Method)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return nil, err
+	}
+	if !headerContains(r.Header, "connection", "upgrade") ||
+		!headerContains(r.Header, "upgrade", "websocket") {
+		err := errors.New("not a websocket handshake")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	if r.Header.Get("sec-websocket-version") != "13" {
+		err := errors.New("unsupported websocket version")
+		w.Header().Set("sec-websocket-version", "13")
+		http.Error(w, err.Error(), http.StatusUpgradeRequired)
+		return nil, err
+	}
+	key := r.Header.Get("sec-websocket-key")
+	if key == "" {
+		err := errors.New("missing websocket key")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	_, err = conn.Write([]byte(resp))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// headerContains checks if the comma-separated header
+// contains the token (case-insensitive).
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+func TestUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(msgType, data)
+	}))
+	defer srv.Close()
+
+	t.Run("echo", func(t *testing.T) {
+		conn, err := Dial("ws" + strings.TrimPrefix(srv.URL, "http"))
+		be.Err(t, err, nil)
+		defer func() { _ = conn.Close() }()
+		err = conn.WriteMessage(TextMessage, []byte("hello"))
+		be.Err(t, err, nil)
+		_, data, err := conn.ReadMessage()
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), "hello")
+	})
+	t.Run("not websocket", func(t *testing.T) {
+		resp, err := http.Get(srv.URL)
+		be.Err(t, err, nil)
+		defer func() { _ = resp.Body.Close() }()
+		be.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	})
+	t.Run("unsupported method", func(t *testing.T) {
+		resp, err := http.Post(srv.URL, "text/plain", nil)
+		be.Err(t, err, nil)
+		defer func() { _ = resp.Body.Close() }()
+		be.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+	})
+	t.Run("unsupported version", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("connection", "upgrade")
+		req.Header.Set("upgrade", "websocket")
+		req.Header.Set("sec-websocket-version", "8")
+		req.Header.Set("sec-websocket-key", "dGhlIHNhbXBsZSBub25jZQ==")
+		resp, err := http.DefaultClient.Do(req)
+		be.Err(t, err, nil)
+		defer func() { _ = resp.Body.Close() }()
+		be.Equal(t, resp.StatusCode, http.StatusUpgradeRequired)
+	})
+}
+
+func Test_headerContains(t *testing.T) {
+	header := http.Header{}
+	header.Set("connection", "keep-alive, Upgrade")
+	be.True(t, headerContains(header, "connection", "upgrade"))
+	be.True(t, !headerContains(header, "connection", "close"))
+}
@@ -0,0 +1,270 @@
+// Package websocket implements a minimal subset
+// of the WebSocket protocol (RFC 6455).
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// The WebSocket protocol GUID used to compute the accept key.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Message types.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// MaxMessageSize is the default maximum size of a received message.
+const MaxMessageSize = 64 * 1024
+
+var (
+	ErrClosed          = errors.New("websocket: connection closed")
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	ErrProtocol        = errors.New("websocket: protocol error")
+)
+
+// A Conn is a WebSocket connection.
+// Writes are concurrent-safe, but reads are not,
+// so there should be only one reading goroutine.
+type Conn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	client  bool
+	maxSize int64
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+// newConn creates a new WebSocket connection
+// over an established network connection.
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, client: client, maxSize: MaxMessageSize}
+}
+
+// SetMaxMessageSize sets the maximum size of a received message.
+func (c *Conn) SetMaxMessageSize(n int64) {
+	c.maxSize = n
+}
+
+// ReadMessage reads the next text or binary message.
+// Answers pings and handles close frames transparently.
+// Returns io.EOF when the peer closes the connection.
+func (c *Conn) ReadMessage() (msgType int, data []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case opPing:
+			err = c.writeFrame(opPong, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			_ = c.writeFrame(opClose, payload)
+			_ = c.conn.Close()
+			return 0, nil, io.EOF
+		case opText, opBinary:
+			if msgType != 0 {
+				// a new message before the previous one is complete
+				return 0, nil, ErrProtocol
+			}
+			msgType = int(opcode)
+		case opContinuation:
+			if msgType == 0 {
+				return 0, nil, ErrProtocol
+			}
+		default:
+			return 0, nil, ErrProtocol
+		}
+		if int64(len(data)+len(payload)) > c.maxSize {
+			_ = c.closeWith(1009)
+			return 0, nil, ErrMessageTooLarge
+		}
+		data = append(data, payload...)
+		if fin {
+			return msgType, data, nil
+		}
+	}
+}
+
+// ReadJSON reads the next message and decodes it from JSON.
+func (c *Conn) ReadJSON(obj any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// WriteMessage writes a message of the given type.
+func (c *Conn) WriteMessage(msgType int, data []byte) error {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return ErrProtocol
+	}
+	return c.writeFrame(byte(msgType), data)
+}
+
+// WriteJSON encodes an object into JSON and writes it as a text message.
+func (c *Conn) WriteJSON(obj any) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// Close sends a normal closure frame and closes the connection.
+func (c *Conn) Close() error {
+	return c.closeWith(1000)
+}
+
+// closeWith sends a close frame with the status code
+// and closes the connection.
+func (c *Conn) closeWith(code uint16) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	_ = c.writeFrame(opClose, payload)
+	c.wmu.Lock()
+	c.closed = true
+	c.wmu.Unlock()
+	return c.conn.Close()
+}
+
+// readFrame reads a single frame from the connection.
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	_, err = io.ReadFull(c.br, head[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		// clients must mask the frames, servers must not
+		return false, 0, nil, ErrProtocol
+	}
+
+	size := int64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		size = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		size = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if size < 0 || size > c.maxSize {
+		_ = c.closeWith(1009)
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.br, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, size)
+	_, err = io.ReadFull(c.br, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a single final frame to the connection.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	frame := []byte{0x80 | opcode}
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	size := len(payload)
+	switch {
+	case size < 126:
+		frame = append(frame, maskBit|byte(size))
+	case size <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	}
+
+	if c.client {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		masked := make([]byte, size)
+		copy(masked, payload)
+		maskBytes(mask, masked)
+		payload = masked
+	}
+
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	if opcode == opClose {
+		c.closed = true
+	}
+	return err
+}
+
+// maskBytes applies the masking key to the data in place.
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value
+// for the given Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
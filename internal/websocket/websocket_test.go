@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+)
+
+// pipeConns creates a connected pair of client and server connections.
+func pipeConns() (client *Conn, server *Conn) {
+	c1, c2 := net.Pipe()
+	return newConn(c1, nil, true), newConn(c2, nil, false)
+}
+
+func TestConn_Message(t *testing.T) {
+	t.Run("client to server", func(t *testing.T) {
+		client, server := pipeConns()
+		go func() { _ = client.WriteMessage(TextMessage, []byte("hello")) }()
+		msgType, data, err := server.ReadMessage()
+		be.Err(t, err, nil)
+		be.Equal(t, msgType, TextMessage)
+		be.Equal(t, string(data), "hello")
+	})
+	t.Run("server to client", func(t *testing.T) {
+		client, server := pipeConns()
+		go func() { _ = server.WriteMessage(BinaryMessage, []byte{1, 2, 3}) }()
+		msgType, data, err := client.ReadMessage()
+		be.Err(t, err, nil)
+		be.Equal(t, msgType, BinaryMessage)
+		be.Equal(t, data, []byte{1, 2, 3})
+	})
+	t.Run("large", func(t *testing.T) {
+		client, server := pipeConns()
+		want := strings.Repeat("a", 70000)
+		go func() { _ = client.WriteMessage(TextMessage, []byte(want)) }()
+		server.SetMaxMessageSize(100000)
+		_, data, err := server.ReadMessage()
+		be.Err(t, err, nil)
+		be.Equal(t, string(data), want)
+	})
+	t.Run("too large", func(t *testing.T) {
+		client, server := pipeConns()
+		go func() {
+			_ = client.WriteMessage(TextMessage, []byte("hello"))
+			_, _, _ = client.ReadMessage()
+		}()
+		server.SetMaxMessageSize(3)
+		_, _, err := server.ReadMessage()
+		be.Err(t, err, ErrMessageTooLarge)
+	})
+	t.Run("unmasked", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		client, server := newConn(c1, nil, false), newConn(c2, nil, false)
+		go func() { _ = client.WriteMessage(TextMessage, []byte("hello")) }()
+		_, _, err := server.ReadMessage()
+		be.Err(t, err, ErrProtocol)
+	})
+}
+
+func TestConn_JSON(t *testing.T) {
+	client, server := pipeConns()
+	go func() { _ = client.WriteJSON(map[string]string{"type": "stdin"}) }()
+	var msg map[string]string
+	err := server.ReadJSON(&msg)
+	be.Err(t, err, nil)
+	be.Equal(t, msg["type"], "stdin")
+}
+
+func TestConn_Ping(t *testing.T) {
+	client, server := pipeConns()
+	go func() {
+		_ = client.writeFrame(opPing, []byte("ping"))
+		_ = client.WriteMessage(TextMessage, []byte("hello"))
+	}()
+	go func() {
+		// receive the pong
+		_, _, _, _ = client.readFrame()
+	}()
+	_, data, err := server.ReadMessage()
+	be.Err(t, err, nil)
+	be.Equal(t, string(data), "hello")
+}
+
+func TestConn_Close(t *testing.T) {
+	client, server := pipeConns()
+	go func() { _ = client.Close() }()
+	_, _, err := server.ReadMessage()
+	be.Err(t, err, io.EOF)
+	err = server.WriteMessage(TextMessage, []byte("hello"))
+	be.Err(t, err, ErrClosed)
+}
+
+func Test_acceptKey(t *testing.T) {
+	// example from RFC 6455
+	got := acceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	be.Equal(t, got, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}